@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mallfin_api/config"
+	"mallfin_api/logging"
+)
+
+const (
+	// entries are hashes of the response body and content type
+	keyPrefix     = "cache:response:"
+	tagPrefix     = "cache:tag:"
+	versionPrefix = "cache:version:"
+	lockPrefix    = "cache:lock:"
+	paramMark     = ":"
+
+	// the open status of malls is computed at the request time, it may go stale for this long at most
+	maxOpenStatusTTL = time.Minute
+)
+
+var (
+	// responses to requests with these params depend on the current time
+	volatileParams = []string{"open_now"}
+	// the order of the values of these params does not change the response
+	setParams = map[string]bool{"shops": true}
+	// the responses of these routes embed is_open, opens_at and closes_at
+	openStatusRoutes = map[string]bool{
+		"/malls/":        true,
+		"/malls/:id/":    true,
+		"/current_mall/": true,
+		"/shops/":        true,
+		"/shops/:id/":    true,
+		"/search/":       true,
+		"/plan/":         true,
+	}
+
+	routes  []*route
+	maxTTL  time.Duration
+	storage Storage
+	logger  = logging.WithPackage("cache")
+	once    sync.Once
+)
+
+// Storage keeps the cached responses with the sets of their keys by tag.
+type Storage interface {
+	Get(ctx context.Context, key string) (*Response, error)
+	// Set does not store the response if one of the tags has been invalidated since the versions were taken.
+	Set(ctx context.Context, key string, response *Response, ttl time.Duration, tags []string, versions []int64) (bool, error)
+	Versions(ctx context.Context, tags []string) ([]int64, error)
+	// Invalidate deletes the entries of the tag and bumps its version.
+	Invalidate(ctx context.Context, tag string) (int, error)
+	Mutex(key string) Mutex
+}
+
+type Mutex interface {
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+type route struct {
+	template string
+	segments []string
+	ttl      time.Duration
+}
+
+type Response struct {
+	ContentType string
+	Body        []byte
+}
+
+type Entry struct {
+	Key  string
+	TTL  time.Duration
+	Tags []string
+}
+
+func Initialization(cacheStorage Storage) {
+	once.Do(func() {
+		conf := config.Cache()
+		if conf == nil || !conf.Enabled {
+			return
+		}
+		storage = cacheStorage
+		for template, ttl := range conf.Routes {
+			r := &route{template: template, segments: splitPath(template), ttl: time.Duration(ttl) * time.Second}
+			if openStatusRoutes[template] && r.ttl > maxOpenStatusTTL {
+				logger.WithField("route", template).Warnf("Route embeds the open status of malls, ttl is cut to %s", maxOpenStatusTTL)
+				r.ttl = maxOpenStatusTTL
+			}
+			routes = append(routes, r)
+			if r.ttl > maxTTL {
+				maxTTL = r.ttl
+			}
+		}
+		sort.Slice(routes, func(i, j int) bool {
+			pi, pj := routes[i].paramsCount(), routes[j].paramsCount()
+			if pi != pj {
+				return pi < pj
+			}
+			return routes[i].template < routes[j].template
+		})
+	})
+}
+
+func EntityTag(collection string, id interface{}) string {
+	return fmt.Sprintf("%s:%v", collection, id)
+}
+
+func DetailsTag(collection string) string {
+	return collection + ":*"
+}
+
+func Lookup(path string, query url.Values) *Entry {
+	for _, param := range volatileParams {
+		if query.Get(param) != "" {
+			return nil
+		}
+	}
+	segments := splitPath(path)
+	for _, r := range routes {
+		params, ok := r.match(segments)
+		if !ok || r.ttl <= 0 {
+			continue
+		}
+		collection := segments[0]
+		tags := []string{collection}
+		if len(params) != 0 {
+			tags = []string{EntityTag(collection, strings.Join(params, "/")), DetailsTag(collection)}
+		}
+		key := keyPrefix + "/" + strings.Join(segments, "/") + "/?" + encodeQuery(query)
+		return &Entry{Key: key, TTL: r.ttl, Tags: tags}
+	}
+	return nil
+}
+
+func (e *Entry) Get(ctx context.Context) (*Response, error) {
+	return storage.Get(ctx, e.Key)
+}
+
+// Versions must be taken before the response is built, Set compares them with the current ones.
+func (e *Entry) Versions(ctx context.Context) ([]int64, error) {
+	return storage.Versions(ctx, e.Tags)
+}
+
+func (e *Entry) Set(ctx context.Context, response *Response, versions []int64) error {
+	stored, err := storage.Set(ctx, e.Key, response, e.TTL, e.Tags, versions)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		logger.WithField("key", e.Key).Debug("Response went stale during the request, not cached")
+	}
+	return nil
+}
+
+func (e *Entry) Mutex() Mutex {
+	return storage.Mutex(lockPrefix + e.Key)
+}
+
+func Invalidate(ctx context.Context, tags ...string) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	for _, tag := range tags {
+		count, err := storage.Invalidate(ctx, tag)
+		if err != nil {
+			return err
+		}
+		logger.WithField("tag", tag).Debugf("Invalidated %d cache entries", count)
+	}
+	return nil
+}
+
+func (r *route) paramsCount() int {
+	count := 0
+	for _, segment := range r.segments {
+		if strings.HasPrefix(segment, paramMark) {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *route) match(segments []string) ([]string, bool) {
+	if len(segments) != len(r.segments) || len(segments) == 0 {
+		return nil, false
+	}
+	var params []string
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, paramMark) {
+			params = append(params, segments[i])
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func encodeQuery(query url.Values) string {
+	sorted := make(url.Values, len(query))
+	for param, values := range query {
+		if setParams[param] {
+			values = append([]string{}, values...)
+			sort.Strings(values)
+		}
+		sorted[param] = values
+	}
+	return sorted.Encode()
+}
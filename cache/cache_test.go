@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func useTestRoutes(t *testing.T, templates ...string) {
+	previousRoutes, previousStorage := routes, storage
+	routes = nil
+	for _, template := range templates {
+		routes = append(routes, &route{template: template, segments: splitPath(template), ttl: time.Minute})
+	}
+	storage = NewMemoryStorage()
+	t.Cleanup(func() {
+		routes, storage = previousRoutes, previousStorage
+	})
+}
+
+func lookup(t *testing.T, target string) *Entry {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Lookup(u.Path, u.Query())
+	if entry == nil {
+		t.Fatalf("No cache entry for %s", target)
+	}
+	return entry
+}
+
+func TestLookupKey(t *testing.T) {
+	useTestRoutes(t, "/malls/", "/search/")
+	for _, c := range []struct {
+		target, other string
+		same          bool
+	}{
+		{"/search/?shops=1&shops=2&city=1", "/search/?city=1&shops=2&shops=1", true},
+		{"/search/?shops=1&shops=2", "/search/?shops=1&shops=3", false},
+		// only the first sort value is used
+		{"/malls/?sort=name&sort=-name", "/malls/?sort=-name&sort=name", false},
+		{"/malls/?city=1", "/search/?city=1", false},
+	} {
+		key, otherKey := lookup(t, c.target).Key, lookup(t, c.other).Key
+		if (key == otherKey) != c.same {
+			t.Errorf("Keys of %s and %s: %s and %s, expected same %t", c.target, c.other, key, otherKey, c.same)
+		}
+	}
+	if entry := Lookup("/malls/", url.Values{"open_now": {"true"}}); entry != nil {
+		t.Errorf("Cache entry %s for the open_now request", entry.Key)
+	}
+	if entry := Lookup("/shops/", nil); entry != nil {
+		t.Errorf("Cache entry %s for the route without ttl", entry.Key)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	useTestRoutes(t, "/malls/", "/malls/:id/")
+	ctx := context.Background()
+	response := &Response{ContentType: "application/json", Body: []byte(`{}`)}
+	list, details, otherDetails := lookup(t, "/malls/"), lookup(t, "/malls/1/"), lookup(t, "/malls/2/")
+	for _, entry := range []*Entry{list, details, otherDetails} {
+		versions, err := entry.Versions(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = entry.Set(ctx, response, versions)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := Invalidate(ctx, EntityTag("malls", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		entry  *Entry
+		cached bool
+	}{{list, true}, {details, false}, {otherDetails, true}} {
+		cached, err := c.entry.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (cached != nil) != c.cached {
+			t.Errorf("Entry %s cached %t, expected %t", c.entry.Key, cached != nil, c.cached)
+		}
+	}
+}
+
+func TestSetAfterInvalidate(t *testing.T) {
+	useTestRoutes(t, "/malls/:id/")
+	ctx := context.Background()
+	entry := lookup(t, "/malls/1/")
+	versions, err := entry.Versions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mall is updated while its old details are being built
+	err = Invalidate(ctx, DetailsTag("malls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = entry.Set(ctx, &Response{ContentType: "application/json", Body: []byte(`{}`)}, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := entry.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != nil {
+		t.Errorf("Stale response %s is cached", cached.Body)
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryEntry struct {
+	response  *Response
+	expiresAt time.Time
+}
+
+// MemoryStorage keeps the cache of a single process, it is used by the tests.
+type MemoryStorage struct {
+	mutex    sync.Mutex
+	entries  map[string]*memoryEntry
+	tags     map[string]map[string]bool
+	versions map[string]int64
+	locks    map[string]*memoryMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		entries:  map[string]*memoryEntry{},
+		tags:     map[string]map[string]bool{},
+		versions: map[string]int64{},
+		locks:    map[string]*memoryMutex{},
+	}
+}
+
+func (ms *MemoryStorage) Get(ctx context.Context, key string) (*Response, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	entry, ok := ms.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return entry.response, nil
+}
+
+func (ms *MemoryStorage) Set(ctx context.Context, key string, response *Response, ttl time.Duration,
+	tags []string, versions []int64) (bool, error) {
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if len(tags) != len(versions) {
+		return false, nil
+	}
+	for i, tag := range tags {
+		if ms.versions[tag] != versions[i] {
+			return false, nil
+		}
+	}
+	ms.entries[key] = &memoryEntry{response: response, expiresAt: time.Now().Add(ttl)}
+	for _, tag := range tags {
+		if ms.tags[tag] == nil {
+			ms.tags[tag] = map[string]bool{}
+		}
+		ms.tags[tag][key] = true
+	}
+	return true, nil
+}
+
+func (ms *MemoryStorage) Versions(ctx context.Context, tags []string) ([]int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	versions := make([]int64, len(tags))
+	for i, tag := range tags {
+		versions[i] = ms.versions[tag]
+	}
+	return versions, nil
+}
+
+func (ms *MemoryStorage) Invalidate(ctx context.Context, tag string) (int, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.versions[tag]++
+	keys := ms.tags[tag]
+	for key := range keys {
+		delete(ms.entries, key)
+	}
+	delete(ms.tags, tag)
+	return len(keys), nil
+}
+
+func (ms *MemoryStorage) Mutex(key string) Mutex {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	lock, ok := ms.locks[key]
+	if !ok {
+		lock = &memoryMutex{locked: make(chan struct{}, 1)}
+		ms.locks[key] = lock
+	}
+	return lock
+}
+
+type memoryMutex struct {
+	locked chan struct{}
+}
+
+func (mm *memoryMutex) Lock(ctx context.Context) error {
+	select {
+	case mm.locked <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (mm *memoryMutex) Unlock(ctx context.Context) error {
+	select {
+	case <-mm.locked:
+		return nil
+	default:
+		return errors.New("mutex hasn't locked yet")
+	}
+}
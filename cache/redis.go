@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"mallfin_api/redisdb"
+	"mallfin_api/tracing"
+	"mallfin_api/utils"
+
+	"github.com/pkg/errors"
+	"gopkg.in/redis.v5"
+)
+
+// RedisStorage keeps entries as hashes of the response body and content type.
+type RedisStorage struct{}
+
+func NewRedisStorage() *RedisStorage {
+	return &RedisStorage{}
+}
+
+func (rs *RedisStorage) Get(ctx context.Context, key string) (*Response, error) {
+	client := redisdb.GetClient()
+	_, span := tracing.StartRedisSpan(ctx, "HGETALL", key)
+	fields, err := client.HGetAll(key).Result()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get cache entry %s", key)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return &Response{ContentType: fields["content_type"], Body: []byte(fields["body"])}, nil
+}
+
+func (rs *RedisStorage) Set(ctx context.Context, key string, response *Response, ttl time.Duration,
+	tags []string, versions []int64) (bool, error) {
+
+	client := redisdb.GetClient()
+	versionKeys := tagKeys(versionPrefix, tags)
+	stored := false
+	_, span := tracing.StartRedisSpan(ctx, "HMSET", key)
+	// an invalidation between MGET and EXEC fails the transaction
+	err := client.Watch(func(tx *redis.Tx) error {
+		values, err := tx.MGet(versionKeys...).Result()
+		if err != nil {
+			return err
+		}
+		current, err := parseVersions(values)
+		if err != nil {
+			return err
+		}
+		if !equalVersions(current, versions) {
+			return nil
+		}
+		_, err = tx.Pipelined(func(pipe *redis.Pipeline) error {
+			pipe.HMSet(key, map[string]string{"content_type": response.ContentType, "body": string(response.Body)})
+			pipe.Expire(key, ttl)
+			for _, tag := range tags {
+				pipe.SAdd(tagPrefix+tag, key)
+				pipe.Expire(tagPrefix+tag, maxTTL)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		stored = true
+		return nil
+	}, versionKeys...)
+	if err == redis.TxFailedErr {
+		err = nil
+	}
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot set cache entry %s", key)
+	}
+	return stored, nil
+}
+
+func (rs *RedisStorage) Versions(ctx context.Context, tags []string) ([]int64, error) {
+	client := redisdb.GetClient()
+	versionKeys := tagKeys(versionPrefix, tags)
+	_, span := tracing.StartRedisSpan(ctx, "MGET", strings.Join(versionKeys, " "))
+	values, err := client.MGet(versionKeys...).Result()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get cache tag versions")
+	}
+	return parseVersions(values)
+}
+
+func (rs *RedisStorage) Invalidate(ctx context.Context, tag string) (int, error) {
+	client := redisdb.GetClient()
+	tagKey := tagPrefix + tag
+	versionKey := versionPrefix + tag
+	// the version goes first, so a response built before the invalidation is not stored after it
+	_, span := tracing.StartRedisSpan(ctx, "INCR", versionKey)
+	_, err := client.Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.Incr(versionKey)
+		pipe.Expire(versionKey, maxTTL)
+		return nil
+	})
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot bump version of tag %s", tag)
+	}
+	_, span = tracing.StartRedisSpan(ctx, "SMEMBERS", tagKey)
+	keys, err := client.SMembers(tagKey).Result()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot get cache entries for tag %s", tag)
+	}
+	_, span = tracing.StartRedisSpan(ctx, "DEL", tagKey)
+	err = client.Del(append(keys, tagKey)...).Err()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot delete cache entries for tag %s", tag)
+	}
+	return len(keys), nil
+}
+
+func (rs *RedisStorage) Mutex(key string) Mutex {
+	return utils.NewDistributedMutex(key)
+}
+
+func tagKeys(prefix string, tags []string) []string {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = prefix + tag
+	}
+	return keys
+}
+
+// parseVersions treats missing version keys as zero versions.
+func parseVersions(values []interface{}) ([]int64, error) {
+	versions := make([]int64, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		raw, ok := value.(string)
+		if !ok {
+			return nil, errors.Errorf("unexpected cache tag version %v", value)
+		}
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse cache tag version %s", raw)
+		}
+		versions[i] = version
+	}
+	return versions, nil
+}
+
+func equalVersions(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
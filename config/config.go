@@ -61,15 +61,59 @@ func LogLevel() string {
 	return conf.LogLevel
 }
 
+func AdminToken() string {
+	conf := GetConfig()
+	return conf.AdminToken
+}
+
+func Cache() *CacheSettings {
+	conf := GetConfig()
+	return conf.Cache
+}
+
+func Logging() *LoggingSettings {
+	conf := GetConfig()
+	return conf.Logging
+}
+
+func Health() *HealthSettings {
+	conf := GetConfig()
+	return conf.Health
+}
+
+func Tracing() *TracingSettings {
+	conf := GetConfig()
+	return conf.Tracing
+}
+
+func MigrationsDir() string {
+	conf := GetConfig()
+	return conf.MigrationsDir
+}
+
+func Timezone() string {
+	conf := GetConfig()
+	return conf.Timezone
+}
+
 type PostgresSettings struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	DBName   string `json:"name"`
-	PoolSize int    `json:"pool_size"`
-	Timeout  int    `json:"timeout"`
-	Retries  int    `json:"retries"`
+	Host      string             `json:"host"`
+	Port      int                `json:"port"`
+	User      string             `json:"user"`
+	Password  string             `json:"password"`
+	DBName    string             `json:"name"`
+	PoolSize  int                `json:"pool_size"`
+	Timeout   int                `json:"timeout"`
+	Retries   int                `json:"retries"`
+	SlowQuery *SlowQuerySettings `json:"slow_query"`
+}
+type SlowQuerySettings struct {
+	// the queries that take longer are logged, off if 0
+	ThresholdMs int `json:"threshold_ms"`
+	// share of the slow select queries that are run again with EXPLAIN ANALYZE, from 0 to 1
+	ExplainRatio float64 `json:"explain_ratio"`
+	// how many of the last slow queries are kept for /debug/slow_queries/
+	Keep int `json:"keep"`
 }
 type RedisSettings struct {
 	Host     string `json:"host"`
@@ -77,15 +121,56 @@ type RedisSettings struct {
 	Password string `json:"password"`
 	DB       int    `json:"db"`
 }
+type CacheSettings struct {
+	Enabled bool           `json:"enabled"`
+	Routes  map[string]int `json:"routes"`
+}
+type LoggingSettings struct {
+	// "text" or "json"
+	Format string `json:"format"`
+	// stderr if empty, the file is rotated when it grows over max_size_mb
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+	MaxAgeDays int    `json:"max_age_days"`
+	// package name -> level, the other packages log with log_level
+	Levels map[string]string `json:"levels"`
+}
+type HealthSettings struct {
+	// timeout of every dependency check of /readyz
+	CheckTimeoutMs int `json:"check_timeout_ms"`
+	// how long /readyz reports unready before the server stops accepting connections
+	DrainSeconds int `json:"drain_seconds"`
+	// how long the in-flight requests are waited for after that
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+}
+type TracingSettings struct {
+	// "otlp", "stdout" or "file", no spans are exported if empty
+	Exporter string `json:"exporter"`
+	// host:port of the otlp http receiver
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+	// path for the file exporter, the spans are appended to it
+	File string `json:"file"`
+	// "w3c" continues the traces from the traceparent header, otherwise every request starts a new trace
+	Propagation string `json:"propagation"`
+}
 type Config struct {
-	LogLevel    string            `json:"log_level"`
-	ServiceName string            `json:"service_name"`
-	ServerID    string            `json:"server_id"`
-	Debug       bool              `json:"debug"`
-	Port        int               `json:"port"`
-	AccessLog   bool              `json:"access_log"`
-	Postgres    *PostgresSettings `json:"postgres"`
-	Redis       *RedisSettings    `json:"redis"`
+	LogLevel      string            `json:"log_level"`
+	ServiceName   string            `json:"service_name"`
+	ServerID      string            `json:"server_id"`
+	Debug         bool              `json:"debug"`
+	Port          int               `json:"port"`
+	AccessLog     bool              `json:"access_log"`
+	MigrationsDir string            `json:"migrations_dir"`
+	AdminToken    string            `json:"admin_token"`
+	Timezone      string            `json:"timezone"`
+	Postgres      *PostgresSettings `json:"postgres"`
+	Redis         *RedisSettings    `json:"redis"`
+	Cache         *CacheSettings    `json:"cache"`
+	Logging       *LoggingSettings  `json:"logging"`
+	Health        *HealthSettings   `json:"health"`
+	Tracing       *TracingSettings  `json:"tracing"`
 }
 
 func CreateConfig(path string) *Config {
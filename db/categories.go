@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"mallfin_api/models"
 	"mallfin_api/utils"
 
@@ -8,9 +9,9 @@ import (
 	pg "gopkg.in/pg.v5"
 )
 
-func GetCategoryDetails(categoryID int) (*models.Category, error) {
+func (s *PostgresStore) GetCategoryDetails(ctx context.Context, categoryID int) (*models.Category, error) {
 	queryName := utils.CurrentFuncName()
-	categories, err := categoriesQuery(queryName, baseQuery(`
+	categories, err := categoriesQuery(ctx, queryName, baseQuery(`
 	SELECT {columns}
 	FROM category c
 	WHERE c.category_id = ?0
@@ -25,10 +26,10 @@ func GetCategoryDetails(categoryID int) (*models.Category, error) {
 	return categories[0], nil
 }
 
-func GetCategories(sorting models.Sorting) ([]*models.Category, error) {
+func (s *PostgresStore) GetCategories(ctx context.Context, sorting models.Sorting) ([]*models.Category, error) {
 	orderBy := categoryOrderBy(sorting)
 	queryName := utils.CurrentFuncName()
-	categories, err := categoriesQuery(queryName, orderBy.CompileBaseQuery(`
+	categories, err := categoriesQuery(ctx, queryName, orderBy.CompileBaseQuery(`
 	SELECT {columns}
 	FROM category c
 	ORDER BY {order}
@@ -39,10 +40,10 @@ func GetCategories(sorting models.Sorting) ([]*models.Category, error) {
 	return categories, nil
 }
 
-func GetCategoriesByIDs(categoryIDs []int) ([]*models.Category, error) {
+func (s *PostgresStore) GetCategoriesByIDs(ctx context.Context, categoryIDs []int) ([]*models.Category, error) {
 	categoryIDsArray := pg.Array(categoryIDs)
 	queryName := utils.CurrentFuncName()
-	categories, err := categoriesQuery(queryName, baseQuery(`
+	categories, err := categoriesQuery(ctx, queryName, baseQuery(`
 	SELECT {columns}
 	FROM category c
 	WHERE c.category_id = ANY (?0)
@@ -53,10 +54,10 @@ func GetCategoriesByIDs(categoryIDs []int) ([]*models.Category, error) {
 	return categories, nil
 }
 
-func GetCategoriesByShop(shopID int, sorting models.Sorting) ([]*models.Category, error) {
+func (s *PostgresStore) GetCategoriesByShop(ctx context.Context, shopID int, sorting models.Sorting) ([]*models.Category, error) {
 	orderBy := categoryOrderBy(sorting)
 	queryName := utils.CurrentFuncName()
-	categories, err := categoriesQuery(queryName, orderBy.CompileBaseQuery(`
+	categories, err := categoriesQuery(ctx, queryName, orderBy.CompileBaseQuery(`
 	SELECT {columns}
 	FROM category c
 	  JOIN shop_category sc ON c.category_id = sc.category_id
@@ -69,8 +70,8 @@ func GetCategoriesByShop(shopID int, sorting models.Sorting) ([]*models.Category
 	return categories, nil
 }
 
-func categoriesQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.Category, error) {
-	client := GetClient()
+func categoriesQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.Category, error) {
+	client := observed(ctx, GetClient(), queryName)
 	query := queryBasis.withColumns(`
 	  c.category_id,
 	  c.category_name,
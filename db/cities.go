@@ -1,16 +1,17 @@
 package db
 
 import (
+	"context"
 	"mallfin_api/models"
 	"mallfin_api/utils"
 
 	"github.com/pkg/errors"
 )
 
-func GetCities(sorting models.Sorting) ([]*models.City, error) {
+func (s *PostgresStore) GetCities(ctx context.Context, sorting models.Sorting) ([]*models.City, error) {
 	orderBy := cityOrderBy(sorting)
 	queryName := utils.CurrentFuncName()
-	cities, err := citiesQuery(queryName, orderBy.CompileBaseQuery(`
+	cities, err := citiesQuery(ctx, queryName, orderBy.CompileBaseQuery(`
 	SELECT {columns}
 	FROM city c
 	ORDER BY {order}
@@ -21,10 +22,10 @@ func GetCities(sorting models.Sorting) ([]*models.City, error) {
 	return cities, nil
 }
 
-func GetCitiesByName(name string, sorting models.Sorting) ([]*models.City, error) {
+func (s *PostgresStore) GetCitiesByName(ctx context.Context, name string, sorting models.Sorting) ([]*models.City, error) {
 	orderBy := cityOrderBy(sorting)
 	queryName := utils.CurrentFuncName()
-	cities, err := citiesQuery(queryName, orderBy.CompileBaseQuery(`
+	cities, err := citiesQuery(ctx, queryName, orderBy.CompileBaseQuery(`
 	SELECT {columns}
 	FROM city c
 	WHERE c.city_name ILIKE '%%' || ?0 || '%%'
@@ -36,9 +37,9 @@ func GetCitiesByName(name string, sorting models.Sorting) ([]*models.City, error
 	return cities, nil
 }
 
-func GetCityByLocation(location *models.Location) (*models.City, error) {
+func (s *PostgresStore) GetCityByLocation(ctx context.Context, location *models.Location) (*models.City, error) {
 	queryName := utils.CurrentFuncName()
-	cities, err := citiesQuery(queryName, baseQuery(`
+	cities, err := citiesQuery(ctx, queryName, baseQuery(`
 	SELECT {columns}
 	FROM city c
 	WHERE st_dwithin(st_transform(c.city_location, 26986), st_transform(ST_Setsrid(st_point(?, ?), 4326), 26986), c.city_radius)
@@ -54,8 +55,8 @@ func GetCityByLocation(location *models.Location) (*models.City, error) {
 	return cities[0], nil
 }
 
-func citiesQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.City, error) {
-	client := GetClient()
+func citiesQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.City, error) {
+	client := observed(ctx, GetClient(), queryName)
 	var rows []*struct {
 		CityID   int
 		CityName string
@@ -1,142 +1,183 @@
 package db
 
 import (
+	"context"
+	"time"
+
 	"github.com/go-pg/pg"
 	"github.com/pkg/errors"
+	"mallfin_api/models"
 	"mallfin_api/utils"
 )
 
-func MallsCount(cityID int) (int, error) {
+func (s *PostgresStore) MallsCount(ctx context.Context, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
-	WHERE m.city_id = ?0
-	`, cityID)
+	WHERE m.city_id = ?0 AND (?1::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?1, ?2)) AND {geo}
+	`, geo), cityID, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func MallsWithoutCityCount() (int, error) {
+func (s *PostgresStore) MallsWithoutCityCount(ctx context.Context, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
-	`)
+	WHERE (?0::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?0, ?1)) AND {geo}
+	`, geo), openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func MallsByNameCount(name string, cityID int) (int, error) {
+func (s *PostgresStore) MallsByNameCount(ctx context.Context, name string, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
-	  JOIN (SELECT DISTINCT ON (mall_id) mall_id
+	  JOIN (SELECT DISTINCT mall_id
 			FROM mall_name
-			WHERE mall_name ILIKE '%' || ?0 || '%') mn ON m.mall_id = mn.mall_id
-	WHERE m.city_id = ?1
-	`, name, cityID)
+			WHERE name_search_key(mall_name) LIKE '%' || name_search_key(?0) || '%' OR name_search_key(?0) <% name_search_key(mall_name)) mn
+		ON m.mall_id = mn.mall_id
+	WHERE m.city_id = ?1 AND (?2::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?2, ?3)) AND {geo}
+	`, geo), name, cityID, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func MallsByNameWithoutCityCount(name string) (int, error) {
+func (s *PostgresStore) MallsByNameWithoutCityCount(ctx context.Context, name string, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
-	  JOIN (SELECT DISTINCT ON (mall_id) mall_id
+	  JOIN (SELECT DISTINCT mall_id
 			FROM mall_name
-			WHERE mall_name ILIKE '%' || ?0 || '%') mn ON m.mall_id = mn.mall_id
-	`, name)
+			WHERE name_search_key(mall_name) LIKE '%' || name_search_key(?0) || '%' OR name_search_key(?0) <% name_search_key(mall_name)) mn
+		ON m.mall_id = mn.mall_id
+	WHERE (?1::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?1, ?2)) AND {geo}
+	`, geo), name, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func MallsByShopWithoutCityCount(shopID int) (int, error) {
+func (s *PostgresStore) MallsByShopWithoutCityCount(ctx context.Context, shopID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ?0
-	`, shopID)
+	WHERE ms.shop_id = ?0 AND (?1::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?1, ?2)) AND {geo}
+	`, geo), shopID, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func MallsByShopCount(shopID, cityID int) (int, error) {
+func (s *PostgresStore) MallsByShopCount(ctx context.Context, shopID, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ?0 AND m.city_id = ?1
-	`, shopID, cityID)
+	WHERE ms.shop_id = ?0 AND m.city_id = ?1 AND (?2::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?2, ?3)) AND {geo}
+	`, geo), shopID, cityID, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func MallsBySubwayStationCount(subwayStationID int) (int, error) {
+func (s *PostgresStore) MallsBySubwayStationCount(ctx context.Context, subwayStationID int, maxWalkMinutes *int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withGeoFilter(`
 	SELECT count(*)
 	FROM mall m
-	  LEFT JOIN subway_station ss ON m.subway_station_id = ss.station_id
-	WHERE ss.station_id = ?0
-	`, subwayStationID)
+	  JOIN mall_subway_station mss ON m.mall_id = mss.mall_id
+	WHERE mss.station_id = ?0 AND (?1::INTEGER IS NULL OR mss.walk_minutes <= ?1) AND
+	  (?2::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?2, ?3)) AND {geo}
+	`, geo), subwayStationID, maxWalkMinutes, openAt, timeZone.String())
+	if err != nil {
+		return 0, err
+	}
+	return totalCount, nil
+}
+
+func (s *PostgresStore) SearchResultsWithoutCityCount(ctx context.Context, shopIDs []int, openAt *time.Time) (int, error) {
+	queryName := utils.CurrentFuncName()
+	shopIDsArray := pg.Array(shopIDs)
+	totalCount, err := countQuery(ctx, queryName, `
+	SELECT count(DISTINCT m.mall_id)
+	FROM mall m
+	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
+	WHERE ms.shop_id = ANY (?0) AND (?1::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?1, ?2))
+	`, shopIDsArray, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func SearchResultsWithoutCityCount(shopIDs []int) (int, error) {
+func (s *PostgresStore) SearchResultsCount(ctx context.Context, shopIDs []int, cityID int, openAt *time.Time) (int, error) {
 	queryName := utils.CurrentFuncName()
 	shopIDsArray := pg.Array(shopIDs)
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, `
 	SELECT count(DISTINCT m.mall_id)
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ANY (?0)
-	`, shopIDsArray)
+	WHERE ms.shop_id = ANY (?0) AND m.city_id = ?1 AND (?2::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?2, ?3))
+	`, shopIDsArray, cityID, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func SearchResultsCount(shopIDs []int, cityID int) (int, error) {
+func (s *PostgresStore) SearchResultsAlongRouteWithoutCityCount(ctx context.Context, shopIDs []int, route *models.RouteFilter, openAt *time.Time) (int, error) {
 	queryName := utils.CurrentFuncName()
 	shopIDsArray := pg.Array(shopIDs)
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, withRouteFilter(`
 	SELECT count(DISTINCT m.mall_id)
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ANY (?0) AND m.city_id = ?1
-	`, shopIDsArray, cityID)
+	WHERE ms.shop_id = ANY (?0) AND (?1::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?1, ?2))
+	  AND {route}
+	`, route), shopIDsArray, openAt, timeZone.String())
 	if err != nil {
 		return 0, err
 	}
 	return totalCount, nil
 }
 
-func ShopsByCategoryWithoutCityCount(categoryID int) (int, error) {
+func (s *PostgresStore) SearchResultsAlongRouteCount(ctx context.Context, shopIDs []int, route *models.RouteFilter, cityID int, openAt *time.Time) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	shopIDsArray := pg.Array(shopIDs)
+	totalCount, err := countQuery(ctx, queryName, withRouteFilter(`
+	SELECT count(DISTINCT m.mall_id)
+	FROM mall m
+	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
+	WHERE ms.shop_id = ANY (?0) AND m.city_id = ?1 AND (?2::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?2, ?3))
+	  AND {route}
+	`, route), shopIDsArray, cityID, openAt, timeZone.String())
+	if err != nil {
+		return 0, err
+	}
+	return totalCount, nil
+}
+
+func (s *PostgresStore) ShopsByCategoryWithoutCityCount(ctx context.Context, categoryID int) (int, error) {
+	queryName := utils.CurrentFuncName()
+	totalCount, err := countQuery(ctx, queryName, `
 	SELECT count(*)
 	FROM shop s
 	  JOIN shop_category sc ON s.shop_id = sc.shop_id
@@ -148,9 +189,9 @@ func ShopsByCategoryWithoutCityCount(categoryID int) (int, error) {
 	return totalCount, nil
 }
 
-func ShopsByCategoryCount(categoryID, cityID int) (int, error) {
+func (s *PostgresStore) ShopsByCategoryCount(ctx context.Context, categoryID, cityID int) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, `
 	SELECT count(DISTINCT s.shop_id)
 	FROM shop s
 	  JOIN shop_category sc ON s.shop_id = sc.shop_id
@@ -164,13 +205,12 @@ func ShopsByCategoryCount(categoryID, cityID int) (int, error) {
 	return totalCount, nil
 }
 
-func ShopsByNameWithoutCityCount(name string) (int, error) {
+func (s *PostgresStore) ShopsByNameWithoutCityCount(ctx context.Context, name string) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
-	SELECT count(DISTINCT s.shop_id)
-	FROM shop s
-	  JOIN shop_name sn ON s.shop_id = sn.shop_id
-	WHERE sn.shop_name ILIKE '%' || ?0 || '%'
+	totalCount, err := countQuery(ctx, queryName, `
+	SELECT count(DISTINCT shop_id)
+	FROM shop_name
+	WHERE name_search_key(shop_name) LIKE '%' || name_search_key(?0) || '%' OR name_search_key(?0) <% name_search_key(shop_name)
 	`, name)
 	if err != nil {
 		return 0, err
@@ -178,15 +218,15 @@ func ShopsByNameWithoutCityCount(name string) (int, error) {
 	return totalCount, nil
 }
 
-func ShopsByNameCount(name string, cityID int) (int, error) {
+func (s *PostgresStore) ShopsByNameCount(ctx context.Context, name string, cityID int) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
-	SELECT count(DISTINCT s.shop_id)
-	FROM shop s
-	  JOIN shop_name sn ON s.shop_id = sn.shop_id
-	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
+	totalCount, err := countQuery(ctx, queryName, `
+	SELECT count(DISTINCT sn.shop_id)
+	FROM shop_name sn
+	  JOIN mall_shop ms ON sn.shop_id = ms.shop_id
 	  JOIN mall m ON ms.mall_id = m.mall_id
-	WHERE sn.shop_name ILIKE '%' || ?0 || '%' AND m.city_id = ?1
+	WHERE (name_search_key(sn.shop_name) LIKE '%' || name_search_key(?0) || '%' OR name_search_key(?0) <% name_search_key(sn.shop_name))
+	  AND m.city_id = ?1
 	`, name, cityID)
 	if err != nil {
 		return 0, err
@@ -194,9 +234,9 @@ func ShopsByNameCount(name string, cityID int) (int, error) {
 	return totalCount, nil
 }
 
-func ShopsByMallCount(mallID int) (int, error) {
+func (s *PostgresStore) ShopsByMallCount(ctx context.Context, mallID int) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, `
 	SELECT count(*)
 	FROM shop s
 	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
@@ -208,9 +248,9 @@ func ShopsByMallCount(mallID int) (int, error) {
 	return totalCount, nil
 }
 
-func ShopsWithoutCityCount() (int, error) {
+func (s *PostgresStore) ShopsWithoutCityCount(ctx context.Context) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, `
 	SELECT count(*)
 	FROM shop s
 	`)
@@ -220,9 +260,9 @@ func ShopsWithoutCityCount() (int, error) {
 	return totalCount, nil
 }
 
-func ShopsCount(cityID int) (int, error) {
+func (s *PostgresStore) ShopsCount(ctx context.Context, cityID int) (int, error) {
 	queryName := utils.CurrentFuncName()
-	totalCount, err := countQuery(queryName, `
+	totalCount, err := countQuery(ctx, queryName, `
 	SELECT count(*)
 	FROM shop s
 	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
@@ -235,11 +275,11 @@ func ShopsCount(cityID int) (int, error) {
 	return totalCount, nil
 }
 
-func countQuery(queryName, query string, args ...interface{}) (int, error) {
+func countQuery(ctx context.Context, queryName, query string, args ...interface{}) (int, error) {
 	var row struct {
 		Count int
 	}
-	client := GetClient()
+	client := observed(ctx, GetClient(), queryName)
 	_, err := client.QueryOne(&row, query, args...)
 	if err != nil {
 		return 0, errors.WithMessage(err, queryName)
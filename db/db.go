@@ -9,12 +9,16 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/go-pg/pg"
 	"mallfin_api/logging"
+	"mallfin_api/metrics"
 )
 
 var (
-	db     *pg.DB
-	logger = logging.WithPackage("db")
-	once   sync.Once
+	db       *pg.DB
+	timeZone *time.Location
+	// city timezone name -> *time.Location
+	cityTimeZones sync.Map
+	logger        = logging.WithPackage("db")
+	once          sync.Once
 )
 
 func CreateNewDB() *pg.DB {
@@ -39,10 +43,47 @@ func CreateNewDB() *pg.DB {
 	return db
 }
 
+func loadTimeZone() *time.Location {
+	name := config.Timezone()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		logger.WithField("timezone", name).Panicf("Cannot load timezone: %s", err)
+	}
+	return location
+}
+
+// city timezones are loaded lazily, unknown ones fall back to the default timezone
+func cityTimeZone(name *string) *time.Location {
+	if name == nil || *name == "" {
+		return timeZone
+	}
+	if location, ok := cityTimeZones.Load(*name); ok {
+		return location.(*time.Location)
+	}
+	location, err := time.LoadLocation(*name)
+	if err != nil {
+		logger.WithField("timezone", *name).Errorf("Cannot load city timezone: %s", err)
+		location = timeZone
+	}
+	cityTimeZones.Store(*name, location)
+	return location
+}
+
 func Initialization() {
 	once.Do(func() {
+		timeZone = loadTimeZone()
 		newDB := CreateNewDB()
 		db = newDB
+		metrics.RegisterPool("postgres", func() *metrics.PoolStats {
+			stats := newDB.PoolStats()
+			return &metrics.PoolStats{
+				Requests:   stats.Requests,
+				Hits:       stats.Hits,
+				Timeouts:   stats.Timeouts,
+				TotalConns: stats.TotalConns,
+				FreeConns:  stats.FreeConns,
+			}
+		})
 	})
 }
 
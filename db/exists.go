@@ -1,14 +1,15 @@
 package db
 
 import (
+	"context"
 	"mallfin_api/utils"
 
 	"github.com/pkg/errors"
 )
 
-func IsShopExists(shopID int) (bool, error) {
+func (s *PostgresStore) IsShopExists(ctx context.Context, shopID int) (bool, error) {
 	queryName := utils.CurrentFuncName()
-	exists, err := existsQuery(queryName, `
+	exists, err := existsQuery(ctx, queryName, `
 	SELECT exists(
 		SELECT *
 		FROM shop
@@ -20,9 +21,9 @@ func IsShopExists(shopID int) (bool, error) {
 	return exists, nil
 }
 
-func IsMallExists(mallID int) (bool, error) {
+func (s *PostgresStore) IsMallExists(ctx context.Context, mallID int) (bool, error) {
 	queryName := utils.CurrentFuncName()
-	exists, err := existsQuery(queryName, `
+	exists, err := existsQuery(ctx, queryName, `
 	SELECT exists(
 		SELECT *
 		FROM mall
@@ -34,9 +35,9 @@ func IsMallExists(mallID int) (bool, error) {
 	return exists, nil
 }
 
-func IsCityExists(cityID int) (bool, error) {
+func (s *PostgresStore) IsCityExists(ctx context.Context, cityID int) (bool, error) {
 	queryName := utils.CurrentFuncName()
-	exists, err := existsQuery(queryName, `
+	exists, err := existsQuery(ctx, queryName, `
 	SELECT exists(
 		SELECT *
 		FROM city
@@ -48,9 +49,9 @@ func IsCityExists(cityID int) (bool, error) {
 	return exists, nil
 }
 
-func IsCategoryExists(categoryID int) (bool, error) {
+func (s *PostgresStore) IsCategoryExists(ctx context.Context, categoryID int) (bool, error) {
 	queryName := utils.CurrentFuncName()
-	exists, err := existsQuery(queryName, `
+	exists, err := existsQuery(ctx, queryName, `
 	SELECT exists(
 		SELECT *
 		FROM category
@@ -62,9 +63,9 @@ func IsCategoryExists(categoryID int) (bool, error) {
 	return exists, nil
 }
 
-func IsSubwayStationExists(subwayStationID int) (bool, error) {
+func (s *PostgresStore) IsSubwayStationExists(ctx context.Context, subwayStationID int) (bool, error) {
 	queryName := utils.CurrentFuncName()
-	exists, err := existsQuery(queryName, `
+	exists, err := existsQuery(ctx, queryName, `
 	SELECT exists(
 		SELECT *
 		FROM subway_station
@@ -76,9 +77,9 @@ func IsSubwayStationExists(subwayStationID int) (bool, error) {
 	return exists, nil
 }
 
-func existsQuery(queryName, query string, args ...interface{}) (bool, error) {
+func existsQuery(ctx context.Context, queryName, query string, args ...interface{}) (bool, error) {
 	result := struct{ Exists bool }{}
-	client := GetClient()
+	client := observed(ctx, GetClient(), queryName)
 	_, err := client.QueryOne(&result, query, args...)
 	if err != nil {
 		return false, errors.WithMessage(err, queryName)
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"mallfin_api/models"
+	"mallfin_api/utils"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+)
+
+type floorRow struct {
+	Floor      int
+	FloorName  string
+	PlanImage  string
+	PlanWidth  float64
+	PlanHeight float64
+	UnitsCount int
+}
+
+func (fr *floorRow) toModel() *models.FloorPlan {
+	plan := &models.FloorPlan{
+		Floor:      fr.Floor,
+		Name:       fr.FloorName,
+		PlanImage:  fr.PlanImage,
+		Width:      fr.PlanWidth,
+		Height:     fr.PlanHeight,
+		UnitsCount: fr.UnitsCount,
+	}
+	return plan
+}
+
+type unitRow struct {
+	UnitID int
+	Unit   string
+	ShopID *int
+	// GeoJSON of the polygon
+	UnitPolygon string
+}
+
+func (ur *unitRow) toModel() (*models.FloorUnit, error) {
+	var geometry struct {
+		Coordinates [][][2]float64
+	}
+	err := json.Unmarshal([]byte(ur.UnitPolygon), &geometry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode polygon of unit %d", ur.UnitID)
+	}
+	polygon := make([][]models.IndoorPoint, len(geometry.Coordinates))
+	for i, ring := range geometry.Coordinates {
+		polygon[i] = make([]models.IndoorPoint, len(ring))
+		for j, point := range ring {
+			polygon[i][j] = models.IndoorPoint{X: point[0], Y: point[1]}
+		}
+	}
+	unit := &models.FloorUnit{
+		ID:      ur.UnitID,
+		Unit:    ur.Unit,
+		ShopID:  ur.ShopID,
+		Polygon: polygon,
+	}
+	return unit, nil
+}
+
+func (s *PostgresStore) GetMallFloors(ctx context.Context, mallID int) ([]*models.FloorPlan, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var rows []*floorRow
+	_, err := client.Query(&rows, `
+	SELECT
+	  f.floor,
+	  f.floor_name,
+	  f.plan_image,
+	  f.plan_width,
+	  f.plan_height,
+	  (SELECT count(*) FROM mall_floor_unit u WHERE u.mall_id = f.mall_id AND u.floor = f.floor) units_count
+	FROM mall_floor f
+	WHERE f.mall_id = ?0
+	ORDER BY f.floor
+	`, mallID)
+	if err != nil {
+		return nil, errors.WithMessage(err, queryName)
+	}
+	plans := make([]*models.FloorPlan, len(rows))
+	for i, row := range rows {
+		plans[i] = row.toModel()
+	}
+	return plans, nil
+}
+
+func (s *PostgresStore) GetFloorPlan(ctx context.Context, mallID, floor int) (*models.FloorPlan, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var row floorRow
+	_, err := client.QueryOne(&row, `
+	SELECT
+	  f.floor,
+	  f.floor_name,
+	  f.plan_image,
+	  f.plan_width,
+	  f.plan_height
+	FROM mall_floor f
+	WHERE f.mall_id = ?0 AND f.floor = ?1
+	`, mallID, floor)
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithMessage(err, queryName)
+	}
+	plan := row.toModel()
+	plan.Units, err = unitsQuery(ctx, queryName, baseQuery(`
+	SELECT {columns}
+	FROM mall_floor_unit u
+	WHERE u.mall_id = ?0 AND u.floor = ?1
+	ORDER BY u.unit, u.unit_id
+	`), mallID, floor)
+	if err != nil {
+		return nil, err
+	}
+	plan.UnitsCount = len(plan.Units)
+	return plan, nil
+}
+
+func (s *PostgresStore) GetFloorUnitAt(ctx context.Context, mallID, floor int, point models.IndoorPoint) (*models.FloorUnit, error) {
+	queryName := utils.CurrentFuncName()
+	// the smallest of the nested units is the most precise
+	units, err := unitsQuery(ctx, queryName, baseQuery(`
+	SELECT {columns}
+	FROM mall_floor_unit u
+	WHERE u.mall_id = ?0 AND u.floor = ?1 AND st_covers(u.unit_polygon, st_makepoint(?2, ?3))
+	ORDER BY st_area(u.unit_polygon), u.unit_id
+	LIMIT 1
+	`), mallID, floor, point.X, point.Y)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, nil
+	}
+	return units[0], nil
+}
+
+func unitsQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.FloorUnit, error) {
+	client := observed(ctx, GetClient(), queryName)
+	var rows []*unitRow
+	query := queryBasis.withColumns(`
+	  u.unit_id,
+	  u.unit,
+	  u.shop_id,
+	  st_asgeojson(u.unit_polygon) unit_polygon
+	`)
+	_, err := client.Query(&rows, query, args...)
+	if err != nil {
+		return nil, errors.WithMessage(err, queryName)
+	}
+	units := make([]*models.FloorUnit, len(rows))
+	for i, row := range rows {
+		units[i], err = row.toModel()
+		if err != nil {
+			return nil, errors.WithMessage(err, queryName)
+		}
+	}
+	return units, nil
+}
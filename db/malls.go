@@ -1,6 +1,10 @@
 package db
 
 import (
+	"context"
+	"sort"
+	"time"
+
 	"mallfin_api/models"
 	"mallfin_api/utils"
 
@@ -21,15 +25,14 @@ type mallRow struct {
 	//Details
 	MallSite    string
 	DayAndNight bool
-	StationID   *int
-	StationName *string
+	// nil if the city uses the default timezone
+	CityTimezone *string
+	// only in name queries
+	Relevance float64
+	Distance  *float64
 }
 
 func (mr *mallRow) toModel() *models.Mall {
-	var station *models.SubwayStation
-	if mr.StationID != nil && mr.StationName != nil {
-		station = &models.SubwayStation{ID: *mr.StationID, Name: *mr.StationName}
-	}
 	mall := &models.Mall{
 		ID:          mr.MallID,
 		Name:        mr.MallName,
@@ -40,17 +43,18 @@ func (mr *mallRow) toModel() *models.Mall {
 		Address:     mr.Address,
 		DayAndNight: mr.DayAndNight,
 		Site:        mr.MallSite,
-		Subway:      station,
+		TimeZone:    cityTimeZone(mr.CityTimezone),
+		Relevance:   mr.Relevance,
+		Distance:    mr.Distance,
 	}
 	return mall
 }
 
-func GetMallDetails(mallID int) (*models.Mall, error) {
+func (s *PostgresStore) GetMallDetails(ctx context.Context, mallID int) (*models.Mall, error) {
 	queryName := utils.CurrentFuncName()
-	mall, err := mallQuery(queryName, baseQuery(`
+	mall, err := mallQuery(ctx, queryName, baseQuery(`
 	SELECT {columns}
 	FROM mall m
-	  LEFT JOIN subway_station ss ON m.subway_station_id = ss.station_id
 	WHERE m.mall_id = ?0
 	LIMIT 1
 	`), mallID)
@@ -60,12 +64,11 @@ func GetMallDetails(mallID int) (*models.Mall, error) {
 	return mall, nil
 }
 
-func GetMallByLocation(location *models.Location) (*models.Mall, error) {
+func (s *PostgresStore) GetMallByLocation(ctx context.Context, location *models.Location) (*models.Mall, error) {
 	queryName := utils.CurrentFuncName()
-	mall, err := mallQuery(queryName, baseQuery(`
+	mall, err := mallQuery(ctx, queryName, baseQuery(`
 	SELECT {columns}
 	FROM mall m
-	  LEFT JOIN subway_station ss ON m.subway_station_id = ss.station_id
 	WHERE st_dwithin(st_transform(m.mall_location, 26986), st_transform(ST_Setsrid(st_point(?0, ?1), 4326), 26986), m.mall_radius)
 	ORDER BY m.mall_location <-> ST_SetSRID(ST_Point(?0, ?1), 4326)
 	LIMIT 1
@@ -76,46 +79,51 @@ func GetMallByLocation(location *models.Location) (*models.Mall, error) {
 	return mall, nil
 }
 
-func GetMalls(cityID int, sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMalls(ctx context.Context, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, {distance} distance
 	FROM mall m
-	WHERE m.city_id = ?2
+	WHERE m.city_id = ?2 AND (?3::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?3, ?4)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, cityID)
+	`, geo), limit, offset, cityID, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetMallsWithoutCity(sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMallsWithoutCity(ctx context.Context, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, {distance} distance
 	FROM mall m
+	WHERE (?2::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?2, ?3)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset)
+	`, geo), limit, offset, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetMallsByIDs(mallIDs []int) ([]*models.Mall, error) {
+func (s *PostgresStore) GetMallsByIDs(ctx context.Context, mallIDs []int) ([]*models.Mall, error) {
 	if len(mallIDs) == 0 {
 		return nil, nil
 	}
 	mallIDsArray := pg.Array(mallIDs)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, baseQuery(`
+	malls, err := mallsQuery(ctx, queryName, baseQuery(`
 	SELECT {columns}
 	FROM mall m
 	WHERE m.mall_id = ANY(?0)
@@ -126,138 +134,220 @@ func GetMallsByIDs(mallIDs []int) ([]*models.Mall, error) {
 	return malls, nil
 }
 
-func GetMallsBySubwayStation(subwayStationID int, sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMallsBySubwayStation(ctx context.Context, subwayStationID int, maxWalkMinutes *int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, {distance} distance
 	FROM mall m
-	  LEFT JOIN subway_station ss ON m.subway_station_id = ss.station_id
-	WHERE ss.station_id = ?2
+	  JOIN mall_subway_station mss ON m.mall_id = mss.mall_id
+	WHERE mss.station_id = ?2 AND (?3::INTEGER IS NULL OR mss.walk_minutes <= ?3) AND
+	  (?4::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?4, ?5)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, subwayStationID)
+	`, geo), limit, offset, subwayStationID, maxWalkMinutes, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetMallsByShop(shopID, cityID int, sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMallsByShop(ctx context.Context, shopID, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, {distance} distance
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ?2 AND m.city_id = ?3
+	WHERE ms.shop_id = ?2 AND m.city_id = ?3 AND (?4::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?4, ?5)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, shopID, cityID)
+	`, geo), limit, offset, shopID, cityID, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetMallsByShopWithoutCity(shopID int, sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMallsByShopWithoutCity(ctx context.Context, shopID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, {distance} distance
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ?2
+	WHERE ms.shop_id = ?2 AND (?3::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?3, ?4)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, shopID)
+	`, geo), limit, offset, shopID, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetMallsByName(name string, cityID int, sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMallsByName(ctx context.Context, name string, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, mn.relevance, {distance} distance
 	FROM mall m
-	  JOIN (SELECT DISTINCT ON (mall_id) mall_id
+	  JOIN (SELECT mall_id, round(max(word_similarity(name_search_key(?2), name_search_key(mall_name)))::NUMERIC, 6)::FLOAT8 relevance
 			FROM mall_name
-			WHERE mall_name ILIKE '%%' || ?2 || '%%') mn ON m.mall_id = mn.mall_id
-	WHERE m.city_id = ?3
+			WHERE name_search_key(mall_name) LIKE '%' || name_search_key(?2) || '%' OR name_search_key(?2) <% name_search_key(mall_name)
+			GROUP BY mall_id) mn ON m.mall_id = mn.mall_id
+	WHERE m.city_id = ?3 AND (?4::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?4, ?5)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, name, cityID)
+	`, geo), limit, offset, name, cityID, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetMallsByNameWithoutCity(name string, sorting models.Sorting, limit, offset *int) ([]*models.Mall, error) {
-	orderBy := mallOrderBy(sorting)
+func (s *PostgresStore) GetMallsByNameWithoutCity(ctx context.Context, name string, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	orderBy := mallOrderBy(sorting, cursor, geo)
 	queryName := utils.CurrentFuncName()
-	malls, err := mallsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withGeoFilter(`
+	SELECT {columns}, mn.relevance, {distance} distance
 	FROM mall m
-	  JOIN (SELECT DISTINCT ON (mall_id) mall_id
+	  JOIN (SELECT mall_id, round(max(word_similarity(name_search_key(?2), name_search_key(mall_name)))::NUMERIC, 6)::FLOAT8 relevance
 			FROM mall_name
-			WHERE mall_name ILIKE '%%' || ?2 || '%%') mn ON m.mall_id = mn.mall_id
+			WHERE name_search_key(mall_name) LIKE '%' || name_search_key(?2) || '%' OR name_search_key(?2) <% name_search_key(mall_name)
+			GROUP BY mall_id) mn ON m.mall_id = mn.mall_id
+	WHERE (?3::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?3, ?4)) AND {geo} AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, name)
+	`, geo), limit, offset, name, openAt, timeZone.String())
+	malls, err := mallsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(malls)
 	return malls, nil
 }
 
-func GetShopsInMalls(mallIDs, shopIDs []int) ([]*models.MallMatchedShops, error) {
+func (s *PostgresStore) GetMallLocations(ctx context.Context, filter *models.MallFilter) ([]*models.Mall, error) {
 	queryName := utils.CurrentFuncName()
-	client := GetClient()
+	client := observed(ctx, GetClient(), queryName)
 	var rows []*struct {
-		MallID int
-		Shops  []int `pg:",array"`
+		MallID          int
+		MallLocationLon float64
+		MallLocationLat float64
 	}
-	_, err := client.Query(&rows, `
+	_, err := client.Query(&rows, withGeoFilter(`
 	SELECT
-	  mall_id,
-	  array_agg(shop_id) shops
-	FROM mall_shop
-	WHERE mall_id = ANY (?) AND shop_id = ANY (?)
-	GROUP BY mall_id
-	ORDER BY count(shop_id) DESC
-	`, pg.Array(mallIDs), pg.Array(shopIDs))
+	  m.mall_id,
+	  ST_Y(m.mall_location) mall_location_lat,
+	  ST_X(m.mall_location) mall_location_lon
+	FROM mall m
+	WHERE (?0::INTEGER IS NULL OR m.city_id = ?0) AND
+	  (?1::INTEGER IS NULL OR EXISTS(SELECT 1
+	                                 FROM mall_shop ms
+	                                 WHERE ms.mall_id = m.mall_id AND ms.shop_id = ?1)) AND
+	  (?2::INTEGER IS NULL OR EXISTS(SELECT 1
+	                                 FROM mall_subway_station mss
+	                                 WHERE mss.mall_id = m.mall_id AND mss.station_id = ?2 AND
+	                                   (?3::INTEGER IS NULL OR mss.walk_minutes <= ?3))) AND
+	  (?4::TEXT IS NULL OR EXISTS(SELECT 1
+	                              FROM mall_name mn
+	                              WHERE mn.mall_id = m.mall_id AND
+	                                (name_search_key(mn.mall_name) LIKE '%' || name_search_key(?4) || '%' OR
+	                                 name_search_key(?4) <% name_search_key(mn.mall_name)))) AND
+	  (?5::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?5, ?6)) AND {geo}
+	ORDER BY m.mall_id
+	`, filter.Geo), filter.City, filter.Shop, filter.SubwayStation, filter.MaxWalkMinutes, filter.Query, filter.OpenAt,
+		timeZone.String())
+	if err != nil {
+		return nil, errors.WithMessage(err, queryName)
+	}
+	malls := make([]*models.Mall, len(rows))
+	for i, row := range rows {
+		malls[i] = &models.Mall{ID: row.MallID, Location: models.Location{Lat: row.MallLocationLat, Lon: row.MallLocationLon}}
+	}
+	return malls, nil
+}
+
+func (s *PostgresStore) GetShopsInMalls(ctx context.Context, mallIDs, shopIDs []int) ([]*models.MallMatchedShops, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var rows []*struct {
+		MallID            int
+		ShopID            int
+		PlacementFloor    *int
+		PlacementUnit     string
+		PlacementEntrance string
+		PlacementPhone    string
+	}
+	_, err := client.Query(&rows, withPlacement(`
+	SELECT
+	  ms.mall_id,
+	  ms.shop_id,
+	  {placement}
+	FROM mall_shop ms
+	WHERE ms.mall_id = ANY (?0) AND ms.shop_id = ANY (?1)
+	ORDER BY ms.mall_id, ms.shop_id
+	`), pg.Array(mallIDs), pg.Array(shopIDs))
 	if err != nil && err != pg.ErrNoRows {
 		return nil, errors.WithMessage(err, queryName)
 	}
-	mallToShops := map[int][]int{}
+	mallToShops := map[int]*models.MallMatchedShops{}
+	var matchedShops []*models.MallMatchedShops
 	for _, row := range rows {
-		mallToShops[row.MallID] = row.Shops
-	}
-	matchedShops := make([]*models.MallMatchedShops, len(rows))
-	for i, row := range rows {
-		matchedShops[i] = &models.MallMatchedShops{MallID: row.MallID, ShopIDs: row.Shops}
+		matched, ok := mallToShops[row.MallID]
+		if !ok {
+			matched = &models.MallMatchedShops{MallID: row.MallID}
+			mallToShops[row.MallID] = matched
+			matchedShops = append(matchedShops, matched)
+		}
+		matched.ShopIDs = append(matched.ShopIDs, row.ShopID)
+		matched.Placements = append(matched.Placements, &models.ShopPlacement{
+			Floor:    row.PlacementFloor,
+			Unit:     row.PlacementUnit,
+			Entrance: row.PlacementEntrance,
+			Phone:    row.PlacementPhone,
+		})
 	}
+	sort.SliceStable(matchedShops, func(i, j int) bool {
+		return len(matchedShops[i].ShopIDs) > len(matchedShops[j].ShopIDs)
+	})
 	for _, mallID := range mallIDs {
 		if _, ok := mallToShops[mallID]; !ok {
-			matchedShops = append(matchedShops, &models.MallMatchedShops{MallID: mallID, ShopIDs: []int{}})
+			matchedShops = append(matchedShops, &models.MallMatchedShops{MallID: mallID, ShopIDs: []int{}, Placements: []*models.ShopPlacement{}})
 		}
 	}
 	return matchedShops, nil
 }
 
-func getMallWorkingHours(mallID int) ([]*models.WorkPeriod, error) {
-	queryName := utils.CurrentFuncName()
-	client := GetClient()
+func loadMallHours(ctx context.Context, queryName string, malls ...*models.Mall) error {
+	if len(malls) == 0 {
+		return nil
+	}
+	mallIDs := make([]int, len(malls))
+	byID := make(map[int]*models.Mall, len(malls))
+	for i, mall := range malls {
+		mall.WorkingHours = []*models.WorkPeriod{}
+		mall.SpecialHours = []*models.SpecialHours{}
+		mallIDs[i] = mall.ID
+		byID[mall.ID] = mall
+	}
+	client := observed(ctx, GetClient(), queryName)
 	var rows []*struct {
+		MallID    int
 		OpenDay   int
 		OpenTime  string
 		CloseDay  int
@@ -265,28 +355,98 @@ func getMallWorkingHours(mallID int) ([]*models.WorkPeriod, error) {
 	}
 	_, err := client.Query(&rows, `
 	SELECT
-	  open_day,
+	  wh.mall_id,
+	  wh.open_day,
+	  wh.open_time,
+	  wh.close_day,
+	  wh.close_time
+	FROM mall_working_hours wh
+	  JOIN mall m ON wh.mall_id = m.mall_id
+	WHERE wh.mall_id = ANY (?0) AND NOT m.day_and_night
+	ORDER BY wh.mall_id, wh.open_day, wh.open_time
+	`, pg.Array(mallIDs))
+	if err != nil && err != pg.ErrNoRows {
+		return errors.WithMessage(err, queryName)
+	}
+	for _, row := range rows {
+		mall := byID[row.MallID]
+		mall.WorkingHours = append(mall.WorkingHours, &models.WorkPeriod{
+			Open:  models.WeekTime{Day: row.OpenDay, Time: row.OpenTime},
+			Close: models.WeekTime{Day: row.CloseDay, Time: row.CloseTime},
+		})
+	}
+	var specialRows []*struct {
+		MallID      int
+		SpecialDate string
+		OpenTime    *string
+		CloseTime   *string
+	}
+	// the serializers compute the open status and the schedules at the time of the request,
+	// the dates around it are taken in go, the date of the db session may be another one
+	windowFrom, windowTo := models.SpecialHoursWindow(time.Now())
+	_, err = client.Query(&specialRows, `
+	SELECT
+	  mall_id,
+	  to_char(special_date, 'YYYY-MM-DD') special_date,
 	  open_time,
-	  close_day,
 	  close_time
-	FROM mall_working_hours
-	WHERE mall_id = ?0
-	`, mallID)
+	FROM mall_special_hours
+	WHERE mall_id = ANY (?0) AND special_date BETWEEN ?1::DATE AND ?2::DATE
+	ORDER BY mall_id, special_date
+	`, pg.Array(mallIDs), windowFrom, windowTo)
 	if err != nil && err != pg.ErrNoRows {
-		return nil, errors.WithMessage(err, queryName)
+		return errors.WithMessage(err, queryName)
 	}
-	workingHours := make([]*models.WorkPeriod, len(rows))
+	for _, row := range specialRows {
+		mall := byID[row.MallID]
+		mall.SpecialHours = append(mall.SpecialHours, &models.SpecialHours{
+			Date:  row.SpecialDate,
+			Open:  row.OpenTime,
+			Close: row.CloseTime,
+		})
+	}
+	return nil
+}
+
+func loadMallSubwayStations(ctx context.Context, queryName string, mall *models.Mall) error {
+	client := observed(ctx, GetClient(), queryName)
+	var rows []*struct {
+		stationRow
+		WalkDistance int
+		WalkMinutes  int
+	}
+	_, err := client.Query(&rows, `
+	SELECT
+	  ss.station_id,
+	  ss.station_name,
+	  ss.line_name,
+	  ss.line_color,
+	  ss.city_id,
+	  ST_Y(ss.station_location) station_location_lat,
+	  ST_X(ss.station_location) station_location_lon,
+	  mss.walk_distance,
+	  mss.walk_minutes
+	FROM mall_subway_station mss
+	  JOIN subway_station ss ON mss.station_id = ss.station_id
+	WHERE mss.mall_id = ?0
+	ORDER BY mss.walk_distance, ss.station_id
+	`, mall.ID)
+	if err != nil && err != pg.ErrNoRows {
+		return errors.WithMessage(err, queryName)
+	}
+	mall.SubwayStations = make([]*models.MallSubwayStation, len(rows))
 	for i, row := range rows {
-		workingHours[i] = &models.WorkPeriod{
-			Open:  models.WeekTime{Day: row.OpenDay, Time: row.OpenTime},
-			Close: models.WeekTime{Day: row.CloseDay, Time: row.CloseTime},
+		mall.SubwayStations[i] = &models.MallSubwayStation{
+			Station:      row.toModel(),
+			WalkDistance: row.WalkDistance,
+			WalkMinutes:  row.WalkMinutes,
 		}
 	}
-	return workingHours, nil
+	return nil
 }
 
-func mallQuery(queryName string, queryBasis baseQuery, args ...interface{}) (*models.Mall, error) {
-	client := GetClient()
+func mallQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) (*models.Mall, error) {
+	client := observed(ctx, GetClient(), queryName)
 	var row mallRow
 	query := queryBasis.withColumns(`
 	  m.mall_id,
@@ -300,8 +460,7 @@ func mallQuery(queryName string, queryBasis baseQuery, args ...interface{}) (*mo
 	  m.address,
 	  m.mall_site,
 	  m.day_and_night,
-	  ss.station_id,
-	  ss.station_name
+	  (SELECT c.city_timezone FROM city c WHERE c.city_id = m.city_id) city_timezone
 	`)
 	_, err := client.QueryOne(&row, query, args...)
 	if err == pg.ErrNoRows {
@@ -310,17 +469,19 @@ func mallQuery(queryName string, queryBasis baseQuery, args ...interface{}) (*mo
 		return nil, errors.WithMessage(err, queryName)
 	}
 	mall := row.toModel()
-	if !row.DayAndNight {
-		mall.WorkingHours, err = getMallWorkingHours(mall.ID)
-		if err != nil {
-			return nil, err
-		}
+	err = loadMallHours(ctx, queryName, mall)
+	if err != nil {
+		return nil, err
+	}
+	err = loadMallSubwayStations(ctx, queryName, mall)
+	if err != nil {
+		return nil, err
 	}
 	return mall, nil
 }
 
-func mallsQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.Mall, error) {
-	client := GetClient()
+func mallsQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.Mall, error) {
+	client := observed(ctx, GetClient(), queryName)
 	var rows []*mallRow
 	query := queryBasis.withColumns(`
 	  m.mall_id,
@@ -330,7 +491,9 @@ func mallsQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]
 	  m.mall_logo_large,
 	  ST_Y(m.mall_location) mall_location_lat,
 	  ST_X(m.mall_location) mall_location_lon,
-	  m.shops_count
+	  m.shops_count,
+	  m.day_and_night,
+	  (SELECT c.city_timezone FROM city c WHERE c.city_id = m.city_id) city_timezone
 	`)
 	_, err := client.Query(&rows, query, args...)
 	if err != nil {
@@ -340,5 +503,9 @@ func mallsQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]
 	for i, row := range rows {
 		malls[i] = row.toModel()
 	}
+	err = loadMallHours(ctx, queryName, malls...)
+	if err != nil {
+		return nil, err
+	}
 	return malls, nil
 }
@@ -0,0 +1,1729 @@
+package db
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mallfin_api/models"
+	"mallfin_api/utils"
+
+	"github.com/pkg/errors"
+)
+
+const earthRadius = 6371000.0
+
+type memoryMall struct {
+	mall     *models.Mall
+	cityID   int
+	radius   float64
+	names    []string
+	stations []*models.SubwayStationWalk
+}
+
+type memoryShop struct {
+	shop  *models.Shop
+	names []string
+}
+
+type memoryStation struct {
+	station *models.SubwayStation
+	cityID  int
+}
+
+type memoryCity struct {
+	city     *models.City
+	location models.Location
+	radius   float64
+	// nil means the timezone from the config, like a NULL city_timezone
+	timeZone *string
+}
+
+type MemoryStore struct {
+	mutex          sync.RWMutex
+	malls          map[int]*memoryMall
+	shops          map[int]*memoryShop
+	categories     map[int]*models.Category
+	cities         map[int]*memoryCity
+	subwayStations map[int]*memoryStation
+	mallShops      map[int]map[int]bool
+	shopCategories map[int]map[int]bool
+	// by mall and shop, missing for the shops without placement
+	placements map[int]map[int]*models.ShopPlacement
+	// by mall and floor
+	floorPlans map[int]map[int]*models.FloorPlan
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		malls:          map[int]*memoryMall{},
+		shops:          map[int]*memoryShop{},
+		categories:     map[int]*models.Category{},
+		cities:         map[int]*memoryCity{},
+		subwayStations: map[int]*memoryStation{},
+		mallShops:      map[int]map[int]bool{},
+		shopCategories: map[int]map[int]bool{},
+		placements:     map[int]map[int]*models.ShopPlacement{},
+		floorPlans:     map[int]map[int]*models.FloorPlan{},
+	}
+}
+
+func (s *MemoryStore) AddCity(city *models.City, location models.Location, radius float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := *city
+	s.cities[city.ID] = &memoryCity{city: &c, location: location, radius: radius}
+}
+
+func (s *MemoryStore) SetCityTimeZone(cityID int, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	city, ok := s.cities[cityID]
+	if !ok {
+		panic(errors.Errorf("Unknown city %d", cityID))
+	}
+	city.timeZone = &name
+}
+
+func (s *MemoryStore) AddSubwayStation(station *models.SubwayStation, cityID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ss := *station
+	ss.CityID = cityID
+	s.subwayStations[station.ID] = &memoryStation{station: &ss, cityID: cityID}
+}
+
+func (s *MemoryStore) AddMall(mall *models.Mall, cityID int, radius float64, names ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m := *mall
+	s.malls[mall.ID] = &memoryMall{mall: &m, cityID: cityID, radius: radius, names: append([]string{mall.Name}, names...)}
+	if s.mallShops[mall.ID] == nil {
+		s.mallShops[mall.ID] = map[int]bool{}
+	}
+}
+
+func (s *MemoryStore) AddMallSubwayStation(mallID, stationID, walkDistance, walkMinutes int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mall, ok := s.malls[mallID]
+	if !ok {
+		panic(errors.Errorf("Unknown mall %d", mallID))
+	}
+	if _, ok := s.subwayStations[stationID]; !ok {
+		panic(errors.Errorf("Unknown subway station %d", stationID))
+	}
+	mall.stations = append(mall.stations, &models.SubwayStationWalk{StationID: stationID, WalkDistance: walkDistance, WalkMinutes: walkMinutes})
+}
+
+func (s *MemoryStore) AddFloorPlan(mallID int, plan *models.FloorPlan) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.malls[mallID]; !ok {
+		panic(errors.Errorf("Unknown mall %d", mallID))
+	}
+	for _, unit := range plan.Units {
+		if unit.ShopID != nil && !s.mallShops[mallID][*unit.ShopID] {
+			panic(errors.Errorf("Shop %d is not in mall %d", *unit.ShopID, mallID))
+		}
+	}
+	if s.floorPlans[mallID] == nil {
+		s.floorPlans[mallID] = map[int]*models.FloorPlan{}
+	}
+	s.floorPlans[mallID][plan.Floor] = copyFloorPlan(plan)
+}
+
+func (s *MemoryStore) AddShop(shop *models.Shop, names ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sh := *shop
+	s.shops[shop.ID] = &memoryShop{shop: &sh, names: append([]string{shop.Name}, names...)}
+	if s.shopCategories[shop.ID] == nil {
+		s.shopCategories[shop.ID] = map[int]bool{}
+	}
+}
+
+func (s *MemoryStore) AddCategory(category *models.Category) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := *category
+	s.categories[category.ID] = &c
+}
+
+func (s *MemoryStore) AddShopToMall(shopID, mallID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	shop, ok := s.shops[shopID]
+	if !ok {
+		panic(errors.Errorf("Unknown shop %d", shopID))
+	}
+	mall, ok := s.malls[mallID]
+	if !ok {
+		panic(errors.Errorf("Unknown mall %d", mallID))
+	}
+	if s.mallShops[mallID][shopID] {
+		return
+	}
+	s.mallShops[mallID][shopID] = true
+	mall.mall.ShopsCount++
+	shop.shop.MallsCount++
+}
+
+func (s *MemoryStore) AddShopToCategory(shopID, categoryID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.shops[shopID]; !ok {
+		panic(errors.Errorf("Unknown shop %d", shopID))
+	}
+	category, ok := s.categories[categoryID]
+	if !ok {
+		panic(errors.Errorf("Unknown category %d", categoryID))
+	}
+	if s.shopCategories[shopID][categoryID] {
+		return
+	}
+	s.shopCategories[shopID][categoryID] = true
+	category.ShopsCount++
+}
+
+func (s *MemoryStore) GetMallDetails(ctx context.Context, mallID int) (*models.Mall, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	m, ok := s.malls[mallID]
+	if !ok {
+		return nil, nil
+	}
+	return s.mallDetails(m), nil
+}
+
+func (s *MemoryStore) GetMallByLocation(ctx context.Context, location *models.Location) (*models.Mall, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var nearest *memoryMall
+	var nearestDistance float64
+	for _, m := range s.sortedMalls() {
+		distance := geoDistance(&m.mall.Location, location)
+		if distance > m.radius {
+			continue
+		}
+		if nearest == nil || distance < nearestDistance {
+			nearest = m
+			nearestDistance = distance
+		}
+	}
+	if nearest == nil {
+		return nil, nil
+	}
+	return s.mallDetails(nearest), nil
+}
+
+func (s *MemoryStore) GetMalls(ctx context.Context, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMalls(func(m *memoryMall) bool {
+		return m.cityID == cityID && s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallsWithoutCity(ctx context.Context, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMalls(func(m *memoryMall) bool {
+		return s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallsByIDs(ctx context.Context, mallIDs []int) ([]*models.Mall, error) {
+	if len(mallIDs) == 0 {
+		return nil, nil
+	}
+	ids := intSet(mallIDs)
+	malls := s.filterMalls(func(m *memoryMall) bool {
+		return ids[m.mall.ID]
+	})
+	return malls, nil
+}
+
+func (s *MemoryStore) GetMallsBySubwayStation(ctx context.Context, subwayStationID int, maxWalkMinutes *int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMalls(func(m *memoryMall) bool {
+		return isNearStation(m, subwayStationID, maxWalkMinutes) && s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallsByShop(ctx context.Context, shopID, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMalls(func(m *memoryMall) bool {
+		return s.mallShops[m.mall.ID][shopID] && m.cityID == cityID && s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallsByShopWithoutCity(ctx context.Context, shopID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMalls(func(m *memoryMall) bool {
+		return s.mallShops[m.mall.ID][shopID] && s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallsByName(ctx context.Context, name string, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMallsByName(name, func(m *memoryMall) bool {
+		return m.cityID == cityID && s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallsByNameWithoutCity(ctx context.Context, name string, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error) {
+	malls := s.filterMallsByName(name, func(m *memoryMall) bool {
+		return s.isOpenAt(m, openAt) && matchGeo(m, geo)
+	})
+	return paginateMalls(malls, geo, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetMallLocations(ctx context.Context, filter *models.MallFilter) ([]*models.Mall, error) {
+	match := func(m *memoryMall) bool {
+		return (filter.City == nil || m.cityID == *filter.City) &&
+			(filter.Shop == nil || s.mallShops[m.mall.ID][*filter.Shop]) &&
+			(filter.SubwayStation == nil || isNearStation(m, *filter.SubwayStation, filter.MaxWalkMinutes)) &&
+			s.isOpenAt(m, filter.OpenAt) && matchGeo(m, filter.Geo)
+	}
+	var malls []*models.Mall
+	if filter.Query != nil {
+		malls = s.filterMallsByName(*filter.Query, match)
+	} else {
+		malls = s.filterMalls(match)
+	}
+	locations := make([]*models.Mall, len(malls))
+	for i, mall := range malls {
+		locations[i] = &models.Mall{ID: mall.ID, Location: mall.Location}
+	}
+	return locations, nil
+}
+
+func (s *MemoryStore) GetShopsInMalls(ctx context.Context, mallIDs, shopIDs []int) ([]*models.MallMatchedShops, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	requestedShops := intSet(shopIDs)
+	var matchedShops []*models.MallMatchedShops
+	var notMatched []int
+	for _, mallID := range mallIDs {
+		var shops []int
+		for _, shopID := range sortedKeys(s.mallShops[mallID]) {
+			if requestedShops[shopID] {
+				shops = append(shops, shopID)
+			}
+		}
+		if len(shops) == 0 {
+			notMatched = append(notMatched, mallID)
+			continue
+		}
+		placements := make([]*models.ShopPlacement, len(shops))
+		for i, shopID := range shops {
+			placements[i] = s.placement(mallID, shopID)
+		}
+		matchedShops = append(matchedShops, &models.MallMatchedShops{MallID: mallID, ShopIDs: shops, Placements: placements})
+	}
+	sort.SliceStable(matchedShops, func(i, j int) bool {
+		return len(matchedShops[i].ShopIDs) > len(matchedShops[j].ShopIDs)
+	})
+	for _, mallID := range notMatched {
+		matchedShops = append(matchedShops, &models.MallMatchedShops{MallID: mallID, ShopIDs: []int{}, Placements: []*models.ShopPlacement{}})
+	}
+	return matchedShops, nil
+}
+
+func (s *MemoryStore) MallsCount(ctx context.Context, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMalls(ctx, cityID, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) MallsWithoutCityCount(ctx context.Context, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMallsWithoutCity(ctx, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) MallsByNameCount(ctx context.Context, name string, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMallsByName(ctx, name, cityID, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) MallsByNameWithoutCityCount(ctx context.Context, name string, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMallsByNameWithoutCity(ctx, name, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) MallsByShopCount(ctx context.Context, shopID, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMallsByShop(ctx, shopID, cityID, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) MallsByShopWithoutCityCount(ctx context.Context, shopID int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMallsByShopWithoutCity(ctx, shopID, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) MallsBySubwayStationCount(ctx context.Context, subwayStationID int, maxWalkMinutes *int, openAt *time.Time, geo *models.GeoFilter) (int, error) {
+	malls, _ := s.GetMallsBySubwayStation(ctx, subwayStationID, maxWalkMinutes, openAt, geo, nil, nil, nil, nil)
+	return len(malls), nil
+}
+
+func (s *MemoryStore) IsMallExists(ctx context.Context, mallID int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.malls[mallID]
+	return ok, nil
+}
+
+func (s *MemoryStore) IsSubwayStationExists(ctx context.Context, subwayStationID int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.subwayStations[subwayStationID]
+	return ok, nil
+}
+
+func (s *MemoryStore) CreateMall(ctx context.Context, changes *models.MallChanges) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mallID := 1
+	for id := range s.malls {
+		if id >= mallID {
+			mallID = id + 1
+		}
+	}
+	m := &memoryMall{mall: &models.Mall{ID: mallID}}
+	s.malls[mallID] = m
+	s.mallShops[mallID] = map[int]bool{}
+	s.applyMallChanges(m, changes)
+	return mallID, nil
+}
+
+func (s *MemoryStore) UpdateMall(ctx context.Context, mallID int, changes *models.MallChanges) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.malls[mallID]
+	if !ok {
+		return false, nil
+	}
+	s.applyMallChanges(m, changes)
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteMall(ctx context.Context, mallID int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.malls[mallID]; !ok {
+		return false, nil
+	}
+	for shopID := range s.mallShops[mallID] {
+		s.shops[shopID].shop.MallsCount--
+	}
+	delete(s.mallShops, mallID)
+	delete(s.placements, mallID)
+	delete(s.floorPlans, mallID)
+	delete(s.malls, mallID)
+	return true, nil
+}
+
+func (s *MemoryStore) GetShopDetails(ctx context.Context, shopID int) (*models.Shop, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sh, ok := s.shops[shopID]
+	if !ok {
+		return nil, nil
+	}
+	return copyShop(sh.shop), nil
+}
+
+func (s *MemoryStore) GetShopDetailsWithLocation(ctx context.Context, shopID int, location *models.Location) (*models.Shop, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sh, ok := s.shops[shopID]
+	if !ok {
+		return nil, nil
+	}
+	nearest, nearestDistance := s.nearestMall(shopID, location)
+	if nearest == nil {
+		return nil, nil
+	}
+	shop := copyShop(sh.shop)
+	shop.NearestMall = s.copyMall(nearest)
+	shop.NearestDistance = &nearestDistance
+	return shop, nil
+}
+
+// nearestMall must be called under the read lock, nil if the shop is in no mall.
+func (s *MemoryStore) nearestMall(shopID int, location *models.Location) (*memoryMall, float64) {
+	var nearest *memoryMall
+	var nearestDistance float64
+	for _, m := range s.sortedMalls() {
+		if !s.mallShops[m.mall.ID][shopID] {
+			continue
+		}
+		distance := geoDistance(&m.mall.Location, location)
+		if nearest == nil || distance < nearestDistance {
+			nearest = m
+			nearestDistance = distance
+		}
+	}
+	return nearest, nearestDistance
+}
+
+func (s *MemoryStore) setNearestMalls(shops []*models.Shop, location *models.Location) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, shop := range shops {
+		nearest, nearestDistance := s.nearestMall(shop.ID, location)
+		if nearest != nil {
+			shop.NearestMall = s.copyMall(nearest)
+			shop.NearestDistance = &nearestDistance
+		}
+	}
+}
+
+func (s *MemoryStore) GetShops(ctx context.Context, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return s.isShopInCity(sh.shop.ID, cityID)
+	})
+	return s.paginateShops(shops, location, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetShopsWithoutCity(ctx context.Context, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return true
+	})
+	return s.paginateShops(shops, location, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetShopsByMall(ctx context.Context, mallID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return s.mallShops[mallID][sh.shop.ID]
+	})
+	shops = s.paginateShops(shops, location, sorting, limit, offset, cursor)
+	s.setPlacements(mallID, shops)
+	return shops, nil
+}
+
+func (s *MemoryStore) GetMallDirectory(ctx context.Context, mallID int) ([]*models.Shop, error) {
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return s.mallShops[mallID][sh.shop.ID]
+	})
+	s.setPlacements(mallID, shops)
+	sort.SliceStable(shops, func(i, j int) bool {
+		a, b := shops[i].Placement, shops[j].Placement
+		if (a.Floor == nil) != (b.Floor == nil) {
+			return b.Floor == nil
+		}
+		if a.Floor != nil && *a.Floor != *b.Floor {
+			return *a.Floor < *b.Floor
+		}
+		if a.Unit != b.Unit {
+			return a.Unit < b.Unit
+		}
+		return shops[i].Name < shops[j].Name
+	})
+	return shops, nil
+}
+
+func (s *MemoryStore) SetShopPlacement(ctx context.Context, shopID, mallID int, placement *models.ShopPlacement) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.mallShops[mallID][shopID] {
+		return false, nil
+	}
+	if s.placements[mallID] == nil {
+		s.placements[mallID] = map[int]*models.ShopPlacement{}
+	}
+	p := *placement
+	s.placements[mallID][shopID] = &p
+	return true, nil
+}
+
+func (s *MemoryStore) GetShopsByIDs(ctx context.Context, shopIDs []int) ([]*models.Shop, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	ids := intSet(shopIDs)
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return ids[sh.shop.ID]
+	})
+	return shops, nil
+}
+
+func (s *MemoryStore) GetShopsByName(ctx context.Context, name string, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShopsByName(name, func(sh *memoryShop) bool {
+		return s.isShopInCity(sh.shop.ID, cityID)
+	})
+	return s.paginateShops(shops, location, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetShopsByNameWithoutCity(ctx context.Context, name string, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShopsByName(name, func(sh *memoryShop) bool {
+		return true
+	})
+	return s.paginateShops(shops, location, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetShopsByCategory(ctx context.Context, categoryID, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return s.shopCategories[sh.shop.ID][categoryID] && s.isShopInCity(sh.shop.ID, cityID)
+	})
+	return s.paginateShops(shops, location, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetShopsByCategoryWithoutCity(ctx context.Context, categoryID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	shops := s.filterShops(func(sh *memoryShop) bool {
+		return s.shopCategories[sh.shop.ID][categoryID]
+	})
+	return s.paginateShops(shops, location, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) ShopsCount(ctx context.Context, cityID int) (int, error) {
+	shops, _ := s.GetShops(ctx, cityID, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) ShopsWithoutCityCount(ctx context.Context) (int, error) {
+	shops, _ := s.GetShopsWithoutCity(ctx, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) ShopsByMallCount(ctx context.Context, mallID int) (int, error) {
+	shops, _ := s.GetShopsByMall(ctx, mallID, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) ShopsByNameCount(ctx context.Context, name string, cityID int) (int, error) {
+	shops, _ := s.GetShopsByName(ctx, name, cityID, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) ShopsByNameWithoutCityCount(ctx context.Context, name string) (int, error) {
+	shops, _ := s.GetShopsByNameWithoutCity(ctx, name, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) ShopsByCategoryCount(ctx context.Context, categoryID, cityID int) (int, error) {
+	shops, _ := s.GetShopsByCategory(ctx, categoryID, cityID, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) ShopsByCategoryWithoutCityCount(ctx context.Context, categoryID int) (int, error) {
+	shops, _ := s.GetShopsByCategoryWithoutCity(ctx, categoryID, nil, nil, nil, nil, nil)
+	return len(shops), nil
+}
+
+func (s *MemoryStore) IsShopExists(ctx context.Context, shopID int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.shops[shopID]
+	return ok, nil
+}
+
+func (s *MemoryStore) CreateShop(ctx context.Context, changes *models.ShopChanges) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	shopID := 1
+	for id := range s.shops {
+		if id >= shopID {
+			shopID = id + 1
+		}
+	}
+	sh := &memoryShop{shop: &models.Shop{ID: shopID}}
+	s.shops[shopID] = sh
+	s.shopCategories[shopID] = map[int]bool{}
+	applyShopChanges(sh, changes)
+	return shopID, nil
+}
+
+func (s *MemoryStore) UpdateShop(ctx context.Context, shopID int, changes *models.ShopChanges) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sh, ok := s.shops[shopID]
+	if !ok {
+		return false, nil
+	}
+	applyShopChanges(sh, changes)
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteShop(ctx context.Context, shopID int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.shops[shopID]; !ok {
+		return false, nil
+	}
+	for mallID, shops := range s.mallShops {
+		if shops[shopID] {
+			delete(shops, shopID)
+			delete(s.placements[mallID], shopID)
+			s.unlinkUnits(mallID, shopID)
+			s.malls[mallID].mall.ShopsCount--
+		}
+	}
+	for categoryID := range s.shopCategories[shopID] {
+		s.categories[categoryID].ShopsCount--
+	}
+	delete(s.shopCategories, shopID)
+	delete(s.shops, shopID)
+	return true, nil
+}
+
+func (s *MemoryStore) ChangeShopMalls(ctx context.Context, shopID int, attachMallIDs, detachMallIDs []int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sh, ok := s.shops[shopID]
+	if !ok {
+		return false, nil
+	}
+	for _, mallID := range attachMallIDs {
+		m, ok := s.malls[mallID]
+		if !ok || s.mallShops[mallID][shopID] {
+			continue
+		}
+		s.mallShops[mallID][shopID] = true
+		m.mall.ShopsCount++
+		sh.shop.MallsCount++
+	}
+	for _, mallID := range detachMallIDs {
+		if !s.mallShops[mallID][shopID] {
+			continue
+		}
+		delete(s.mallShops[mallID], shopID)
+		delete(s.placements[mallID], shopID)
+		s.unlinkUnits(mallID, shopID)
+		s.malls[mallID].mall.ShopsCount--
+		sh.shop.MallsCount--
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) ChangeShopCategories(ctx context.Context, shopID int, attachCategoryIDs, detachCategoryIDs []int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.shops[shopID]; !ok {
+		return false, nil
+	}
+	categories := s.shopCategories[shopID]
+	for _, categoryID := range attachCategoryIDs {
+		category, ok := s.categories[categoryID]
+		if !ok || categories[categoryID] {
+			continue
+		}
+		categories[categoryID] = true
+		category.ShopsCount++
+	}
+	for _, categoryID := range detachCategoryIDs {
+		if !categories[categoryID] {
+			continue
+		}
+		delete(categories, categoryID)
+		s.categories[categoryID].ShopsCount--
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) GetCategoryDetails(ctx context.Context, categoryID int) (*models.Category, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	category, ok := s.categories[categoryID]
+	if !ok {
+		return nil, nil
+	}
+	c := *category
+	return &c, nil
+}
+
+func (s *MemoryStore) GetCategories(ctx context.Context, sorting models.Sorting) ([]*models.Category, error) {
+	categories := s.filterCategories(func(c *models.Category) bool {
+		return true
+	})
+	sortCategories(categories, sorting)
+	return categories, nil
+}
+
+func (s *MemoryStore) GetCategoriesByIDs(ctx context.Context, categoryIDs []int) ([]*models.Category, error) {
+	ids := intSet(categoryIDs)
+	categories := s.filterCategories(func(c *models.Category) bool {
+		return ids[c.ID]
+	})
+	return categories, nil
+}
+
+func (s *MemoryStore) GetCategoriesByShop(ctx context.Context, shopID int, sorting models.Sorting) ([]*models.Category, error) {
+	categories := s.filterCategories(func(c *models.Category) bool {
+		return s.shopCategories[shopID][c.ID]
+	})
+	sortCategories(categories, sorting)
+	return categories, nil
+}
+
+func (s *MemoryStore) IsCategoryExists(ctx context.Context, categoryID int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.categories[categoryID]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetCities(ctx context.Context, sorting models.Sorting) ([]*models.City, error) {
+	cities := s.filterCities(func(c *memoryCity) bool {
+		return true
+	})
+	sortCities(cities, sorting)
+	return cities, nil
+}
+
+func (s *MemoryStore) GetCitiesByName(ctx context.Context, name string, sorting models.Sorting) ([]*models.City, error) {
+	cities := s.filterCities(func(c *memoryCity) bool {
+		return matchNames([]string{c.city.Name}, name)
+	})
+	sortCities(cities, sorting)
+	return cities, nil
+}
+
+func (s *MemoryStore) GetCityByLocation(ctx context.Context, location *models.Location) (*models.City, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var nearest *memoryCity
+	var nearestDistance float64
+	for _, cityID := range sortedKeys(s.cities) {
+		c := s.cities[cityID]
+		distance := geoDistance(&c.location, location)
+		if distance > c.radius {
+			continue
+		}
+		if nearest == nil || distance < nearestDistance {
+			nearest = c
+			nearestDistance = distance
+		}
+	}
+	if nearest == nil {
+		return nil, nil
+	}
+	city := *nearest.city
+	return &city, nil
+}
+
+func (s *MemoryStore) IsCityExists(ctx context.Context, cityID int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.cities[cityID]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetSubwayStationDetails(ctx context.Context, stationID int) (*models.SubwayStation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	station, ok := s.subwayStations[stationID]
+	if !ok {
+		return nil, nil
+	}
+	ss := *station.station
+	return &ss, nil
+}
+
+func (s *MemoryStore) GetSubwayStations(ctx context.Context, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	stations := s.filterStations(nil, 0, func(ss *memoryStation) bool {
+		return ss.cityID == cityID
+	})
+	sortStations(stations, sorting)
+	return stations, nil
+}
+
+func (s *MemoryStore) GetSubwayStationsWithoutCity(ctx context.Context, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	stations := s.filterStations(nil, 0, func(ss *memoryStation) bool {
+		return true
+	})
+	sortStations(stations, sorting)
+	return stations, nil
+}
+
+func (s *MemoryStore) GetSubwayStationsByName(ctx context.Context, name string, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	stations := s.filterStations(nil, 0, func(ss *memoryStation) bool {
+		return ss.cityID == cityID && matchNames([]string{ss.station.Name}, name)
+	})
+	sortStations(stations, sorting)
+	return stations, nil
+}
+
+func (s *MemoryStore) GetSubwayStationsByNameWithoutCity(ctx context.Context, name string, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	stations := s.filterStations(nil, 0, func(ss *memoryStation) bool {
+		return matchNames([]string{ss.station.Name}, name)
+	})
+	sortStations(stations, sorting)
+	return stations, nil
+}
+
+func (s *MemoryStore) GetSubwayStationsNear(ctx context.Context, location *models.Location, radius float64, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	stations := s.filterStations(location, radius, func(ss *memoryStation) bool {
+		return ss.cityID == cityID
+	})
+	sortStations(stations, sorting)
+	return stations, nil
+}
+
+func (s *MemoryStore) GetSubwayStationsNearWithoutCity(ctx context.Context, location *models.Location, radius float64, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	stations := s.filterStations(location, radius, func(ss *memoryStation) bool {
+		return true
+	})
+	sortStations(stations, sorting)
+	return stations, nil
+}
+
+func (s *MemoryStore) GetNearestSubwayStation(ctx context.Context, location *models.Location) (*models.SubwayStation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var nearest *memoryStation
+	var nearestDistance float64
+	for _, stationID := range sortedKeys(s.subwayStations) {
+		station := s.subwayStations[stationID]
+		if station.station.Location == nil {
+			continue
+		}
+		distance := geoDistance(station.station.Location, location)
+		if nearest == nil || distance < nearestDistance {
+			nearest = station
+			nearestDistance = distance
+		}
+	}
+	if nearest == nil {
+		return nil, nil
+	}
+	ss := *nearest.station
+	ss.Distance = &nearestDistance
+	return &ss, nil
+}
+
+func (s *MemoryStore) GetMallFloors(ctx context.Context, mallID int) ([]*models.FloorPlan, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	plans := []*models.FloorPlan{}
+	for _, floor := range sortedKeys(s.floorPlans[mallID]) {
+		plan := *s.floorPlans[mallID][floor]
+		plan.UnitsCount = len(plan.Units)
+		plan.Units = nil
+		plans = append(plans, &plan)
+	}
+	return plans, nil
+}
+
+func (s *MemoryStore) GetFloorPlan(ctx context.Context, mallID, floor int) (*models.FloorPlan, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	plan, ok := s.floorPlans[mallID][floor]
+	if !ok {
+		return nil, nil
+	}
+	p := copyFloorPlan(plan)
+	sort.SliceStable(p.Units, func(i, j int) bool {
+		if p.Units[i].Unit != p.Units[j].Unit {
+			return p.Units[i].Unit < p.Units[j].Unit
+		}
+		return p.Units[i].ID < p.Units[j].ID
+	})
+	p.UnitsCount = len(p.Units)
+	return p, nil
+}
+
+func (s *MemoryStore) GetFloorUnitAt(ctx context.Context, mallID, floor int, point models.IndoorPoint) (*models.FloorUnit, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	plan, ok := s.floorPlans[mallID][floor]
+	if !ok {
+		return nil, nil
+	}
+	var found *models.FloorUnit
+	for _, unit := range plan.Units {
+		if !unit.Contains(point) {
+			continue
+		}
+		if found == nil || unit.Area() < found.Area() || unit.Area() == found.Area() && unit.ID < found.ID {
+			found = unit
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	u := *found
+	return &u, nil
+}
+
+func (s *MemoryStore) GetSearchResults(ctx context.Context, shopIDs []int, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	results := s.searchResults(shopIDs, nil, nil, &cityID, openAt)
+	return paginateSearchResults(results, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetSearchResultsWithoutCity(ctx context.Context, shopIDs []int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	results := s.searchResults(shopIDs, nil, nil, nil, openAt)
+	return paginateSearchResults(results, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetSearchResultsWithDistance(ctx context.Context, shopIDs []int, location *models.Location, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	results := s.searchResults(shopIDs, location, nil, &cityID, openAt)
+	return paginateSearchResults(results, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetSearchResultsWithDistanceWithoutCity(ctx context.Context, shopIDs []int, location *models.Location, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	results := s.searchResults(shopIDs, location, nil, nil, openAt)
+	return paginateSearchResults(results, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) SearchResultsCount(ctx context.Context, shopIDs []int, cityID int, openAt *time.Time) (int, error) {
+	return len(s.searchResults(shopIDs, nil, nil, &cityID, openAt)), nil
+}
+
+func (s *MemoryStore) SearchResultsWithoutCityCount(ctx context.Context, shopIDs []int, openAt *time.Time) (int, error) {
+	return len(s.searchResults(shopIDs, nil, nil, nil, openAt)), nil
+}
+
+func (s *MemoryStore) GetSearchResultsAlongRoute(ctx context.Context, shopIDs []int, route *models.RouteFilter, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	results := s.searchResults(shopIDs, nil, route, &cityID, openAt)
+	return paginateSearchResults(results, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) GetSearchResultsAlongRouteWithoutCity(ctx context.Context, shopIDs []int, route *models.RouteFilter, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	results := s.searchResults(shopIDs, nil, route, nil, openAt)
+	return paginateSearchResults(results, sorting, limit, offset, cursor), nil
+}
+
+func (s *MemoryStore) SearchResultsAlongRouteCount(ctx context.Context, shopIDs []int, route *models.RouteFilter, cityID int, openAt *time.Time) (int, error) {
+	return len(s.searchResults(shopIDs, nil, route, &cityID, openAt)), nil
+}
+
+func (s *MemoryStore) SearchResultsAlongRouteWithoutCityCount(ctx context.Context, shopIDs []int, route *models.RouteFilter, openAt *time.Time) (int, error) {
+	return len(s.searchResults(shopIDs, nil, route, nil, openAt)), nil
+}
+
+func (s *MemoryStore) searchResults(shopIDs []int, location *models.Location, route *models.RouteFilter, cityID *int, openAt *time.Time) []*models.SearchResult {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	requestedShops := intSet(shopIDs)
+	var results []*models.SearchResult
+	for _, m := range s.sortedMalls() {
+		if cityID != nil && m.cityID != *cityID || !s.isOpenAt(m, openAt) {
+			continue
+		}
+		var shops []int
+		for _, shopID := range sortedKeys(s.mallShops[m.mall.ID]) {
+			if requestedShops[shopID] {
+				shops = append(shops, shopID)
+			}
+		}
+		if len(shops) == 0 {
+			continue
+		}
+		result := &models.SearchResult{Mall: s.copyMall(m), ShopIDs: shops}
+		if location != nil {
+			distance := geoDistance(&m.mall.Location, location)
+			result.Distance = &distance
+		}
+		if route != nil {
+			routeDistance, routePosition := locateOnPath(route.Path, &m.mall.Location)
+			if routeDistance > route.MaxDetour {
+				continue
+			}
+			result.RouteDistance = &routeDistance
+			result.RoutePosition = &routePosition
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (s *MemoryStore) GetSuggestions(ctx context.Context, query string, cityID *int, limit int) ([]*models.Suggestion, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	inCity := func(id int) bool {
+		return cityID == nil || id == *cityID
+	}
+	var suggestions []*models.Suggestion
+	add := func(suggestionType string, id int, name string, names []string) {
+		var best *models.Suggestion
+		for _, alias := range names {
+			score, ok := suggestionScore(query, alias)
+			if ok && (best == nil || score > best.Score) {
+				best = &models.Suggestion{Type: suggestionType, ID: id, Name: name, MatchedAlias: alias, Score: score}
+			}
+		}
+		if best != nil {
+			suggestions = append(suggestions, best)
+		}
+	}
+	for _, m := range s.sortedMalls() {
+		if inCity(m.cityID) {
+			add(models.MallSuggestion, m.mall.ID, m.mall.Name, m.names)
+		}
+	}
+	for _, shopID := range sortedKeys(s.shops) {
+		sh := s.shops[shopID]
+		if cityID == nil || s.isShopInCity(shopID, *cityID) {
+			add(models.ShopSuggestion, shopID, sh.shop.Name, sh.names)
+		}
+	}
+	for _, categoryID := range sortedKeys(s.categories) {
+		category := s.categories[categoryID]
+		add(models.CategorySuggestion, categoryID, category.Name, []string{category.Name})
+	}
+	for _, stationID := range sortedKeys(s.subwayStations) {
+		station := s.subwayStations[stationID]
+		if inCity(station.cityID) {
+			add(models.SubwayStationSuggestion, stationID, station.station.Name, []string{station.station.Name})
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+func (s *MemoryStore) isShopInCity(shopID, cityID int) bool {
+	for mallID, shops := range s.mallShops {
+		if shops[shopID] && s.malls[mallID].cityID == cityID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) isOpenAt(m *memoryMall, openAt *time.Time) bool {
+	return openAt == nil || s.copyMall(m).IsOpenAt(*openAt)
+}
+
+func isNearStation(m *memoryMall, stationID int, maxWalkMinutes *int) bool {
+	for _, station := range m.stations {
+		if station.StationID == stationID && (maxWalkMinutes == nil || station.WalkMinutes <= *maxWalkMinutes) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGeo(m *memoryMall, geo *models.GeoFilter) bool {
+	if geo == nil {
+		return true
+	}
+	if geo.Near != nil && geo.Radius != nil && geoDistance(&m.mall.Location, geo.Near) > *geo.Radius {
+		return false
+	}
+	return geo.BBox == nil || geo.BBox.Contains(&m.mall.Location)
+}
+
+func (s *MemoryStore) sortedMalls() []*memoryMall {
+	malls := make([]*memoryMall, 0, len(s.malls))
+	for _, mallID := range sortedKeys(s.malls) {
+		malls = append(malls, s.malls[mallID])
+	}
+	return malls
+}
+
+func (s *MemoryStore) filterMalls(match func(*memoryMall) bool) []*models.Mall {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var malls []*models.Mall
+	for _, m := range s.sortedMalls() {
+		if match(m) {
+			malls = append(malls, s.copyMall(m))
+		}
+	}
+	return malls
+}
+
+// placement copies the placement of the shop in the mall, empty if it isn't set.
+func (s *MemoryStore) placement(mallID, shopID int) *models.ShopPlacement {
+	placement, ok := s.placements[mallID][shopID]
+	if !ok {
+		return &models.ShopPlacement{}
+	}
+	p := *placement
+	return &p
+}
+
+func (s *MemoryStore) setPlacements(mallID int, shops []*models.Shop) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, shop := range shops {
+		shop.Placement = s.placement(mallID, shop.ID)
+	}
+}
+
+func (s *MemoryStore) filterShops(match func(*memoryShop) bool) []*models.Shop {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var shops []*models.Shop
+	for _, shopID := range sortedKeys(s.shops) {
+		sh := s.shops[shopID]
+		if match(sh) {
+			shops = append(shops, copyShop(sh.shop))
+		}
+	}
+	return shops
+}
+
+// filterMallsByName sets the relevance of the malls with a matching name or alias.
+func (s *MemoryStore) filterMallsByName(name string, match func(*memoryMall) bool) []*models.Mall {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var malls []*models.Mall
+	for _, m := range s.sortedMalls() {
+		relevance, ok := nameRelevance(m.names, name)
+		if ok && match(m) {
+			mall := s.copyMall(m)
+			mall.Relevance = relevance
+			malls = append(malls, mall)
+		}
+	}
+	return malls
+}
+
+func (s *MemoryStore) filterShopsByName(name string, match func(*memoryShop) bool) []*models.Shop {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var shops []*models.Shop
+	for _, shopID := range sortedKeys(s.shops) {
+		sh := s.shops[shopID]
+		relevance, ok := nameRelevance(sh.names, name)
+		if ok && match(sh) {
+			shop := copyShop(sh.shop)
+			shop.Relevance = relevance
+			shops = append(shops, shop)
+		}
+	}
+	return shops
+}
+
+func (s *MemoryStore) filterCategories(match func(*models.Category) bool) []*models.Category {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	categories := []*models.Category{}
+	for _, categoryID := range sortedKeys(s.categories) {
+		category := s.categories[categoryID]
+		if match(category) {
+			c := *category
+			categories = append(categories, &c)
+		}
+	}
+	return categories
+}
+
+func (s *MemoryStore) filterCities(match func(*memoryCity) bool) []*models.City {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	cities := []*models.City{}
+	for _, cityID := range sortedKeys(s.cities) {
+		city := s.cities[cityID]
+		if match(city) {
+			c := *city.city
+			cities = append(cities, &c)
+		}
+	}
+	return cities
+}
+
+// filterStations keeps only the stations within the radius around the location, if it's given.
+func (s *MemoryStore) filterStations(location *models.Location, radius float64, match func(*memoryStation) bool) []*models.SubwayStation {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	stations := []*models.SubwayStation{}
+	for _, stationID := range sortedKeys(s.subwayStations) {
+		station := s.subwayStations[stationID]
+		if !match(station) {
+			continue
+		}
+		ss := *station.station
+		if location != nil {
+			if ss.Location == nil {
+				continue
+			}
+			distance := geoDistance(ss.Location, location)
+			if distance > radius {
+				continue
+			}
+			ss.Distance = &distance
+		}
+		stations = append(stations, &ss)
+	}
+	return stations
+}
+
+func (s *MemoryStore) applyMallChanges(m *memoryMall, changes *models.MallChanges) {
+	mall := m.mall
+	if changes.Name != nil {
+		mall.Name = *changes.Name
+	}
+	if changes.Phone != nil {
+		mall.Phone = *changes.Phone
+	}
+	if changes.Logo != nil {
+		mall.Logo = *changes.Logo
+	}
+	if changes.Location != nil {
+		mall.Location = *changes.Location
+	}
+	if changes.Radius != nil {
+		m.radius = *changes.Radius
+	}
+	if changes.Address != nil {
+		mall.Address = *changes.Address
+	}
+	if changes.Site != nil {
+		mall.Site = *changes.Site
+	}
+	if changes.SubwayStations != nil {
+		m.stations = make([]*models.SubwayStationWalk, len(changes.SubwayStations))
+		for i, station := range changes.SubwayStations {
+			st := *station
+			m.stations[i] = &st
+		}
+	}
+	if changes.DayAndNight != nil {
+		mall.DayAndNight = *changes.DayAndNight
+	}
+	if changes.CityID != nil {
+		m.cityID = *changes.CityID
+	}
+	aliases := changes.Aliases
+	if aliases == nil && len(m.names) != 0 {
+		aliases = m.names[1:]
+	}
+	m.names = append([]string{mall.Name}, aliases...)
+}
+
+func applyShopChanges(sh *memoryShop, changes *models.ShopChanges) {
+	shop := sh.shop
+	if changes.Name != nil {
+		shop.Name = *changes.Name
+	}
+	if changes.Phone != nil {
+		shop.Phone = *changes.Phone
+	}
+	if changes.Site != nil {
+		shop.Site = *changes.Site
+	}
+	if changes.Logo != nil {
+		shop.Logo = *changes.Logo
+	}
+	if changes.Score != nil {
+		shop.Score = *changes.Score
+	}
+	aliases := changes.Aliases
+	if aliases == nil && len(sh.names) != 0 {
+		aliases = sh.names[1:]
+	}
+	sh.names = append([]string{shop.Name}, aliases...)
+}
+
+// mallDetails copies the mall with the linked stations ordered by the walk distance.
+func (s *MemoryStore) mallDetails(m *memoryMall) *models.Mall {
+	mall := s.copyMall(m)
+	mall.SubwayStations = []*models.MallSubwayStation{}
+	for _, walk := range m.stations {
+		station, ok := s.subwayStations[walk.StationID]
+		if !ok {
+			continue
+		}
+		ss := *station.station
+		mall.SubwayStations = append(mall.SubwayStations, &models.MallSubwayStation{
+			Station:      &ss,
+			WalkDistance: walk.WalkDistance,
+			WalkMinutes:  walk.WalkMinutes,
+		})
+	}
+	sort.SliceStable(mall.SubwayStations, func(i, j int) bool {
+		a, b := mall.SubwayStations[i], mall.SubwayStations[j]
+		if a.WalkDistance != b.WalkDistance {
+			return a.WalkDistance < b.WalkDistance
+		}
+		return a.Station.ID < b.Station.ID
+	})
+	return mall
+}
+
+func (s *MemoryStore) unlinkUnits(mallID, shopID int) {
+	for _, plan := range s.floorPlans[mallID] {
+		for _, unit := range plan.Units {
+			if unit.ShopID != nil && *unit.ShopID == shopID {
+				unit.ShopID = nil
+			}
+		}
+	}
+}
+
+func copyFloorPlan(plan *models.FloorPlan) *models.FloorPlan {
+	p := *plan
+	p.Units = make([]*models.FloorUnit, len(plan.Units))
+	for i, unit := range plan.Units {
+		u := *unit
+		p.Units[i] = &u
+	}
+	return &p
+}
+
+// copyMall sets the timezone the way the postgres store does: the one of the city or the default from the config.
+func (s *MemoryStore) copyMall(m *memoryMall) *models.Mall {
+	mall := *m.mall
+	if mall.DayAndNight {
+		mall.WorkingHours = nil
+	}
+	var timeZoneName *string
+	if city, ok := s.cities[m.cityID]; ok {
+		timeZoneName = city.timeZone
+	}
+	mall.TimeZone = cityTimeZone(timeZoneName)
+	return &mall
+}
+
+func copyShop(shop *models.Shop) *models.Shop {
+	sh := *shop
+	return &sh
+}
+
+func paginateMalls(malls []*models.Mall, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) []*models.Mall {
+	if sorting == nil {
+		sorting = models.DefaultMallSorting
+	}
+	if origin := geo.Origin(); origin != nil {
+		for _, mall := range malls {
+			distance := geoDistance(&mall.Location, origin)
+			mall.Distance = &distance
+		}
+	}
+	start, end := paginate(len(malls), func(i int) []interface{} {
+		return malls[i].SortValues(sorting)
+	}, reflect.Swapper(malls), sortDirections(sorting, 0), limit, offset, cursor)
+	return malls[start:end]
+}
+
+func (s *MemoryStore) paginateShops(shops []*models.Shop, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) []*models.Shop {
+	if sorting == nil {
+		sorting = models.DefaultShopSorting
+	}
+	if location != nil {
+		s.setNearestMalls(shops, location)
+	}
+	start, end := paginate(len(shops), func(i int) []interface{} {
+		return shops[i].SortValues(sorting)
+	}, reflect.Swapper(shops), sortDirections(sorting, 0), limit, offset, cursor)
+	return shops[start:end]
+}
+
+func sortCategories(categories []*models.Category, sorting models.Sorting) {
+	if sorting == nil {
+		sorting = models.DefaultCategorySorting
+	}
+	var less func(a, b *models.Category) bool
+	switch sorting.Key() {
+	case models.IDSortKey:
+		less = func(a, b *models.Category) bool { return a.ID < b.ID }
+	case models.NameSortKey:
+		less = func(a, b *models.Category) bool { return a.Name < b.Name }
+	case models.ShopsCountSortKey:
+		less = func(a, b *models.Category) bool { return a.ShopsCount < b.ShopsCount }
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for category order by", sorting.Key()))
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		if sorting.Reversed() {
+			return less(categories[j], categories[i])
+		}
+		return less(categories[i], categories[j])
+	})
+}
+
+func sortCities(cities []*models.City, sorting models.Sorting) {
+	if sorting == nil {
+		sorting = models.DefaultCitySorting
+	}
+	var less func(a, b *models.City) bool
+	switch sorting.Key() {
+	case models.IDSortKey:
+		less = func(a, b *models.City) bool { return a.ID < b.ID }
+	case models.NameSortKey:
+		less = func(a, b *models.City) bool { return a.Name < b.Name }
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for city order by", sorting.Key()))
+	}
+	sort.SliceStable(cities, func(i, j int) bool {
+		if sorting.Reversed() {
+			return less(cities[j], cities[i])
+		}
+		return less(cities[i], cities[j])
+	})
+}
+
+func sortStations(stations []*models.SubwayStation, sorting models.Sorting) {
+	if sorting == nil {
+		sorting = models.DefaultStationSorting
+	}
+	var less func(a, b *models.SubwayStation) bool
+	switch sorting.Key() {
+	case models.IDSortKey:
+		less = func(a, b *models.SubwayStation) bool { return a.ID < b.ID }
+	case models.NameSortKey:
+		less = func(a, b *models.SubwayStation) bool { return a.Name < b.Name }
+	case models.DistanceSortKey:
+		// available only in location queries
+		less = func(a, b *models.SubwayStation) bool { return *a.Distance < *b.Distance }
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for subway station order by", sorting.Key()))
+	}
+	sort.SliceStable(stations, func(i, j int) bool {
+		if sorting.Reversed() {
+			return less(stations[j], stations[i])
+		}
+		return less(stations[i], stations[j])
+	})
+}
+
+func paginateSearchResults(results []*models.SearchResult, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) []*models.SearchResult {
+	if sorting == nil {
+		sorting = models.DefaultSearchSorting
+	}
+	start, end := paginate(len(results), func(i int) []interface{} {
+		return results[i].SortValues(sorting)
+	}, reflect.Swapper(results), sortDirections(sorting, 1), limit, offset, cursor)
+	return results[start:end]
+}
+
+// sortDirections mirrors OrderBy columns: leading columns are always descending,
+// the sort key and the id tie breaker follow the sorting direction.
+func sortDirections(sorting models.Sorting, leading int) []bool {
+	desc := make([]bool, leading, leading+2)
+	for i := range desc {
+		desc[i] = true
+	}
+	desc = append(desc, models.IsDescending(sorting))
+	if sorting.Key() != models.IDSortKey && sorting.Key() != models.MallIDSortKey {
+		desc = append(desc, models.IsDescending(sorting))
+	}
+	return desc
+}
+
+func paginate(length int, values func(int) []interface{}, swap func(i, j int), desc []bool,
+	limit, offset *int, cursor *models.Cursor) (int, int) {
+
+	keys := make([][]interface{}, length)
+	for i := range keys {
+		keys[i] = values(i)
+	}
+	sort.Sort(&keysetSorter{keys: keys, swap: swap, desc: desc})
+	if cursor == nil {
+		return pageBounds(length, limit, offset)
+	}
+	if !cursor.Backward {
+		start := sort.Search(length, func(i int) bool {
+			return compareSortValues(keys[i], cursor.Values, desc) > 0
+		})
+		end := length
+		if limit != nil && start+*limit < end {
+			end = start + *limit
+		}
+		return start, end
+	}
+	end := sort.Search(length, func(i int) bool {
+		return compareSortValues(keys[i], cursor.Values, desc) >= 0
+	})
+	start := 0
+	if limit != nil && end-*limit > start {
+		start = end - *limit
+	}
+	return start, end
+}
+
+type keysetSorter struct {
+	keys [][]interface{}
+	swap func(i, j int)
+	desc []bool
+}
+
+func (ks *keysetSorter) Len() int {
+	return len(ks.keys)
+}
+
+func (ks *keysetSorter) Less(i, j int) bool {
+	return compareSortValues(ks.keys[i], ks.keys[j], ks.desc) < 0
+}
+
+func (ks *keysetSorter) Swap(i, j int) {
+	ks.keys[i], ks.keys[j] = ks.keys[j], ks.keys[i]
+	ks.swap(i, j)
+}
+
+func compareSortValues(a, b []interface{}, desc []bool) int {
+	for i := range a {
+		var result int
+		switch av := a[i].(type) {
+		case string:
+			result = strings.Compare(av, b[i].(string))
+		case int:
+			result = compareFloats(float64(av), toFloat(b[i]))
+		case float64:
+			result = compareFloats(av, toFloat(b[i]))
+		}
+		if desc[i] {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+	panic(errors.Errorf("Unexpected sort value %v", value))
+}
+
+func pageBounds(length int, limit, offset *int) (int, int) {
+	start := 0
+	if offset != nil {
+		start = *offset
+	}
+	if start > length {
+		start = length
+	}
+	end := length
+	if limit != nil && start+*limit < end {
+		end = start + *limit
+	}
+	return start, end
+}
+
+func matchNames(names []string, query string) bool {
+	query = strings.ToLower(query)
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameRelevance is the best similarity among the matched names.
+func nameRelevance(names []string, query string) (float64, bool) {
+	relevance, matched := 0.0, false
+	for _, name := range names {
+		similarity, ok := utils.MatchName(query, name)
+		if ok {
+			matched = true
+			if similarity > relevance {
+				relevance = similarity
+			}
+		}
+	}
+	return relevance, matched
+}
+
+// suggestionScore mirrors suggestionsBranch: prefix matches get a half of the score, the rest is the word similarity.
+func suggestionScore(query, name string) (float64, bool) {
+	queryKey := utils.NameSearchKey(query)
+	nameKey := utils.NameSearchKey(name)
+	similarity := utils.WordSimilarity(query, name)
+	namePrefix := strings.HasPrefix(nameKey, queryKey)
+	wordPrefix := strings.Contains(nameKey, " "+queryKey)
+	fuzzy := len([]rune(queryKey)) >= minFuzzyQueryLength
+	matched := namePrefix || fuzzy && (wordPrefix || similarity >= utils.WordSimilarityThreshold)
+	bonus := 0.0
+	if namePrefix || wordPrefix {
+		bonus = 1
+	}
+	return math.Round((bonus+similarity)/2*1e6) / 1e6, matched
+}
+
+func intSet(ids []int) map[int]bool {
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func sortedKeys(m interface{}) []int {
+	keysRaw := reflect.ValueOf(m).MapKeys()
+	keys := make([]int, len(keysRaw))
+	for i := range keysRaw {
+		keys[i] = int(keysRaw[i].Int())
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// locateOnPath returns the distance from the location to the path and the path length up to the closest point,
+// every segment is measured on a plane tangent at the location, which is precise enough for city routes.
+func locateOnPath(path []models.Location, location *models.Location) (float64, float64) {
+	metersPerDegree := earthRadius * math.Pi / 180
+	lonScale := math.Cos(location.Lat * math.Pi / 180)
+	project := func(l *models.Location) (float64, float64) {
+		return (l.Lon - location.Lon) * lonScale * metersPerDegree, (l.Lat - location.Lat) * metersPerDegree
+	}
+	bestDistance, bestPosition := math.Inf(1), 0.0
+	traveled := 0.0
+	for i := 0; i+1 < len(path); i++ {
+		ax, ay := project(&path[i])
+		bx, by := project(&path[i+1])
+		dx, dy := bx-ax, by-ay
+		length := math.Hypot(dx, dy)
+		t := 0.0
+		if length > 0 {
+			t = math.Min(math.Max(-(ax*dx+ay*dy)/(length*length), 0), 1)
+		}
+		distance := math.Hypot(ax+t*dx, ay+t*dy)
+		if distance < bestDistance {
+			bestDistance, bestPosition = distance, traveled+t*length
+		}
+		traveled += length
+	}
+	return bestDistance, bestPosition
+}
+
+func geoDistance(a, b *models.Location) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	deltaLat := (b.Lat - a.Lat) * math.Pi / 180
+	deltaLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
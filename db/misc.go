@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"mallfin_api/models"
@@ -9,6 +11,103 @@ import (
 	"github.com/pkg/errors"
 )
 
+// distance queries in search pass user location as ?3 and ?4
+const searchDistanceColumn = `st_distance(
+		  st_transform(m.mall_location, 26986),
+		  st_transform(st_setsrid(st_point(?3, ?4), 4326), 26986)
+	  )`
+
+// geo filter values are numbers, so they are put right into the query text
+func sqlFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func mallDistanceColumn(origin *models.Location) string {
+	if origin == nil {
+		return "NULL::FLOAT8"
+	}
+	return fmt.Sprintf(`st_distance(
+		  st_transform(m.mall_location, 26986),
+		  st_transform(st_setsrid(st_point(%s, %s), 4326), 26986)
+	  )`, sqlFloat(origin.Lon), sqlFloat(origin.Lat))
+}
+
+func mallGeoCondition(geo *models.GeoFilter) string {
+	if geo == nil {
+		return "TRUE"
+	}
+	conditions := []string{"TRUE"}
+	if geo.Near != nil && geo.Radius != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"st_dwithin(st_transform(m.mall_location, 26986), st_transform(st_setsrid(st_point(%s, %s), 4326), 26986), %s)",
+			sqlFloat(geo.Near.Lon), sqlFloat(geo.Near.Lat), sqlFloat(*geo.Radius)))
+	}
+	if geo.BBox != nil {
+		bbox := geo.BBox
+		conditions = append(conditions, fmt.Sprintf("m.mall_location && st_makeenvelope(%s, %s, %s, %s, 4326)",
+			sqlFloat(bbox.MinLon), sqlFloat(bbox.MinLat), sqlFloat(bbox.MaxLon), sqlFloat(bbox.MaxLat)))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// withGeoFilter replaces {geo} with the mall geo filter condition and {distance} with the distance to the filter origin.
+func withGeoFilter(query string, geo *models.GeoFilter) string {
+	query = strings.Replace(query, "{geo}", mallGeoCondition(geo), 1)
+	return strings.Replace(query, "{distance}", mallDistanceColumn(geo.Origin()), 1)
+}
+
+func routeLine(route *models.RouteFilter) string {
+	points := make([]string, len(route.Path))
+	for i, point := range route.Path {
+		points[i] = sqlFloat(point.Lon) + " " + sqlFloat(point.Lat)
+	}
+	return fmt.Sprintf("st_transform(st_geomfromtext('LINESTRING(%s)', 4326), 26986)", strings.Join(points, ", "))
+}
+
+func routeDistanceColumn(route *models.RouteFilter) string {
+	return fmt.Sprintf("st_distance(st_transform(m.mall_location, 26986), %s)", routeLine(route))
+}
+
+// withRouteFilter replaces {route} with the max detour condition,
+// {route_distance} and {route_position} with the distance to the path and the path length up to the closest point.
+func withRouteFilter(query string, route *models.RouteFilter) string {
+	line := routeLine(route)
+	query = strings.Replace(query, "{route}", fmt.Sprintf("st_dwithin(st_transform(m.mall_location, 26986), %s, %s)",
+		line, sqlFloat(route.MaxDetour)), 1)
+	query = strings.Replace(query, "{route_distance}", routeDistanceColumn(route), 1)
+	return strings.Replace(query, "{route_position}", fmt.Sprintf(
+		"st_linelocatepoint(%[1]s, st_transform(m.mall_location, 26986)) * st_length(%[1]s)", line), 1)
+}
+
+// nearest mall columns of the shops when the user location is unknown
+const noNearestMallJoin = `LEFT JOIN (SELECT NULL::INTEGER nearest_mall_id, NULL::FLOAT8 nearest_distance) nm ON TRUE`
+
+// withNearestMall replaces {nearest} with the join of the mall nearest to the location, nm.nearest_mall_id and
+// nm.nearest_distance are NULL for the shops in no mall or if the location is nil.
+func withNearestMall(query string, location *models.Location) string {
+	join := noNearestMallJoin
+	if location != nil {
+		point := fmt.Sprintf("st_setsrid(st_point(%s, %s), 4326)", sqlFloat(location.Lon), sqlFloat(location.Lat))
+		join = fmt.Sprintf(`LEFT JOIN LATERAL (SELECT
+			nmm.mall_id nearest_mall_id,
+			st_distance(st_transform(nmm.mall_location, 26986), st_transform(%[1]s, 26986)) nearest_distance
+		  FROM mall_shop nms
+			JOIN mall nmm ON nms.mall_id = nmm.mall_id
+		  WHERE nms.shop_id = s.shop_id
+		  ORDER BY nmm.mall_location <-> %[1]s
+		  LIMIT 1) nm ON TRUE`, point)
+	}
+	return strings.Replace(query, "{nearest}", join, 1)
+}
+
+const placementColumns = `ms.floor placement_floor, ms.unit placement_unit, ms.entrance placement_entrance,
+	  ms.phone placement_phone`
+
+// withPlacement replaces {placement} with the placement columns of the mall_shop joined as ms.
+func withPlacement(query string) string {
+	return strings.Replace(query, "{placement}", placementColumns, 1)
+}
+
 type baseQuery string
 
 func (bq baseQuery) withColumns(columns string) string {
@@ -18,6 +117,37 @@ func (bq baseQuery) withColumns(columns string) string {
 type OrderBy struct {
 	Column  string
 	Reverse bool
+	// tie breaker for keyset pagination, empty if Column is unique itself
+	IDColumn string
+	// columns that always go first, descending, e.g. matched shops count in search
+	Leading []string
+	// expression to use in keyset condition instead of Column alias
+	KeysetColumn string
+	Cursor       *models.Cursor
+}
+
+type orderColumn struct {
+	expr       string
+	keysetExpr string
+	desc       bool
+}
+
+func (o *OrderBy) columns() []orderColumn {
+	backward := o.Cursor != nil && o.Cursor.Backward
+	var columns []orderColumn
+	for _, leading := range o.Leading {
+		columns = append(columns, orderColumn{expr: leading, keysetExpr: leading, desc: !backward})
+	}
+	keysetColumn := o.Column
+	if o.KeysetColumn != "" {
+		keysetColumn = o.KeysetColumn
+	}
+	desc := o.Reverse != backward
+	columns = append(columns, orderColumn{expr: o.Column, keysetExpr: keysetColumn, desc: desc})
+	if o.IDColumn != "" {
+		columns = append(columns, orderColumn{expr: o.IDColumn, keysetExpr: o.IDColumn, desc: desc})
+	}
+	return columns
 }
 
 func (o *OrderBy) String() string {
@@ -25,13 +155,15 @@ func (o *OrderBy) String() string {
 }
 
 func (o *OrderBy) ToSql() string {
-	var s string
-	if o.Reverse {
-		s = fmt.Sprintf("%s DESC", o.Column)
-	} else {
-		s = fmt.Sprintf("%s ASC", o.Column)
+	var parts []string
+	for _, column := range o.columns() {
+		if column.desc {
+			parts = append(parts, fmt.Sprintf("%s DESC", column.expr))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s ASC", column.expr))
+		}
 	}
-	return s
+	return strings.Join(parts, ", ")
 }
 
 func (o *OrderBy) CompileQuery(query string) string {
@@ -42,7 +174,46 @@ func (o *OrderBy) CompileBaseQuery(query string) baseQuery {
 	return baseQuery(o.CompileQuery(query))
 }
 
-func mallOrderBy(sorting models.Sorting) *OrderBy {
+// CompileKeysetQuery also replaces {keyset} with the condition that skips rows up to the cursor,
+// cursor values are appended to args.
+func (o *OrderBy) CompileKeysetQuery(query string, args ...interface{}) (baseQuery, []interface{}) {
+	condition := "TRUE"
+	if o.Cursor != nil {
+		columns := o.columns()
+		firstArg := len(args)
+		args = append(args, o.Cursor.Values...)
+		var alternatives []string
+		for i, column := range columns {
+			var conditions []string
+			for j, previous := range columns[:i] {
+				conditions = append(conditions, fmt.Sprintf("%s = ?%d", previous.keysetExpr, firstArg+j))
+			}
+			operator := ">"
+			if column.desc {
+				operator = "<"
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s ?%d", column.keysetExpr, operator, firstArg+i))
+			alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+		}
+		condition = "(" + strings.Join(alternatives, " OR ") + ")"
+	}
+	query = strings.Replace(o.CompileQuery(query), "{keyset}", condition, 1)
+	return baseQuery(query), args
+}
+
+// RestoreOrder reverses results fetched with a backward cursor back to the requested order.
+func (o *OrderBy) RestoreOrder(results interface{}) {
+	if o.Cursor == nil || !o.Cursor.Backward {
+		return
+	}
+	value := reflect.ValueOf(results)
+	swap := reflect.Swapper(results)
+	for i, j := 0, value.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
+
+func mallOrderBy(sorting models.Sorting, cursor *models.Cursor, geo *models.GeoFilter) *OrderBy {
 	if sorting == nil {
 		sorting = models.DefaultMallSorting
 	}
@@ -54,13 +225,25 @@ func mallOrderBy(sorting models.Sorting) *OrderBy {
 		column = "m.mall_name"
 	case models.ShopsCountSortKey:
 		column = "m.shops_count"
+	case models.RelevanceSortKey:
+		// available only in name queries
+		column = "mn.relevance"
+	case models.DistanceSortKey:
+		column = "distance"
 	default:
 		panic(errors.Errorf("Unexpected sorting key %s for mall order by", sorting.Key()))
 	}
-	return &OrderBy{Column: column, Reverse: sorting.Reversed()}
+	orderBy := &OrderBy{Column: column, Reverse: models.IsDescending(sorting), Cursor: cursor}
+	if column == "distance" {
+		orderBy.KeysetColumn = mallDistanceColumn(geo.Origin())
+	}
+	if column != "m.mall_id" {
+		orderBy.IDColumn = "m.mall_id"
+	}
+	return orderBy
 }
 
-func shopOrderBy(sorting models.Sorting) *OrderBy {
+func shopOrderBy(sorting models.Sorting, cursor *models.Cursor) *OrderBy {
 	if sorting == nil {
 		sorting = models.DefaultShopSorting
 	}
@@ -74,10 +257,19 @@ func shopOrderBy(sorting models.Sorting) *OrderBy {
 		column = "s.malls_count"
 	case models.ScoreSortKey:
 		column = "s.score"
+	case models.RelevanceSortKey:
+		// available only in name queries
+		column = "s.relevance"
+	case models.NearestSortKey:
+		column = "coalesce(nm.nearest_distance, " + sqlFloat(models.NoMallDistance) + ")"
 	default:
 		panic(errors.Errorf("Unexpected sorting key %s for shop order by", sorting.Key()))
 	}
-	return &OrderBy{Column: column, Reverse: sorting.Reversed()}
+	orderBy := &OrderBy{Column: column, Reverse: models.IsDescending(sorting), Cursor: cursor}
+	if column != "s.shop_id" {
+		orderBy.IDColumn = "s.shop_id"
+	}
+	return orderBy
 }
 
 func categoryOrderBy(sorting models.Sorting) *OrderBy {
@@ -114,7 +306,26 @@ func cityOrderBy(sorting models.Sorting) *OrderBy {
 	return &OrderBy{Column: column, Reverse: sorting.Reversed()}
 }
 
-func searchOrderBy(sorting models.Sorting) *OrderBy {
+func stationOrderBy(sorting models.Sorting) *OrderBy {
+	if sorting == nil {
+		sorting = models.DefaultStationSorting
+	}
+	var column string
+	switch sorting.Key() {
+	case models.IDSortKey:
+		column = "ss.station_id"
+	case models.NameSortKey:
+		column = "ss.station_name"
+	case models.DistanceSortKey:
+		// available only in location queries
+		column = "distance"
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for subway station order by", sorting.Key()))
+	}
+	return &OrderBy{Column: column, Reverse: sorting.Reversed()}
+}
+
+func searchOrderBy(sorting models.Sorting, cursor *models.Cursor, route *models.RouteFilter) *OrderBy {
 	if sorting == nil {
 		sorting = models.DefaultSearchSorting
 	}
@@ -128,8 +339,20 @@ func searchOrderBy(sorting models.Sorting) *OrderBy {
 		column = "m.shops_count"
 	case models.DistanceSortKey:
 		column = "distance"
+	case models.RouteDistanceSortKey:
+		// available only in route queries
+		column = "route_distance"
 	default:
 		panic(errors.Errorf("Unexpected sorting key %s for search order by", sorting.Key()))
 	}
-	return &OrderBy{Column: column, Reverse: sorting.Reversed()}
+	orderBy := &OrderBy{Column: column, Reverse: sorting.Reversed(), Cursor: cursor, Leading: []string{"count(ms.shop_id)"}}
+	if column == "distance" {
+		orderBy.KeysetColumn = searchDistanceColumn
+	} else if column == "route_distance" {
+		orderBy.KeysetColumn = routeDistanceColumn(route)
+	}
+	if column != "m.mall_id" {
+		orderBy.IDColumn = "m.mall_id"
+	}
+	return orderBy
 }
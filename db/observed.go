@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"mallfin_api/metrics"
+	"mallfin_api/tracing"
+
+	"github.com/go-pg/pg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// observedDB records the latency and the errors of the queries under the name of the db function,
+// traces them as children of the request span and logs the slow ones. A transaction is one query
+// in the metrics, its statements get their own spans and are checked for slowness one by one.
+type observedDB struct {
+	*pg.DB
+	ctx       context.Context
+	queryName string
+}
+
+type observedTx struct {
+	*pg.Tx
+	ctx       context.Context
+	queryName string
+}
+
+func observed(ctx context.Context, client *pg.DB, queryName string) *observedDB {
+	return &observedDB{DB: client, ctx: ctx, queryName: queryName}
+}
+
+func (od *observedDB) Query(model, query interface{}, params ...interface{}) (pg.Result, error) {
+	span := startQuerySpan(od.ctx, od.queryName, query)
+	start := time.Now()
+	result, err := od.DB.Query(model, query, params...)
+	duration := time.Since(start)
+	od.observe(duration, err)
+	checkSlowQuery(od.ctx, od.queryName, duration, query, params, true)
+	endQuerySpan(span, result, err)
+	return result, err
+}
+
+func (od *observedDB) QueryOne(model, query interface{}, params ...interface{}) (pg.Result, error) {
+	span := startQuerySpan(od.ctx, od.queryName, query)
+	start := time.Now()
+	result, err := od.DB.QueryOne(model, query, params...)
+	duration := time.Since(start)
+	od.observe(duration, err)
+	checkSlowQuery(od.ctx, od.queryName, duration, query, params, true)
+	endQuerySpan(span, result, err)
+	return result, err
+}
+
+func (od *observedDB) Exec(query interface{}, params ...interface{}) (pg.Result, error) {
+	span := startQuerySpan(od.ctx, od.queryName, query)
+	start := time.Now()
+	result, err := od.DB.Exec(query, params...)
+	duration := time.Since(start)
+	od.observe(duration, err)
+	checkSlowQuery(od.ctx, od.queryName, duration, query, params, false)
+	endQuerySpan(span, result, err)
+	return result, err
+}
+
+func (od *observedDB) RunInTransaction(fn func(*observedTx) error) error {
+	ctx, span := tracing.StartClientSpan(od.ctx, od.queryName,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", "transaction"),
+	)
+	start := time.Now()
+	err := od.DB.RunInTransaction(func(tx *pg.Tx) error {
+		return fn(&observedTx{Tx: tx, ctx: ctx, queryName: od.queryName})
+	})
+	od.observe(time.Since(start), err)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (od *observedDB) observe(duration time.Duration, err error) {
+	metrics.ObserveQuery(od.queryName, duration, isQueryFailed(err))
+}
+
+func (ot *observedTx) Query(model, query interface{}, params ...interface{}) (pg.Result, error) {
+	span := startQuerySpan(ot.ctx, ot.queryName, query)
+	start := time.Now()
+	result, err := ot.Tx.Query(model, query, params...)
+	// the statements of a transaction are never explained, EXPLAIN ANALYZE would run them again
+	checkSlowQuery(ot.ctx, ot.queryName, time.Since(start), query, params, false)
+	endQuerySpan(span, result, err)
+	return result, err
+}
+
+func (ot *observedTx) QueryOne(model, query interface{}, params ...interface{}) (pg.Result, error) {
+	span := startQuerySpan(ot.ctx, ot.queryName, query)
+	start := time.Now()
+	result, err := ot.Tx.QueryOne(model, query, params...)
+	checkSlowQuery(ot.ctx, ot.queryName, time.Since(start), query, params, false)
+	endQuerySpan(span, result, err)
+	return result, err
+}
+
+func (ot *observedTx) Exec(query interface{}, params ...interface{}) (pg.Result, error) {
+	span := startQuerySpan(ot.ctx, ot.queryName, query)
+	start := time.Now()
+	result, err := ot.Tx.Exec(query, params...)
+	checkSlowQuery(ot.ctx, ot.queryName, time.Since(start), query, params, false)
+	endQuerySpan(span, result, err)
+	return result, err
+}
+
+// no rows is an expected outcome of QueryOne
+func isQueryFailed(err error) bool {
+	return err != nil && err != pg.ErrNoRows
+}
+
+// the statement is the template with the ?N placeholders, the params are not recorded
+func startQuerySpan(ctx context.Context, queryName string, query interface{}) trace.Span {
+	_, span := tracing.StartClientSpan(ctx, queryName,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", fmt.Sprint(query)),
+	)
+	return span
+}
+
+func endQuerySpan(span trace.Span, result pg.Result, err error) {
+	if result != nil {
+		span.SetAttributes(attribute.Int("db.rows", result.RowsAffected()))
+	}
+	if !isQueryFailed(err) {
+		err = nil
+	}
+	tracing.EndSpan(span, err)
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"context"
+	"time"
+
 	"mallfin_api/utils"
 
 	"mallfin_api/models"
@@ -9,62 +12,68 @@ import (
 	"github.com/pkg/errors"
 )
 
-func GetSearchResults(shopIDs []int, cityID int, sorting models.Sorting, limit, offset *int) ([]*models.SearchResult, error) {
+func (s *PostgresStore) GetSearchResults(ctx context.Context, shopIDs []int, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
 	if len(shopIDs) == 0 {
 		return nil, nil
 	}
-	orderBy := searchOrderBy(sorting)
+	orderBy := searchOrderBy(sorting, cursor, nil)
 	shopIDsArray := pg.Array(shopIDs)
 	queryName := utils.CurrentFuncName()
-	searchResults, err := searchResultsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(`
 	SELECT {columns}
 	  NULL                  distance
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ANY (?2) AND m.city_id = ?3
+	WHERE ms.shop_id = ANY (?2) AND m.city_id = ?3 AND (?4::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?4, ?5))
 	GROUP BY m.mall_id
-	ORDER BY count(ms.shop_id) DESC, {order}
+	HAVING {keyset}
+	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, shopIDsArray, cityID)
+	`, limit, offset, shopIDsArray, cityID, openAt, timeZone.String())
+	searchResults, err := searchResultsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(searchResults)
 	return searchResults, nil
 }
 
-func GetSearchResultsWithoutCity(shopIDs []int, sorting models.Sorting, limit, offset *int) ([]*models.SearchResult, error) {
+func (s *PostgresStore) GetSearchResultsWithoutCity(ctx context.Context, shopIDs []int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
 	if len(shopIDs) == 0 {
 		return nil, nil
 	}
-	orderBy := searchOrderBy(sorting)
+	orderBy := searchOrderBy(sorting, cursor, nil)
 	shopIDsArray := pg.Array(shopIDs)
 	queryName := utils.CurrentFuncName()
-	searchResults, err := searchResultsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(`
 	SELECT {columns}
 	  NULL                  distance
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ANY (?2)
+	WHERE ms.shop_id = ANY (?2) AND (?3::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?3, ?4))
 	GROUP BY m.mall_id
-	ORDER BY count(ms.shop_id) DESC, {order}
+	HAVING {keyset}
+	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, shopIDsArray)
+	`, limit, offset, shopIDsArray, openAt, timeZone.String())
+	searchResults, err := searchResultsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(searchResults)
 	return searchResults, nil
 }
 
-func GetSearchResultsWithDistance(shopIDs []int, location *models.Location, cityID int, sorting models.Sorting, limit, offset *int) ([]*models.SearchResult, error) {
+func (s *PostgresStore) GetSearchResultsWithDistance(ctx context.Context, shopIDs []int, location *models.Location, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
 	if len(shopIDs) == 0 {
 		return nil, nil
 	}
-	orderBy := searchOrderBy(sorting)
+	orderBy := searchOrderBy(sorting, cursor, nil)
 	shopIDsArray := pg.Array(shopIDs)
 	queryName := utils.CurrentFuncName()
-	searchResults, err := searchResultsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(`
 	SELECT {columns}
 	  st_distance(
 		  st_transform(m.mall_location, 26986),
@@ -72,26 +81,29 @@ func GetSearchResultsWithDistance(shopIDs []int, location *models.Location, city
 	  )                     distance
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ANY(?2) AND m.city_id = ?5
+	WHERE ms.shop_id = ANY(?2) AND m.city_id = ?5 AND (?6::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?6, ?7))
 	GROUP BY m.mall_id
-	ORDER BY count(ms.shop_id) DESC, {order}
+	HAVING {keyset}
+	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, shopIDsArray, location.Lon, location.Lat, cityID)
+	`, limit, offset, shopIDsArray, location.Lon, location.Lat, cityID, openAt, timeZone.String())
+	searchResults, err := searchResultsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(searchResults)
 	return searchResults, nil
 }
 
-func GetSearchResultsWithDistanceWithoutCity(shopIDs []int, location *models.Location, sorting models.Sorting, limit, offset *int) ([]*models.SearchResult, error) {
+func (s *PostgresStore) GetSearchResultsWithDistanceWithoutCity(ctx context.Context, shopIDs []int, location *models.Location, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
 	if len(shopIDs) == 0 {
 		return nil, nil
 	}
-	orderBy := searchOrderBy(sorting)
+	orderBy := searchOrderBy(sorting, cursor, nil)
 	shopIDsArray := pg.Array(shopIDs)
 	queryName := utils.CurrentFuncName()
-	searchResults, err := searchResultsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(`
 	SELECT {columns}
 	  st_distance(
 		  st_transform(m.mall_location, 26986),
@@ -99,24 +111,89 @@ func GetSearchResultsWithDistanceWithoutCity(shopIDs []int, location *models.Loc
 	  )                     distance
 	FROM mall m
 	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
-	WHERE ms.shop_id = ANY (?2)
+	WHERE ms.shop_id = ANY (?2) AND (?5::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?5, ?6))
+	GROUP BY m.mall_id
+	HAVING {keyset}
+	ORDER BY {order}
+	LIMIT ?0
+	OFFSET ?1
+	`, limit, offset, shopIDsArray, location.Lon, location.Lat, openAt, timeZone.String())
+	searchResults, err := searchResultsQuery(ctx, queryName, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	orderBy.RestoreOrder(searchResults)
+	return searchResults, nil
+}
+
+func (s *PostgresStore) GetSearchResultsAlongRoute(ctx context.Context, shopIDs []int, route *models.RouteFilter, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	orderBy := searchOrderBy(sorting, cursor, route)
+	shopIDsArray := pg.Array(shopIDs)
+	queryName := utils.CurrentFuncName()
+	query, args := orderBy.CompileKeysetQuery(withRouteFilter(`
+	SELECT {columns}
+	  NULL                  distance,
+	  {route_distance}      route_distance,
+	  {route_position}      route_position
+	FROM mall m
+	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
+	WHERE ms.shop_id = ANY (?2) AND m.city_id = ?3 AND (?4::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?4, ?5))
+	  AND {route}
+	GROUP BY m.mall_id
+	HAVING {keyset}
+	ORDER BY {order}
+	LIMIT ?0
+	OFFSET ?1
+	`, route), limit, offset, shopIDsArray, cityID, openAt, timeZone.String())
+	searchResults, err := searchResultsQuery(ctx, queryName, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	orderBy.RestoreOrder(searchResults)
+	return searchResults, nil
+}
+
+func (s *PostgresStore) GetSearchResultsAlongRouteWithoutCity(ctx context.Context, shopIDs []int, route *models.RouteFilter, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error) {
+	if len(shopIDs) == 0 {
+		return nil, nil
+	}
+	orderBy := searchOrderBy(sorting, cursor, route)
+	shopIDsArray := pg.Array(shopIDs)
+	queryName := utils.CurrentFuncName()
+	query, args := orderBy.CompileKeysetQuery(withRouteFilter(`
+	SELECT {columns}
+	  NULL                  distance,
+	  {route_distance}      route_distance,
+	  {route_position}      route_position
+	FROM mall m
+	  JOIN mall_shop ms ON m.mall_id = ms.mall_id
+	WHERE ms.shop_id = ANY (?2) AND (?3::TIMESTAMPTZ IS NULL OR mall_is_open(m.mall_id, m.day_and_night, ?3, ?4))
+	  AND {route}
 	GROUP BY m.mall_id
-	ORDER BY count(ms.shop_id) DESC, {order}
+	HAVING {keyset}
+	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, shopIDsArray, location.Lon, location.Lat)
+	`, route), limit, offset, shopIDsArray, openAt, timeZone.String())
+	searchResults, err := searchResultsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(searchResults)
 	return searchResults, nil
 }
 
-func searchResultsQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.SearchResult, error) {
-	client := GetClient()
+func searchResultsQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.SearchResult, error) {
+	client := observed(ctx, GetClient(), queryName)
 	var rows []*struct {
 		mallRow
-		Shops    []int `pg:",array"`
-		Distance *float64
+		Shops         []int `pg:",array"`
+		Distance      *float64
+		RouteDistance *float64
+		RoutePosition *float64
 	}
 	query := queryBasis.withColumns(`
 	  m.mall_id,
@@ -128,6 +205,8 @@ func searchResultsQuery(queryName string, queryBasis baseQuery, args ...interfac
 	  ST_X(m.mall_location) mall_location_lon,
 	  m.shops_count,
 	  m.address,
+	  m.day_and_night,
+	  (SELECT c.city_timezone FROM city c WHERE c.city_id = m.city_id) city_timezone,
 	  array_agg(ms.shop_id) shops,
 	`)
 	_, err := client.Query(&rows, query, args...)
@@ -135,13 +214,21 @@ func searchResultsQuery(queryName string, queryBasis baseQuery, args ...interfac
 		return nil, errors.WithMessage(err, queryName)
 	}
 	searchResults := make([]*models.SearchResult, len(rows))
+	malls := make([]*models.Mall, len(rows))
 	for i, row := range rows {
 		sr := models.SearchResult{
-			Mall:     row.mallRow.toModel(),
-			ShopIDs:  row.Shops,
-			Distance: row.Distance,
+			Mall:          row.mallRow.toModel(),
+			ShopIDs:       row.Shops,
+			Distance:      row.Distance,
+			RouteDistance: row.RouteDistance,
+			RoutePosition: row.RoutePosition,
 		}
 		searchResults[i] = &sr
+		malls[i] = sr.Mall
+	}
+	err = loadMallHours(ctx, queryName, malls...)
+	if err != nil {
+		return nil, err
 	}
 	return searchResults, nil
 }
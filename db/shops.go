@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"mallfin_api/models"
 	"mallfin_api/utils"
 
@@ -18,6 +19,16 @@ type shopRow struct {
 	//Details
 	ShopPhone string
 	ShopSite  string
+	// only in name queries
+	Relevance float64
+	// only in list queries, nil without the user location
+	NearestMallID   *int
+	NearestDistance *float64
+	// only in mall queries
+	PlacementFloor    *int
+	PlacementUnit     *string
+	PlacementEntrance *string
+	PlacementPhone    *string
 }
 
 func (sr *shopRow) toModel() *models.Shop {
@@ -29,13 +40,24 @@ func (sr *shopRow) toModel() *models.Shop {
 		MallsCount: sr.MallsCount,
 		Phone:      sr.ShopPhone,
 		Site:       sr.ShopSite,
+		Relevance:  sr.Relevance,
+		// set with the user location only
+		NearestDistance: sr.NearestDistance,
+	}
+	if sr.PlacementUnit != nil {
+		shop.Placement = &models.ShopPlacement{
+			Floor:    sr.PlacementFloor,
+			Unit:     *sr.PlacementUnit,
+			Entrance: *sr.PlacementEntrance,
+			Phone:    *sr.PlacementPhone,
+		}
 	}
 	return shop
 }
 
-func GetShopDetails(shopID int) (*models.Shop, error) {
-	client := GetClient()
+func (s *PostgresStore) GetShopDetails(ctx context.Context, shopID int) (*models.Shop, error) {
 	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
 	var row shopRow
 	_, err := client.QueryOne(&row, `
 	SELECT
@@ -60,9 +82,9 @@ func GetShopDetails(shopID int) (*models.Shop, error) {
 	return shop, nil
 }
 
-func GetShopDetailsWithLocation(shopID int, location *models.Location) (*models.Shop, error) {
-	client := GetClient()
+func (s *PostgresStore) GetShopDetailsWithLocation(ctx context.Context, shopID int, location *models.Location) (*models.Shop, error) {
 	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
 	var row struct {
 		shopRow
 		mallRow
@@ -84,7 +106,13 @@ func GetShopDetailsWithLocation(shopID int, location *models.Location) (*models.
 	  m.mall_logo_large,
 	  ST_Y(m.mall_location) mall_location_lat,
 	  ST_X(m.mall_location) mall_location_lon,
-	  m.shops_count
+	  m.shops_count,
+	  m.day_and_night,
+	  (SELECT c.city_timezone FROM city c WHERE c.city_id = m.city_id) city_timezone,
+	  st_distance(
+		  st_transform(m.mall_location, 26986),
+		  st_transform(st_setsrid(st_point(?1, ?2), 4326), 26986)
+	  ) nearest_distance
 	FROM shop s
 	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
 	  JOIN mall m ON ms.mall_id = m.mall_id
@@ -99,123 +127,165 @@ func GetShopDetailsWithLocation(shopID int, location *models.Location) (*models.
 	} else if err != nil {
 		return nil, errors.WithMessage(err, queryName)
 	}
+	err = loadMallHours(ctx, queryName, shop.NearestMall)
+	if err != nil {
+		return nil, err
+	}
 	return shop, nil
 }
 
-func GetShops(cityID int, sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShops(ctx context.Context, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withNearestMall(`
+	SELECT {columns}, nm.nearest_mall_id, nm.nearest_distance
 	FROM shop s
 	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
 	  JOIN mall m ON ms.mall_id = m.mall_id
-	WHERE m.city_id = ?2
+	  {nearest}
+	WHERE m.city_id = ?2 AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, cityID)
+	`, location), limit, offset, cityID)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(shops)
 	return shops, nil
 }
 
-func GetShopsWithoutCity(sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShopsWithoutCity(ctx context.Context, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withNearestMall(`
+	SELECT {columns}, nm.nearest_mall_id, nm.nearest_distance
 	FROM shop s
+	  {nearest}
+	WHERE {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset)
+	`, location), limit, offset)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(shops)
 	return shops, nil
 }
 
-func GetShopsByMall(mallID int, sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShopsByMall(ctx context.Context, mallID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withPlacement(withNearestMall(`
+	SELECT {columns}, nm.nearest_mall_id, nm.nearest_distance, {placement}
 	FROM shop s
 	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
-	WHERE ms.mall_id = ?2
+	  {nearest}
+	WHERE ms.mall_id = ?2 AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, mallID)
+	`, location)), limit, offset, mallID)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
-		return nil, nil
+		return nil, err
+	}
+	orderBy.RestoreOrder(shops)
+	return shops, nil
+}
+
+func (s *PostgresStore) GetMallDirectory(ctx context.Context, mallID int) ([]*models.Shop, error) {
+	queryName := utils.CurrentFuncName()
+	shops, err := shopsQuery(ctx, queryName, baseQuery(withPlacement(`
+	SELECT {columns}, {placement}
+	FROM shop s
+	  JOIN mall_shop ms ON s.shop_id = ms.shop_id
+	WHERE ms.mall_id = ?0
+	ORDER BY ms.floor NULLS LAST, ms.unit, s.shop_name, s.shop_id
+	`)), mallID)
+	if err != nil {
+		return nil, err
 	}
 	return shops, nil
 }
 
-func GetShopsByIDs(shopIDs []int) ([]*models.Shop, error) {
+func (s *PostgresStore) GetShopsByIDs(ctx context.Context, shopIDs []int) ([]*models.Shop, error) {
 	if len(shopIDs) == 0 {
 		return nil, nil
 	}
 	shopIDsArray := pg.Array(shopIDs)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, `
-	SELECT %s
+	shops, err := shopsQuery(ctx, queryName, `
+	SELECT {columns}
 	FROM shop s
 	WHERE s.shop_id = ANY(?0)
 	`, shopIDsArray)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	return shops, nil
 }
 
-func GetShopsByName(name string, cityID int, sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShopsByName(ctx context.Context, name string, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(withNearestMall(`
 	SELECT *
-	FROM (SELECT DISTINCT ON (s.shop_id) {columns}
+	FROM (SELECT DISTINCT ON (s.shop_id) {columns}, sn.relevance
 		  FROM shop s
-			JOIN shop_name sn ON s.shop_id = sn.shop_id
+			JOIN (SELECT shop_id, round(max(word_similarity(name_search_key(?2), name_search_key(shop_name)))::NUMERIC, 6)::FLOAT8 relevance
+				  FROM shop_name
+				  WHERE name_search_key(shop_name) LIKE '%' || name_search_key(?2) || '%' OR name_search_key(?2) <% name_search_key(shop_name)
+				  GROUP BY shop_id) sn ON s.shop_id = sn.shop_id
 			JOIN mall_shop ms ON s.shop_id = ms.shop_id
 			JOIN mall m ON ms.mall_id = m.mall_id
-		  WHERE sn.shop_name ILIKE '%%' || ?2 || '%%' AND m.city_id = ?3) s
+		  WHERE m.city_id = ?3) s
+	  {nearest}
+	WHERE {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, name, cityID)
+	`, location), limit, offset, name, cityID)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(shops)
 	return shops, nil
 }
 
-func GetShopsByNameWithoutCity(name string, sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShopsByNameWithoutCity(ctx context.Context, name string, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(withNearestMall(`
 	SELECT *
-	FROM (SELECT DISTINCT ON (s.shop_id) {columns}
+	FROM (SELECT {columns}, sn.relevance
 		  FROM shop s
-			JOIN shop_name sn ON s.shop_id = sn.shop_id
-		  WHERE sn.shop_name ILIKE '%%' || ?2 || '%%') s
+			JOIN (SELECT shop_id, round(max(word_similarity(name_search_key(?2), name_search_key(shop_name)))::NUMERIC, 6)::FLOAT8 relevance
+				  FROM shop_name
+				  WHERE name_search_key(shop_name) LIKE '%' || name_search_key(?2) || '%' OR name_search_key(?2) <% name_search_key(shop_name)
+				  GROUP BY shop_id) sn ON s.shop_id = sn.shop_id) s
+	  {nearest}
+	WHERE {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, name)
+	`, location), limit, offset, name)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(shops)
 	return shops, nil
 }
 
-func GetShopsByCategory(categoryID, cityID int, sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShopsByCategory(ctx context.Context, categoryID, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
+	query, args := orderBy.CompileKeysetQuery(withNearestMall(`
 	SELECT *
 	FROM (SELECT DISTINCT ON (s.shop_id) {columns}
 		  FROM shop s
@@ -223,36 +293,43 @@ func GetShopsByCategory(categoryID, cityID int, sorting models.Sorting, limit, o
 			JOIN mall_shop ms ON s.shop_id = ms.shop_id
 			JOIN mall m ON ms.mall_id = m.mall_id
 		  WHERE sc.category_id = ?2 AND m.city_id = ?3) s
+	  {nearest}
+	WHERE {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, categoryID, cityID)
+	`, location), limit, offset, categoryID, cityID)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(shops)
 	return shops, nil
 }
 
-func GetShopsByCategoryWithoutCity(categoryID int, sorting models.Sorting, limit, offset *int) ([]*models.Shop, error) {
-	orderBy := shopOrderBy(sorting)
+func (s *PostgresStore) GetShopsByCategoryWithoutCity(ctx context.Context, categoryID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error) {
+	orderBy := shopOrderBy(sorting, cursor)
 	queryName := utils.CurrentFuncName()
-	shops, err := shopsQuery(queryName, orderBy.CompileBaseQuery(`
-	SELECT {columns}
+	query, args := orderBy.CompileKeysetQuery(withNearestMall(`
+	SELECT {columns}, nm.nearest_mall_id, nm.nearest_distance
 	FROM shop s
 	  JOIN shop_category sc ON s.shop_id = sc.shop_id
-	WHERE sc.category_id = ?2
+	  {nearest}
+	WHERE sc.category_id = ?2 AND {keyset}
 	ORDER BY {order}
 	LIMIT ?0
 	OFFSET ?1
-	`), limit, offset, categoryID)
+	`, location), limit, offset, categoryID)
+	shops, err := shopsQuery(ctx, queryName, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	orderBy.RestoreOrder(shops)
 	return shops, nil
 }
 
-func shopsQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.Shop, error) {
-	client := GetClient()
+func shopsQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.Shop, error) {
+	client := observed(ctx, GetClient(), queryName)
 	query := queryBasis.withColumns(`
 	  s.shop_id,
 	  s.shop_name,
@@ -267,8 +344,45 @@ func shopsQuery(queryName string, queryBasis baseQuery, args ...interface{}) ([]
 		return nil, errors.WithMessage(err, queryName)
 	}
 	shops := make([]*models.Shop, len(rows))
+	nearestMallIDs := make([]int, len(rows))
 	for i, row := range rows {
 		shops[i] = row.toModel()
+		if row.NearestMallID != nil {
+			nearestMallIDs[i] = *row.NearestMallID
+		}
+	}
+	err = loadNearestMalls(ctx, queryName, shops, nearestMallIDs)
+	if err != nil {
+		return nil, err
 	}
 	return shops, nil
 }
+
+// loadNearestMalls fetches the nearest malls of all the shops in one query, zero mall id means no nearest mall.
+func loadNearestMalls(ctx context.Context, queryName string, shops []*models.Shop, nearestMallIDs []int) error {
+	var mallIDs []int
+	for _, mallID := range nearestMallIDs {
+		if mallID != 0 {
+			mallIDs = append(mallIDs, mallID)
+		}
+	}
+	if len(mallIDs) == 0 {
+		return nil
+	}
+	malls, err := mallsQuery(ctx, queryName, baseQuery(`
+	SELECT {columns}
+	FROM mall m
+	WHERE m.mall_id = ANY(?0)
+	`), pg.Array(mallIDs))
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]*models.Mall, len(malls))
+	for _, mall := range malls {
+		byID[mall.ID] = mall
+	}
+	for i, shop := range shops {
+		shop.NearestMall = byID[nearestMallIDs[i]]
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mallfin_api/config"
+	"mallfin_api/logging"
+	"mallfin_api/models"
+	"mallfin_api/tracing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-pg/pg"
+)
+
+const defaultKeptSlowQueries = 50
+
+var (
+	// the newest last
+	slowQueries      []*models.SlowQuery
+	slowQueriesMutex sync.Mutex
+	// one EXPLAIN ANALYZE at a time, the slow queries meanwhile are not explained
+	explaining int32
+)
+
+// checkSlowQuery logs the query if it is slower than the threshold and keeps it for the debug endpoint.
+// EXPLAIN ANALYZE runs the query once more, so only the sampled selects are explained, in the background.
+func checkSlowQuery(ctx context.Context, queryName string, duration time.Duration, query interface{}, params []interface{}, explainable bool) {
+	conf := config.Postgres().SlowQuery
+	if conf == nil || conf.ThresholdMs <= 0 || duration < time.Duration(conf.ThresholdMs)*time.Millisecond {
+		return
+	}
+	// the args are neither logged nor kept, they carry the coordinates of the users
+	slowQuery := &models.SlowQuery{
+		QueryName: queryName,
+		Statement: fmt.Sprint(query),
+		Duration:  duration,
+		RequestID: tracing.FromContext(ctx),
+		StartedAt: time.Now().Add(-duration),
+	}
+	logging.FromContext(ctx).WithFields(log.Fields{
+		logging.PackageField: "db",
+		"query_name":         queryName,
+		"duration_ms":        float64(duration) / float64(time.Millisecond),
+		"statement":          slowQuery.Statement,
+	}).Warn("Slow query")
+	keepSlowQuery(slowQuery, conf.Keep)
+	if explainable && isSelect(slowQuery.Statement) && rand.Float64() < conf.ExplainRatio &&
+		atomic.CompareAndSwapInt32(&explaining, 0, 1) {
+		go explainSlowQuery(slowQuery, params)
+	}
+}
+
+// a WITH statement is not explained: its CTEs may insert, update or delete,
+// and EXPLAIN ANALYZE would apply them a second time
+func isSelect(statement string) bool {
+	statement = strings.ToUpper(strings.TrimSpace(statement))
+	return strings.HasPrefix(statement, "SELECT")
+}
+
+func keepSlowQuery(slowQuery *models.SlowQuery, keep int) {
+	if keep <= 0 {
+		keep = defaultKeptSlowQueries
+	}
+	slowQueriesMutex.Lock()
+	defer slowQueriesMutex.Unlock()
+	slowQueries = append(slowQueries, slowQuery)
+	if len(slowQueries) > keep {
+		slowQueries = append([]*models.SlowQuery(nil), slowQueries[len(slowQueries)-keep:]...)
+	}
+}
+
+func explainSlowQuery(slowQuery *models.SlowQuery, params []interface{}) {
+	defer atomic.StoreInt32(&explaining, 0)
+	var plan string
+	_, err := GetClient().QueryOne(pg.Scan(&plan), "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+slowQuery.Statement, params...)
+	if err != nil {
+		logger.WithField("query_name", slowQuery.QueryName).Errorf("Cannot explain slow query: %s", err)
+		return
+	}
+	slowQueriesMutex.Lock()
+	defer slowQueriesMutex.Unlock()
+	slowQuery.Plan = []byte(plan)
+}
+
+// SlowQueries returns the kept slow queries, the newest first.
+func SlowQueries() []*models.SlowQuery {
+	slowQueriesMutex.Lock()
+	defer slowQueriesMutex.Unlock()
+	result := make([]*models.SlowQuery, len(slowQueries))
+	for i, slowQuery := range slowQueries {
+		copied := *slowQuery
+		result[len(slowQueries)-1-i] = &copied
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"mallfin_api/models"
+)
+
+type MallStore interface {
+	GetMallDetails(ctx context.Context, mallID int) (*models.Mall, error)
+	GetMallByLocation(ctx context.Context, location *models.Location) (*models.Mall, error)
+	GetMalls(ctx context.Context, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetMallsWithoutCity(ctx context.Context, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetMallsByIDs(ctx context.Context, mallIDs []int) ([]*models.Mall, error)
+	GetMallsBySubwayStation(ctx context.Context, subwayStationID int, maxWalkMinutes *int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetMallsByShop(ctx context.Context, shopID, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetMallsByShopWithoutCity(ctx context.Context, shopID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetMallsByName(ctx context.Context, name string, cityID int, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetMallsByNameWithoutCity(ctx context.Context, name string, openAt *time.Time, geo *models.GeoFilter, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Mall, error)
+	GetShopsInMalls(ctx context.Context, mallIDs, shopIDs []int) ([]*models.MallMatchedShops, error)
+	// GetMallLocations returns the malls with only the ID and the location set, ordered by ID.
+	GetMallLocations(ctx context.Context, filter *models.MallFilter) ([]*models.Mall, error)
+
+	MallsCount(ctx context.Context, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error)
+	MallsWithoutCityCount(ctx context.Context, openAt *time.Time, geo *models.GeoFilter) (int, error)
+	MallsByNameCount(ctx context.Context, name string, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error)
+	MallsByNameWithoutCityCount(ctx context.Context, name string, openAt *time.Time, geo *models.GeoFilter) (int, error)
+	MallsByShopCount(ctx context.Context, shopID, cityID int, openAt *time.Time, geo *models.GeoFilter) (int, error)
+	MallsByShopWithoutCityCount(ctx context.Context, shopID int, openAt *time.Time, geo *models.GeoFilter) (int, error)
+	MallsBySubwayStationCount(ctx context.Context, subwayStationID int, maxWalkMinutes *int, openAt *time.Time, geo *models.GeoFilter) (int, error)
+
+	IsMallExists(ctx context.Context, mallID int) (bool, error)
+	IsSubwayStationExists(ctx context.Context, subwayStationID int) (bool, error)
+
+	CreateMall(ctx context.Context, changes *models.MallChanges) (int, error)
+	UpdateMall(ctx context.Context, mallID int, changes *models.MallChanges) (bool, error)
+	DeleteMall(ctx context.Context, mallID int) (bool, error)
+}
+
+type ShopStore interface {
+	GetShopDetails(ctx context.Context, shopID int) (*models.Shop, error)
+	GetShopDetailsWithLocation(ctx context.Context, shopID int, location *models.Location) (*models.Shop, error)
+	GetShops(ctx context.Context, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	GetShopsWithoutCity(ctx context.Context, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	GetShopsByMall(ctx context.Context, mallID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	GetShopsByIDs(ctx context.Context, shopIDs []int) ([]*models.Shop, error)
+	GetShopsByName(ctx context.Context, name string, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	GetShopsByNameWithoutCity(ctx context.Context, name string, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	GetShopsByCategory(ctx context.Context, categoryID, cityID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	GetShopsByCategoryWithoutCity(ctx context.Context, categoryID int, location *models.Location, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.Shop, error)
+	// the placed shops of the mall ordered by floor, unknown floor last
+	GetMallDirectory(ctx context.Context, mallID int) ([]*models.Shop, error)
+
+	ShopsCount(ctx context.Context, cityID int) (int, error)
+	ShopsWithoutCityCount(ctx context.Context) (int, error)
+	ShopsByMallCount(ctx context.Context, mallID int) (int, error)
+	ShopsByNameCount(ctx context.Context, name string, cityID int) (int, error)
+	ShopsByNameWithoutCityCount(ctx context.Context, name string) (int, error)
+	ShopsByCategoryCount(ctx context.Context, categoryID, cityID int) (int, error)
+	ShopsByCategoryWithoutCityCount(ctx context.Context, categoryID int) (int, error)
+
+	IsShopExists(ctx context.Context, shopID int) (bool, error)
+
+	CreateShop(ctx context.Context, changes *models.ShopChanges) (int, error)
+	UpdateShop(ctx context.Context, shopID int, changes *models.ShopChanges) (bool, error)
+	DeleteShop(ctx context.Context, shopID int) (bool, error)
+	ChangeShopMalls(ctx context.Context, shopID int, attachMallIDs, detachMallIDs []int) (bool, error)
+	ChangeShopCategories(ctx context.Context, shopID int, attachCategoryIDs, detachCategoryIDs []int) (bool, error)
+	// false if the shop is not in the mall
+	SetShopPlacement(ctx context.Context, shopID, mallID int, placement *models.ShopPlacement) (bool, error)
+}
+
+type CategoryStore interface {
+	GetCategoryDetails(ctx context.Context, categoryID int) (*models.Category, error)
+	GetCategories(ctx context.Context, sorting models.Sorting) ([]*models.Category, error)
+	GetCategoriesByIDs(ctx context.Context, categoryIDs []int) ([]*models.Category, error)
+	GetCategoriesByShop(ctx context.Context, shopID int, sorting models.Sorting) ([]*models.Category, error)
+
+	IsCategoryExists(ctx context.Context, categoryID int) (bool, error)
+}
+
+type CityStore interface {
+	GetCities(ctx context.Context, sorting models.Sorting) ([]*models.City, error)
+	GetCitiesByName(ctx context.Context, name string, sorting models.Sorting) ([]*models.City, error)
+	GetCityByLocation(ctx context.Context, location *models.Location) (*models.City, error)
+
+	IsCityExists(ctx context.Context, cityID int) (bool, error)
+}
+
+type SubwayStationStore interface {
+	GetSubwayStationDetails(ctx context.Context, stationID int) (*models.SubwayStation, error)
+	GetSubwayStations(ctx context.Context, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error)
+	GetSubwayStationsWithoutCity(ctx context.Context, sorting models.Sorting) ([]*models.SubwayStation, error)
+	GetSubwayStationsByName(ctx context.Context, name string, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error)
+	GetSubwayStationsByNameWithoutCity(ctx context.Context, name string, sorting models.Sorting) ([]*models.SubwayStation, error)
+	// radius in meters, the stations without coordinates are skipped
+	GetSubwayStationsNear(ctx context.Context, location *models.Location, radius float64, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error)
+	GetSubwayStationsNearWithoutCity(ctx context.Context, location *models.Location, radius float64, sorting models.Sorting) ([]*models.SubwayStation, error)
+	GetNearestSubwayStation(ctx context.Context, location *models.Location) (*models.SubwayStation, error)
+}
+
+type FloorPlanStore interface {
+	// without units, ordered by floor
+	GetMallFloors(ctx context.Context, mallID int) ([]*models.FloorPlan, error)
+	GetFloorPlan(ctx context.Context, mallID, floor int) (*models.FloorPlan, error)
+	GetFloorUnitAt(ctx context.Context, mallID, floor int, point models.IndoorPoint) (*models.FloorUnit, error)
+}
+
+type SearchStore interface {
+	GetSearchResults(ctx context.Context, shopIDs []int, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error)
+	GetSearchResultsWithoutCity(ctx context.Context, shopIDs []int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error)
+	GetSearchResultsWithDistance(ctx context.Context, shopIDs []int, location *models.Location, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error)
+	GetSearchResultsWithDistanceWithoutCity(ctx context.Context, shopIDs []int, location *models.Location, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error)
+
+	GetSearchResultsAlongRoute(ctx context.Context, shopIDs []int, route *models.RouteFilter, cityID int, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error)
+	GetSearchResultsAlongRouteWithoutCity(ctx context.Context, shopIDs []int, route *models.RouteFilter, openAt *time.Time, sorting models.Sorting, limit, offset *int, cursor *models.Cursor) ([]*models.SearchResult, error)
+
+	SearchResultsCount(ctx context.Context, shopIDs []int, cityID int, openAt *time.Time) (int, error)
+	SearchResultsWithoutCityCount(ctx context.Context, shopIDs []int, openAt *time.Time) (int, error)
+	SearchResultsAlongRouteCount(ctx context.Context, shopIDs []int, route *models.RouteFilter, cityID int, openAt *time.Time) (int, error)
+	SearchResultsAlongRouteWithoutCityCount(ctx context.Context, shopIDs []int, route *models.RouteFilter, openAt *time.Time) (int, error)
+}
+
+type SuggestStore interface {
+	// nil cityID suggests from all cities
+	GetSuggestions(ctx context.Context, query string, cityID *int, limit int) ([]*models.Suggestion, error)
+}
+
+type PostgresStore struct{}
+
+func NewPostgresStore() *PostgresStore {
+	return &PostgresStore{}
+}
+
+var (
+	_ MallStore     = (*PostgresStore)(nil)
+	_ ShopStore     = (*PostgresStore)(nil)
+	_ CategoryStore = (*PostgresStore)(nil)
+	_ CityStore     = (*PostgresStore)(nil)
+	_ SearchStore   = (*PostgresStore)(nil)
+	_ SuggestStore  = (*PostgresStore)(nil)
+
+	_ SubwayStationStore = (*PostgresStore)(nil)
+	_ FloorPlanStore     = (*PostgresStore)(nil)
+
+	_ MallStore     = (*MemoryStore)(nil)
+	_ ShopStore     = (*MemoryStore)(nil)
+	_ CategoryStore = (*MemoryStore)(nil)
+	_ CityStore     = (*MemoryStore)(nil)
+	_ SearchStore   = (*MemoryStore)(nil)
+	_ SuggestStore  = (*MemoryStore)(nil)
+
+	_ SubwayStationStore = (*MemoryStore)(nil)
+	_ FloorPlanStore     = (*MemoryStore)(nil)
+)
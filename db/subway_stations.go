@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"mallfin_api/models"
+	"mallfin_api/utils"
+
+	"github.com/pkg/errors"
+)
+
+type stationRow struct {
+	StationID          int
+	StationName        string
+	LineName           string
+	LineColor          string
+	CityID             int
+	StationLocationLat *float64
+	StationLocationLon *float64
+	// only in location queries
+	Distance *float64
+}
+
+func (sr *stationRow) toModel() *models.SubwayStation {
+	station := &models.SubwayStation{
+		ID:        sr.StationID,
+		Name:      sr.StationName,
+		LineName:  sr.LineName,
+		LineColor: sr.LineColor,
+		CityID:    sr.CityID,
+		Distance:  sr.Distance,
+	}
+	if sr.StationLocationLat != nil && sr.StationLocationLon != nil {
+		station.Location = &models.Location{Lat: *sr.StationLocationLat, Lon: *sr.StationLocationLon}
+	}
+	return station
+}
+
+func (s *PostgresStore) GetSubwayStationDetails(ctx context.Context, stationID int) (*models.SubwayStation, error) {
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, baseQuery(`
+	SELECT {columns}
+	FROM subway_station ss
+	WHERE ss.station_id = ?0
+	`), stationID)
+	if err != nil {
+		return nil, err
+	}
+	if len(stations) == 0 {
+		return nil, nil
+	}
+	return stations[0], nil
+}
+
+func (s *PostgresStore) GetSubwayStations(ctx context.Context, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	orderBy := stationOrderBy(sorting)
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, orderBy.CompileBaseQuery(`
+	SELECT {columns}
+	FROM subway_station ss
+	WHERE ss.city_id = ?0
+	ORDER BY {order}
+	`), cityID)
+	if err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *PostgresStore) GetSubwayStationsWithoutCity(ctx context.Context, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	orderBy := stationOrderBy(sorting)
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, orderBy.CompileBaseQuery(`
+	SELECT {columns}
+	FROM subway_station ss
+	ORDER BY {order}
+	`))
+	if err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *PostgresStore) GetSubwayStationsByName(ctx context.Context, name string, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	orderBy := stationOrderBy(sorting)
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, orderBy.CompileBaseQuery(`
+	SELECT {columns}
+	FROM subway_station ss
+	WHERE name_search_key(ss.station_name) LIKE '%' || name_search_key(?0) || '%' AND ss.city_id = ?1
+	ORDER BY {order}
+	`), name, cityID)
+	if err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *PostgresStore) GetSubwayStationsByNameWithoutCity(ctx context.Context, name string, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	orderBy := stationOrderBy(sorting)
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, orderBy.CompileBaseQuery(`
+	SELECT {columns}
+	FROM subway_station ss
+	WHERE name_search_key(ss.station_name) LIKE '%' || name_search_key(?0) || '%'
+	ORDER BY {order}
+	`), name)
+	if err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *PostgresStore) GetSubwayStationsNear(ctx context.Context, location *models.Location, radius float64, cityID int, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	orderBy := stationOrderBy(sorting)
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, orderBy.CompileBaseQuery(`
+	SELECT {columns},
+	  st_distance(
+		  st_transform(ss.station_location, 26986),
+		  st_transform(st_setsrid(st_point(?0, ?1), 4326), 26986)
+	  ) distance
+	FROM subway_station ss
+	WHERE st_dwithin(st_transform(ss.station_location, 26986), st_transform(st_setsrid(st_point(?0, ?1), 4326), 26986), ?2)
+	  AND ss.city_id = ?3
+	ORDER BY {order}
+	`), location.Lon, location.Lat, radius, cityID)
+	if err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *PostgresStore) GetSubwayStationsNearWithoutCity(ctx context.Context, location *models.Location, radius float64, sorting models.Sorting) ([]*models.SubwayStation, error) {
+	orderBy := stationOrderBy(sorting)
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, orderBy.CompileBaseQuery(`
+	SELECT {columns},
+	  st_distance(
+		  st_transform(ss.station_location, 26986),
+		  st_transform(st_setsrid(st_point(?0, ?1), 4326), 26986)
+	  ) distance
+	FROM subway_station ss
+	WHERE st_dwithin(st_transform(ss.station_location, 26986), st_transform(st_setsrid(st_point(?0, ?1), 4326), 26986), ?2)
+	ORDER BY {order}
+	`), location.Lon, location.Lat, radius)
+	if err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (s *PostgresStore) GetNearestSubwayStation(ctx context.Context, location *models.Location) (*models.SubwayStation, error) {
+	queryName := utils.CurrentFuncName()
+	stations, err := stationsQuery(ctx, queryName, baseQuery(`
+	SELECT {columns},
+	  st_distance(
+		  st_transform(ss.station_location, 26986),
+		  st_transform(st_setsrid(st_point(?0, ?1), 4326), 26986)
+	  ) distance
+	FROM subway_station ss
+	WHERE ss.station_location IS NOT NULL
+	ORDER BY ss.station_location <-> st_setsrid(st_point(?0, ?1), 4326)
+	LIMIT 1
+	`), location.Lon, location.Lat)
+	if err != nil {
+		return nil, err
+	}
+	if len(stations) == 0 {
+		return nil, nil
+	}
+	return stations[0], nil
+}
+
+func stationsQuery(ctx context.Context, queryName string, queryBasis baseQuery, args ...interface{}) ([]*models.SubwayStation, error) {
+	client := observed(ctx, GetClient(), queryName)
+	var rows []*stationRow
+	query := queryBasis.withColumns(`
+	  ss.station_id,
+	  ss.station_name,
+	  ss.line_name,
+	  ss.line_color,
+	  ss.city_id,
+	  ST_Y(ss.station_location) station_location_lat,
+	  ST_X(ss.station_location) station_location_lon
+	`)
+	_, err := client.Query(&rows, query, args...)
+	if err != nil {
+		return nil, errors.WithMessage(err, queryName)
+	}
+	stations := make([]*models.SubwayStation, len(rows))
+	for i, row := range rows {
+		stations[i] = row.toModel()
+	}
+	return stations, nil
+}
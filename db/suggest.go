@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mallfin_api/models"
+	"mallfin_api/utils"
+
+	"github.com/pkg/errors"
+)
+
+// shorter queries match only the beginning of names, that is served by the prefix indexes
+const minFuzzyQueryLength = 3
+
+// suggestions of one type, the best matching name of every object.
+// Args: ?0 query search key, ?1 name prefix pattern, ?2 word prefix pattern, ?3 fuzzy matching enabled, ?4 city, ?5 limit.
+const suggestionsBranch = `
+	(SELECT *
+	 FROM (SELECT DISTINCT ON (%[2]s)
+			 '%[1]s' suggestion_type,
+			 %[2]s suggestion_id,
+			 %[3]s suggestion_name,
+			 %[4]s matched_alias,
+			 round(((CASE WHEN name_search_key(%[4]s) LIKE ?1 OR name_search_key(%[4]s) LIKE ?2 THEN 1 ELSE 0 END
+					 + word_similarity(?0, name_search_key(%[4]s))) / 2)::NUMERIC, 6)::FLOAT8 score
+		   FROM %[5]s
+		   WHERE (name_search_key(%[4]s) LIKE ?1 OR ?3 AND (name_search_key(%[4]s) LIKE ?2 OR ?0 <%% name_search_key(%[4]s)))
+			 AND (?4::INTEGER IS NULL OR %[6]s)
+		   ORDER BY %[2]s, score DESC) s
+	 ORDER BY score DESC
+	 LIMIT ?5)`
+
+var suggestionsQuery = `
+	SELECT *
+	FROM (` + strings.Join([]string{
+	fmt.Sprintf(suggestionsBranch, models.MallSuggestion, "m.mall_id", "m.mall_name", "mn.mall_name",
+		"mall_name mn JOIN mall m ON mn.mall_id = m.mall_id", "m.city_id = ?4"),
+	fmt.Sprintf(suggestionsBranch, models.ShopSuggestion, "s.shop_id", "s.shop_name", "sn.shop_name",
+		"shop_name sn JOIN shop s ON sn.shop_id = s.shop_id",
+		"EXISTS (SELECT 1 FROM mall_shop ms JOIN mall m ON ms.mall_id = m.mall_id WHERE ms.shop_id = s.shop_id AND m.city_id = ?4)"),
+	fmt.Sprintf(suggestionsBranch, models.CategorySuggestion, "c.category_id", "c.category_name", "c.category_name",
+		"category c", "TRUE"),
+	fmt.Sprintf(suggestionsBranch, models.SubwayStationSuggestion, "ss.station_id", "ss.station_name", "ss.station_name",
+		"subway_station ss", "ss.city_id = ?4"),
+}, `
+	  UNION ALL`) + `) suggestions
+	ORDER BY score DESC, suggestion_type, suggestion_id
+	LIMIT ?5
+	`
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *PostgresStore) GetSuggestions(ctx context.Context, query string, cityID *int, limit int) ([]*models.Suggestion, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	key := utils.NameSearchKey(query)
+	escapedKey := likeEscaper.Replace(key)
+	fuzzy := len([]rune(key)) >= minFuzzyQueryLength
+	var rows []*struct {
+		SuggestionType string
+		SuggestionID   int
+		SuggestionName string
+		MatchedAlias   string
+		Score          float64
+	}
+	_, err := client.Query(&rows, suggestionsQuery, key, escapedKey+"%", "% "+escapedKey+"%", fuzzy, cityID, limit)
+	if err != nil {
+		return nil, errors.WithMessage(err, queryName)
+	}
+	suggestions := make([]*models.Suggestion, len(rows))
+	for i, row := range rows {
+		suggestions[i] = &models.Suggestion{
+			Type:         row.SuggestionType,
+			ID:           row.SuggestionID,
+			Name:         row.SuggestionName,
+			MatchedAlias: row.MatchedAlias,
+			Score:        row.Score,
+		}
+	}
+	return suggestions, nil
+}
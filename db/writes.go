@@ -0,0 +1,596 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mallfin_api/models"
+	"mallfin_api/utils"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+)
+
+type namesTable struct {
+	table      string
+	idColumn   string
+	nameColumn string
+}
+
+var (
+	mallNames = &namesTable{table: "mall_name", idColumn: "mall_id", nameColumn: "mall_name"}
+	shopNames = &namesTable{table: "shop_name", idColumn: "shop_id", nameColumn: "shop_name"}
+)
+
+func (nt *namesTable) set(tx *observedTx, id int, name string, aliases []string) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+	DELETE FROM %s
+	WHERE %s = ?0
+	`, nt.table, nt.idColumn), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`
+	INSERT INTO %s (%s, %s)
+	SELECT ?0, unnest(?1::TEXT[])
+	ON CONFLICT DO NOTHING
+	`, nt.table, nt.idColumn, nt.nameColumn), id, pg.Array(append([]string{name}, aliases...)))
+	return err
+}
+
+func (nt *namesTable) rename(tx *observedTx, id int, oldName, newName string) error {
+	if oldName == newName {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf(`
+	DELETE FROM %s
+	WHERE %s = ?0 AND %s = ?1
+	`, nt.table, nt.idColumn, nt.nameColumn), id, oldName)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`
+	INSERT INTO %s (%s, %s)
+	VALUES (?0, ?1)
+	ON CONFLICT DO NOTHING
+	`, nt.table, nt.idColumn, nt.nameColumn), id, newName)
+	return err
+}
+
+func (nt *namesTable) update(tx *observedTx, id int, oldName string, newName *string, aliases []string) error {
+	name := oldName
+	if newName != nil {
+		name = *newName
+	}
+	if aliases != nil {
+		return nt.set(tx, id, name, aliases)
+	}
+	return nt.rename(tx, id, oldName, name)
+}
+
+type counterColumn struct {
+	table       string
+	idColumn    string
+	countColumn string
+}
+
+var (
+	mallsShopsCount      = &counterColumn{table: "mall", idColumn: "mall_id", countColumn: "shops_count"}
+	shopsMallsCount      = &counterColumn{table: "shop", idColumn: "shop_id", countColumn: "malls_count"}
+	categoriesShopsCount = &counterColumn{table: "category", idColumn: "category_id", countColumn: "shops_count"}
+)
+
+type assignments struct {
+	columns []string
+	args    []interface{}
+}
+
+func newAssignments(id int) *assignments {
+	return &assignments{args: []interface{}{id}}
+}
+
+func (a *assignments) set(column string, value interface{}) {
+	a.columns = append(a.columns, fmt.Sprintf("%s = ?%d", column, len(a.args)))
+	a.args = append(a.args, value)
+}
+
+func (a *assignments) setPoint(column string, location *models.Location) {
+	a.columns = append(a.columns, fmt.Sprintf("%s = ST_SetSRID(ST_Point(?%d, ?%d), 4326)", column, len(a.args), len(a.args)+1))
+	a.args = append(a.args, location.Lon, location.Lat)
+}
+
+func (a *assignments) exec(tx *observedTx, table, idColumn string) error {
+	if len(a.columns) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf(`
+	UPDATE %s
+	SET %s
+	WHERE %s = ?0
+	`, table, strings.Join(a.columns, ", "), idColumn), a.args...)
+	return err
+}
+
+func (s *PostgresStore) CreateMall(ctx context.Context, changes *models.MallChanges) (int, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var mallID int
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var logo models.Logo
+		if changes.Logo != nil {
+			logo = *changes.Logo
+		}
+		var row struct {
+			MallID int
+		}
+		_, err := tx.QueryOne(&row, `
+		INSERT INTO mall (
+		  mall_name,
+		  mall_phone,
+		  mall_logo_small,
+		  mall_logo_large,
+		  mall_location,
+		  mall_radius,
+		  mall_site,
+		  address,
+		  day_and_night,
+		  city_id
+		)
+		VALUES (?0, ?1, ?2, ?3, ST_SetSRID(ST_Point(?4, ?5), 4326), ?6, ?7, ?8, ?9, ?10)
+		RETURNING mall_id
+		`, *changes.Name, stringValue(changes.Phone), logo.Small, logo.Large, changes.Location.Lon, changes.Location.Lat,
+			*changes.Radius, stringValue(changes.Site), stringValue(changes.Address), boolValue(changes.DayAndNight),
+			*changes.CityID)
+		if err != nil {
+			return err
+		}
+		mallID = row.MallID
+		err = setMallSubwayStations(tx, mallID, changes.SubwayStations)
+		if err != nil {
+			return err
+		}
+		return mallNames.set(tx, mallID, *changes.Name, changes.Aliases)
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, queryName)
+	}
+	return mallID, nil
+}
+
+func (s *PostgresStore) UpdateMall(ctx context.Context, mallID int, changes *models.MallChanges) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var found bool
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var row struct {
+			MallName string
+		}
+		_, err := tx.QueryOne(&row, `
+		SELECT mall_name
+		FROM mall
+		WHERE mall_id = ?0
+		FOR UPDATE
+		`, mallID)
+		if err == pg.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		found = true
+		err = mallAssignments(mallID, changes).exec(tx, "mall", "mall_id")
+		if err != nil {
+			return err
+		}
+		err = setMallSubwayStations(tx, mallID, changes.SubwayStations)
+		if err != nil {
+			return err
+		}
+		return mallNames.update(tx, mallID, row.MallName, changes.Name, changes.Aliases)
+	})
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return found, nil
+}
+
+func (s *PostgresStore) DeleteMall(ctx context.Context, mallID int) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var found bool
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var err error
+		// the links attached concurrently are committed before the lock is granted,
+		// so the delete below counts them instead of the cascade dropping them silently
+		found, err = lockRow(tx, "mall", "mall_id", mallID)
+		if err != nil || !found {
+			return err
+		}
+		shopIDs, err := returningIDs(tx, `
+		DELETE FROM mall_shop
+		WHERE mall_id = ?0
+		RETURNING shop_id AS id
+		`, mallID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		DELETE FROM mall
+		WHERE mall_id = ?0
+		`, mallID)
+		if err != nil {
+			return err
+		}
+		return shopsMallsCount.add(tx, shopIDs, -1)
+	})
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return found, nil
+}
+
+func (s *PostgresStore) CreateShop(ctx context.Context, changes *models.ShopChanges) (int, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var shopID int
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var logo models.Logo
+		if changes.Logo != nil {
+			logo = *changes.Logo
+		}
+		var score int
+		if changes.Score != nil {
+			score = *changes.Score
+		}
+		var row struct {
+			ShopID int
+		}
+		_, err := tx.QueryOne(&row, `
+		INSERT INTO shop (
+		  shop_name,
+		  shop_logo_small,
+		  shop_logo_large,
+		  shop_phone,
+		  shop_site,
+		  score
+		)
+		VALUES (?0, ?1, ?2, ?3, ?4, ?5)
+		RETURNING shop_id
+		`, *changes.Name, logo.Small, logo.Large, stringValue(changes.Phone), stringValue(changes.Site), score)
+		if err != nil {
+			return err
+		}
+		shopID = row.ShopID
+		return shopNames.set(tx, shopID, *changes.Name, changes.Aliases)
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, queryName)
+	}
+	return shopID, nil
+}
+
+func (s *PostgresStore) UpdateShop(ctx context.Context, shopID int, changes *models.ShopChanges) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var found bool
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var row struct {
+			ShopName string
+		}
+		_, err := tx.QueryOne(&row, `
+		SELECT shop_name
+		FROM shop
+		WHERE shop_id = ?0
+		FOR UPDATE
+		`, shopID)
+		if err == pg.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		found = true
+		err = shopAssignments(shopID, changes).exec(tx, "shop", "shop_id")
+		if err != nil {
+			return err
+		}
+		return shopNames.update(tx, shopID, row.ShopName, changes.Name, changes.Aliases)
+	})
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return found, nil
+}
+
+func (s *PostgresStore) DeleteShop(ctx context.Context, shopID int) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var found bool
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var err error
+		found, err = lockRow(tx, "shop", "shop_id", shopID)
+		if err != nil || !found {
+			return err
+		}
+		mallIDs, err := returningIDs(tx, `
+		DELETE FROM mall_shop
+		WHERE shop_id = ?0
+		RETURNING mall_id AS id
+		`, shopID)
+		if err != nil {
+			return err
+		}
+		categoryIDs, err := returningIDs(tx, `
+		DELETE FROM shop_category
+		WHERE shop_id = ?0
+		RETURNING category_id AS id
+		`, shopID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		DELETE FROM shop
+		WHERE shop_id = ?0
+		`, shopID)
+		if err != nil {
+			return err
+		}
+		err = mallsShopsCount.add(tx, mallIDs, -1)
+		if err != nil {
+			return err
+		}
+		return categoriesShopsCount.add(tx, categoryIDs, -1)
+	})
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return found, nil
+}
+
+func (s *PostgresStore) ChangeShopMalls(ctx context.Context, shopID int, attachMallIDs, detachMallIDs []int) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var found bool
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var err error
+		found, err = lockRow(tx, "shop", "shop_id", shopID)
+		if err != nil || !found {
+			return err
+		}
+		var attachedIDs, detachedIDs []int
+		if len(attachMallIDs) != 0 {
+			attachedIDs, err = returningIDs(tx, `
+			INSERT INTO mall_shop (mall_id, shop_id)
+			SELECT unnest(?1::INTEGER[]), ?0
+			ON CONFLICT DO NOTHING
+			RETURNING mall_id AS id
+			`, shopID, pg.Array(attachMallIDs))
+			if err != nil {
+				return err
+			}
+		}
+		if len(detachMallIDs) != 0 {
+			detachedIDs, err = returningIDs(tx, `
+			DELETE FROM mall_shop
+			WHERE shop_id = ?0 AND mall_id = ANY (?1)
+			RETURNING mall_id AS id
+			`, shopID, pg.Array(detachMallIDs))
+			if err != nil {
+				return err
+			}
+		}
+		err = mallsShopsCount.add(tx, attachedIDs, 1)
+		if err != nil {
+			return err
+		}
+		err = mallsShopsCount.add(tx, detachedIDs, -1)
+		if err != nil {
+			return err
+		}
+		return shopsMallsCount.add(tx, []int{shopID}, len(attachedIDs)-len(detachedIDs))
+	})
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return found, nil
+}
+
+func (s *PostgresStore) SetShopPlacement(ctx context.Context, shopID, mallID int, placement *models.ShopPlacement) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	result, err := client.Exec(`
+	UPDATE mall_shop
+	SET floor = ?2, unit = ?3, entrance = ?4, phone = ?5
+	WHERE shop_id = ?0 AND mall_id = ?1
+	`, shopID, mallID, placement.Floor, placement.Unit, placement.Entrance, placement.Phone)
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return result.RowsAffected() != 0, nil
+}
+
+func (s *PostgresStore) ChangeShopCategories(ctx context.Context, shopID int, attachCategoryIDs, detachCategoryIDs []int) (bool, error) {
+	queryName := utils.CurrentFuncName()
+	client := observed(ctx, GetClient(), queryName)
+	var found bool
+	err := client.RunInTransaction(func(tx *observedTx) error {
+		var err error
+		found, err = lockRow(tx, "shop", "shop_id", shopID)
+		if err != nil || !found {
+			return err
+		}
+		var attachedIDs, detachedIDs []int
+		if len(attachCategoryIDs) != 0 {
+			attachedIDs, err = returningIDs(tx, `
+			INSERT INTO shop_category (shop_id, category_id)
+			SELECT ?0, unnest(?1::INTEGER[])
+			ON CONFLICT DO NOTHING
+			RETURNING category_id AS id
+			`, shopID, pg.Array(attachCategoryIDs))
+			if err != nil {
+				return err
+			}
+		}
+		if len(detachCategoryIDs) != 0 {
+			detachedIDs, err = returningIDs(tx, `
+			DELETE FROM shop_category
+			WHERE shop_id = ?0 AND category_id = ANY (?1)
+			RETURNING category_id AS id
+			`, shopID, pg.Array(detachCategoryIDs))
+			if err != nil {
+				return err
+			}
+		}
+		err = categoriesShopsCount.add(tx, attachedIDs, 1)
+		if err != nil {
+			return err
+		}
+		return categoriesShopsCount.add(tx, detachedIDs, -1)
+	})
+	if err != nil {
+		return false, errors.WithMessage(err, queryName)
+	}
+	return found, nil
+}
+
+func mallAssignments(mallID int, changes *models.MallChanges) *assignments {
+	a := newAssignments(mallID)
+	if changes.Name != nil {
+		a.set("mall_name", *changes.Name)
+	}
+	if changes.Phone != nil {
+		a.set("mall_phone", *changes.Phone)
+	}
+	if changes.Logo != nil {
+		a.set("mall_logo_small", changes.Logo.Small)
+		a.set("mall_logo_large", changes.Logo.Large)
+	}
+	if changes.Location != nil {
+		a.setPoint("mall_location", changes.Location)
+	}
+	if changes.Radius != nil {
+		a.set("mall_radius", *changes.Radius)
+	}
+	if changes.Address != nil {
+		a.set("address", *changes.Address)
+	}
+	if changes.Site != nil {
+		a.set("mall_site", *changes.Site)
+	}
+	if changes.DayAndNight != nil {
+		a.set("day_and_night", *changes.DayAndNight)
+	}
+	if changes.CityID != nil {
+		a.set("city_id", *changes.CityID)
+	}
+	return a
+}
+
+func shopAssignments(shopID int, changes *models.ShopChanges) *assignments {
+	a := newAssignments(shopID)
+	if changes.Name != nil {
+		a.set("shop_name", *changes.Name)
+	}
+	if changes.Phone != nil {
+		a.set("shop_phone", *changes.Phone)
+	}
+	if changes.Site != nil {
+		a.set("shop_site", *changes.Site)
+	}
+	if changes.Logo != nil {
+		a.set("shop_logo_small", changes.Logo.Small)
+		a.set("shop_logo_large", changes.Logo.Large)
+	}
+	if changes.Score != nil {
+		a.set("score", *changes.Score)
+	}
+	return a
+}
+
+func lockRow(tx *observedTx, table, idColumn string, id int) (bool, error) {
+	var row struct {
+		ID int
+	}
+	_, err := tx.QueryOne(&row, fmt.Sprintf(`
+	SELECT %s AS id
+	FROM %s
+	WHERE %s = ?0
+	FOR UPDATE
+	`, idColumn, table, idColumn), id)
+	if err == pg.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// returningIDs runs an insert or a delete with RETURNING ... AS id, only the rows it actually changed are returned.
+func returningIDs(tx *observedTx, query string, args ...interface{}) ([]int, error) {
+	var rows []*struct {
+		ID int
+	}
+	_, err := tx.Query(&rows, query, args...)
+	if err != nil && err != pg.ErrNoRows {
+		return nil, err
+	}
+	ids := make([]int, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// add shifts the counter by delta for every id. An update of the counter waits for the concurrent ones
+// and applies to the latest row version, unlike recounting with a subquery that sees only the statement snapshot.
+func (cc *counterColumn) add(tx *observedTx, ids []int, delta int) error {
+	if len(ids) == 0 || delta == 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf(`
+	UPDATE %s t
+	SET %s = t.%s + ?1 * changed.times
+	FROM (SELECT id, count(*) AS times
+	      FROM unnest(?0::INTEGER[]) id
+	      GROUP BY id) changed
+	WHERE t.%s = changed.id
+	`, cc.table, cc.countColumn, cc.countColumn, cc.idColumn), pg.Array(ids), delta)
+	return err
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func boolValue(b *bool) bool {
+	if b == nil {
+		return false
+	}
+	return *b
+}
+
+// setMallSubwayStations replaces the linked stations, nil keeps them.
+func setMallSubwayStations(tx *observedTx, mallID int, stations []*models.SubwayStationWalk) error {
+	if stations == nil {
+		return nil
+	}
+	_, err := tx.Exec(`
+	DELETE FROM mall_subway_station
+	WHERE mall_id = ?0
+	`, mallID)
+	if err != nil {
+		return err
+	}
+	for _, station := range stations {
+		_, err = tx.Exec(`
+		INSERT INTO mall_subway_station (mall_id, station_id, walk_distance, walk_minutes)
+		VALUES (?0, ?1, ?2, ?3)
+		`, mallID, station.StationID, station.WalkDistance, station.WalkMinutes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"mallfin_api/cache"
+	"mallfin_api/config"
+	"mallfin_api/logging"
+	"mallfin_api/serializers"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gazoon/binding"
+	"github.com/gazoon/httprouter"
+)
+
+const (
+	adminTokenPrefix = "Bearer "
+
+	mallsCollection        = "malls"
+	mallClustersCollection = "mall_clusters"
+	shopsCollection        = "shops"
+	categoriesCollection   = "categories"
+	currentMallCollection  = "current_mall"
+	searchCollection       = "search"
+	shopsInMallsCollection = "shops_in_malls"
+	suggestCollection      = "suggest"
+	planCollection         = "plan"
+)
+
+var (
+	mallDependentTags = []string{
+		mallsCollection, currentMallCollection, searchCollection, shopsInMallsCollection, shopsCollection,
+		categoriesCollection, cache.DetailsTag(shopsCollection), cache.DetailsTag(categoriesCollection), suggestCollection,
+		planCollection, cache.DetailsTag(mallsCollection), mallClustersCollection,
+	}
+	shopDependentTags = []string{
+		shopsCollection, mallsCollection, searchCollection, shopsInMallsCollection, categoriesCollection,
+		cache.DetailsTag(categoriesCollection), cache.DetailsTag(mallsCollection), suggestCollection, planCollection,
+		mallClustersCollection,
+	}
+)
+
+func AdminOnly(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx)
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, adminTokenPrefix)
+		adminToken := config.AdminToken()
+		if adminToken == "" || !strings.HasPrefix(header, adminTokenPrefix) ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logger.Warn("Admin request with invalid token")
+			errorResponse(ctx, w, UNAUTHORIZED, "Valid admin token required.", http.StatusUnauthorized)
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+func invalidateCache(ctx context.Context, tags ...string) {
+	logger := logging.FromContext(ctx)
+	err := cache.Invalidate(ctx, tags...)
+	if err != nil {
+		logger.Errorf("Cannot invalidate cache: %s", err)
+	}
+}
+
+func invalidateMall(ctx context.Context, mallID int) {
+	tags := append([]string{cache.EntityTag(mallsCollection, mallID)}, mallDependentTags...)
+	invalidateCache(ctx, tags...)
+}
+
+func invalidateShop(ctx context.Context, shopID int, extraTags ...string) {
+	tags := append([]string{cache.EntityTag(shopsCollection, shopID)}, shopDependentTags...)
+	invalidateCache(ctx, append(tags, extraTags...)...)
+}
+
+func checkReference(ctx context.Context, w http.ResponseWriter, name string, exists bool, err error) bool {
+	logger := logging.FromContext(ctx)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return false
+	}
+	if !exists {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, fmt.Sprintf(DoesNotExistMsg, name), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func checkMallReferences(ctx context.Context, w http.ResponseWriter, formData *mallForm) bool {
+	if formData.City != nil {
+		exists, err := stores.Cities.IsCityExists(ctx, *formData.City)
+		if !checkReference(ctx, w, "City", exists, err) {
+			return false
+		}
+	}
+	for _, station := range formData.SubwayStations {
+		exists, err := stores.Malls.IsSubwayStationExists(ctx, station.ID)
+		if !checkReference(ctx, w, "Subway station", exists, err) {
+			return false
+		}
+	}
+	return true
+}
+
+func mallDetailsResponse(ctx context.Context, w http.ResponseWriter, mallID int, status int) {
+	logger := logging.FromContext(ctx)
+	mall, err := stores.Malls.GetMallDetails(ctx, mallID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if mall == nil {
+		notFoundResponse(ctx, w, MALL_NOT_FOUND)
+		return
+	}
+	serialized := serializers.SerializeMall(mall, defaultScheduleDays)
+	writeJSON(ctx, w, SuccessResponse{Data: serialized}, status)
+}
+
+func CreateMall(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := &mallForm{}
+	errs := binding.Json(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkMallReferences(ctx, w, formData) {
+		return
+	}
+	mallID, err := stores.Malls.CreateMall(ctx, formData.toChanges())
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	logger.WithField("mall_id", mallID).Info("Mall created")
+	invalidateMall(ctx, mallID)
+	mallDetailsResponse(ctx, w, mallID, http.StatusCreated)
+}
+
+func saveMall(w http.ResponseWriter, r *http.Request, ps httprouter.Params, partial bool) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	mallID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	formData := &mallForm{partial: partial}
+	errs := binding.Json(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkMallReferences(ctx, w, formData) {
+		return
+	}
+	found, err := stores.Malls.UpdateMall(ctx, mallID, formData.toChanges())
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if !found {
+		notFoundResponse(ctx, w, MALL_NOT_FOUND)
+		return
+	}
+	logger.WithField("mall_id", mallID).Info("Mall updated")
+	invalidateMall(ctx, mallID)
+	mallDetailsResponse(ctx, w, mallID, http.StatusOK)
+}
+
+func ReplaceMall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	saveMall(w, r, ps, false)
+}
+
+func UpdateMall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	saveMall(w, r, ps, true)
+}
+
+func DeleteMall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	mallID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	found, err := stores.Malls.DeleteMall(ctx, mallID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if !found {
+		notFoundResponse(ctx, w, MALL_NOT_FOUND)
+		return
+	}
+	logger.WithField("mall_id", mallID).Info("Mall deleted")
+	invalidateMall(ctx, mallID)
+	noContentResponse(w)
+}
+
+type shopLinkKind struct {
+	name       string
+	param      string
+	collection string
+	change     func(ctx context.Context, shopID int, attachIDs, detachIDs []int) (bool, error)
+	exists     func(ctx context.Context, id int) (bool, error)
+}
+
+func shopDetailsResponse(ctx context.Context, w http.ResponseWriter, shopID int, status int) {
+	logger := logging.FromContext(ctx)
+	shop, err := stores.Shops.GetShopDetails(ctx, shopID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if shop == nil {
+		notFoundResponse(ctx, w, SHOP_NOT_FOUND)
+		return
+	}
+	serialized := serializers.SerializeShop(shop)
+	writeJSON(ctx, w, SuccessResponse{Data: serialized}, status)
+}
+
+func CreateShop(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := &shopForm{}
+	errs := binding.Json(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	shopID, err := stores.Shops.CreateShop(ctx, formData.toChanges())
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	logger.WithField("shop_id", shopID).Info("Shop created")
+	invalidateShop(ctx, shopID)
+	shopDetailsResponse(ctx, w, shopID, http.StatusCreated)
+}
+
+func saveShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params, partial bool) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	shopID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	formData := &shopForm{partial: partial}
+	errs := binding.Json(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	found, err := stores.Shops.UpdateShop(ctx, shopID, formData.toChanges())
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if !found {
+		notFoundResponse(ctx, w, SHOP_NOT_FOUND)
+		return
+	}
+	logger.WithField("shop_id", shopID).Info("Shop updated")
+	invalidateShop(ctx, shopID)
+	shopDetailsResponse(ctx, w, shopID, http.StatusOK)
+}
+
+func ReplaceShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	saveShop(w, r, ps, false)
+}
+
+func UpdateShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	saveShop(w, r, ps, true)
+}
+
+func DeleteShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	shopID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	found, err := stores.Shops.DeleteShop(ctx, shopID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if !found {
+		notFoundResponse(ctx, w, SHOP_NOT_FOUND)
+		return
+	}
+	logger.WithField("shop_id", shopID).Info("Shop deleted")
+	invalidateShop(ctx, shopID, cache.DetailsTag(mallsCollection))
+	noContentResponse(w)
+}
+
+func changeShopLinks(ctx context.Context, w http.ResponseWriter, shopID int, attachIDs, detachIDs []int, kind *shopLinkKind) {
+	logger := logging.FromContext(ctx)
+	for _, id := range attachIDs {
+		ok, err := kind.exists(ctx, id)
+		if !checkReference(ctx, w, kind.name, ok, err) {
+			return
+		}
+	}
+	found, err := kind.change(ctx, shopID, attachIDs, detachIDs)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if !found {
+		notFoundResponse(ctx, w, SHOP_NOT_FOUND)
+		return
+	}
+	logger.WithFields(log.Fields{"shop_id": shopID, "attach": attachIDs, "detach": detachIDs}).Infof("Shop %s links changed", strings.ToLower(kind.name))
+	tags := make([]string, 0, len(attachIDs)+len(detachIDs))
+	for _, id := range append(append([]int{}, attachIDs...), detachIDs...) {
+		tags = append(tags, cache.EntityTag(kind.collection, id))
+	}
+	invalidateShop(ctx, shopID, tags...)
+	shopDetailsResponse(ctx, w, shopID, http.StatusOK)
+}
+
+func bulkShopLinks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, kind *shopLinkKind) {
+	ctx := r.Context()
+	shopID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	formData := &shopLinksForm{}
+	errs := binding.Json(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	changeShopLinks(ctx, w, shopID, formData.Attach, formData.Detach, kind)
+}
+
+func singleShopLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params, kind *shopLinkKind, attach bool) {
+	ctx := r.Context()
+	shopID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	linkID, err := ps.ByNameInt(kind.param)
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if attach {
+		changeShopLinks(ctx, w, shopID, []int{linkID}, nil, kind)
+	} else {
+		changeShopLinks(ctx, w, shopID, nil, []int{linkID}, kind)
+	}
+}
+
+func mallLinks() *shopLinkKind {
+	return &shopLinkKind{
+		name:       "Mall",
+		param:      "mall_id",
+		collection: mallsCollection,
+		change:     stores.Shops.ChangeShopMalls,
+		exists:     stores.Malls.IsMallExists,
+	}
+}
+
+func categoryLinks() *shopLinkKind {
+	return &shopLinkKind{
+		name:       "Category",
+		param:      "category_id",
+		collection: categoriesCollection,
+		change:     stores.Shops.ChangeShopCategories,
+		exists:     stores.Categories.IsCategoryExists,
+	}
+}
+
+func ChangeShopMalls(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	bulkShopLinks(w, r, ps, mallLinks())
+}
+
+func AttachShopMall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	singleShopLink(w, r, ps, mallLinks(), true)
+}
+
+func DetachShopMall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	singleShopLink(w, r, ps, mallLinks(), false)
+}
+
+func ChangeShopCategories(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	bulkShopLinks(w, r, ps, categoryLinks())
+}
+
+func AttachShopCategory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	singleShopLink(w, r, ps, categoryLinks(), true)
+}
+
+func DetachShopCategory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	singleShopLink(w, r, ps, categoryLinks(), false)
+}
+
+func SetShopPlacement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	shopID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mallID, err := ps.ByNameInt("mall_id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	formData := &shopPlacementForm{}
+	errs := binding.Json(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkShop(ctx, w, shopID) {
+		return
+	}
+	placement := formData.toPlacement()
+	found, err := stores.Shops.SetShopPlacement(ctx, shopID, mallID, placement)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if !found {
+		errorResponse(ctx, w, MALL_NOT_FOUND, "The shop is not in this mall.", http.StatusNotFound)
+		return
+	}
+	logger.WithFields(log.Fields{"shop_id": shopID, "mall_id": mallID}).Info("Shop placement changed")
+	invalidateShop(ctx, shopID, cache.EntityTag(mallsCollection, mallID))
+	serialized := serializers.SerializeShopPlacement(placement)
+	response(ctx, w, serialized)
+}
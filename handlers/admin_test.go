@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mallfin_api/db"
+	"mallfin_api/middlewares"
+)
+
+const testMallBody = `{"name": "Mega", "location": {"lat": 55.911, "lon": 37.397}, "radius": 500, "city": 1}`
+
+// useFreshStore points the handlers to a new test store until the end of the test,
+// so that the writes do not leak into the other tests.
+func useFreshStore(t *testing.T) {
+	useStore(t, newTestStore())
+}
+
+func useStore(t *testing.T, store *db.MemoryStore) {
+	previous := stores
+	Initialization(newTestStores(store))
+	t.Cleanup(func() {
+		Initialization(previous)
+	})
+}
+
+func doRequest(t *testing.T, method, target, authorization, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+func doAdmin(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequest(t, method, target, adminTokenPrefix+testAdminToken, body)
+}
+
+func checkStatus(t *testing.T, w *httptest.ResponseRecorder, method, target string, expected int) {
+	t.Helper()
+	if w.Code != expected {
+		t.Errorf("%s %s: status %d, expected %d, body %s", method, target, w.Code, expected, w.Body)
+	}
+}
+
+// getDetails decodes the data of a successful response into result.
+func getDetails(t *testing.T, target string, result interface{}) {
+	t.Helper()
+	w := doGet(t, target)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	resp := struct {
+		Data interface{} `json:"data"`
+	}{Data: result}
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("GET %s: cannot decode %s: %s", target, w.Body, err)
+	}
+}
+
+type countsCase struct {
+	target string
+	field  string
+	count  int
+}
+
+func checkCounts(t *testing.T, cases []countsCase) {
+	t.Helper()
+	for _, c := range cases {
+		counts := map[string]interface{}{}
+		getDetails(t, c.target, &counts)
+		if count, _ := counts[c.field].(float64); int(count) != c.count {
+			t.Errorf("GET %s: %s is %v, expected %d", c.target, c.field, counts[c.field], c.count)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	useFreshStore(t)
+	for _, authorization := range []string{
+		"",
+		// the token without the scheme
+		testAdminToken,
+		"Bearer",
+		"Bearer wrong-token",
+		"Basic " + testAdminToken,
+		"Bearer " + testAdminToken + "x",
+	} {
+		w := doRequest(t, http.MethodPost, "/malls/", authorization, testMallBody)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, expected 401", authorization, w.Code)
+		}
+	}
+	checkListCases(t, []listCase{{"/malls/?city=1", []int{evropeisky, afimall, atrium}, 3}})
+	w := doAdmin(t, http.MethodPost, "/malls/", testMallBody)
+	checkStatus(t, w, http.MethodPost, "/malls/", http.StatusCreated)
+}
+
+func TestMallWrites(t *testing.T) {
+	useFreshStore(t)
+	w := doAdmin(t, http.MethodPost, "/malls/", testMallBody)
+	checkStatus(t, w, http.MethodPost, "/malls/", http.StatusCreated)
+	checkListCases(t, []listCase{{"/malls/?city=1", []int{evropeisky, afimall, atrium, 5}, 4}})
+	checkCounts(t, []countsCase{{"/malls/5/", "shops_count", 0}})
+
+	w = doAdmin(t, http.MethodPatch, "/malls/5/", `{"name": "Mega Khimki"}`)
+	checkStatus(t, w, http.MethodPatch, "/malls/5/", http.StatusOK)
+	mall := struct {
+		Name string `json:"name"`
+	}{}
+	getDetails(t, "/malls/5/", &mall)
+	if mall.Name != "Mega Khimki" {
+		t.Errorf("mall name %q after the patch, expected %q", mall.Name, "Mega Khimki")
+	}
+
+	w = doAdmin(t, http.MethodPut, "/malls/5/", `{"name": "Mega", "location": {"lat": 59.9, "lon": 30.3}, "radius": 500, "city": 2}`)
+	checkStatus(t, w, http.MethodPut, "/malls/5/", http.StatusOK)
+	checkListCases(t, []listCase{{"/malls/?city=2", []int{galeria, 5}, 2}})
+}
+
+func TestMallWritesValidation(t *testing.T) {
+	useFreshStore(t)
+	for _, body := range []string{
+		`{`,
+		`{}`,
+		`{"location": {"lat": 55.911, "lon": 37.397}, "radius": 500, "city": 1}`,
+		`{"name": " ", "location": {"lat": 55.911, "lon": 37.397}, "radius": 500, "city": 1}`,
+		`{"name": "Mega", "location": {"lat": 91, "lon": 37.397}, "radius": 500, "city": 1}`,
+		`{"name": "Mega", "location": {"lat": 55.911, "lon": 37.397}, "radius": -1, "city": 1}`,
+		`{"name": "Mega", "location": {"lat": 55.911, "lon": 37.397}, "radius": 500, "city": 100}`,
+		`{"name": "Mega", "location": {"lat": 55.911, "lon": 37.397}, "radius": 500, "city": 1, "subway_stations": [{"id": 1}, {"id": 1}]}`,
+	} {
+		w := doAdmin(t, http.MethodPost, "/malls/", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST /malls/ %s: status %d, expected 400, body %s", body, w.Code, w.Body)
+		}
+	}
+	// a replacement needs all the required fields, unlike a patch
+	checkStatus(t, doAdmin(t, http.MethodPut, "/malls/1/", `{"name": "Mega"}`), http.MethodPut, "/malls/1/", http.StatusBadRequest)
+	checkStatus(t, doAdmin(t, http.MethodPatch, "/malls/1/", `{"radius": 0}`), http.MethodPatch, "/malls/1/", http.StatusBadRequest)
+	checkStatus(t, doAdmin(t, http.MethodPatch, "/malls/x/", `{"name": "Mega"}`), http.MethodPatch, "/malls/x/", http.StatusBadRequest)
+	checkStatus(t, doAdmin(t, http.MethodPatch, "/malls/100/", `{"name": "Mega"}`), http.MethodPatch, "/malls/100/", http.StatusNotFound)
+	checkStatus(t, doAdmin(t, http.MethodDelete, "/malls/100/", ""), http.MethodDelete, "/malls/100/", http.StatusNotFound)
+	checkListCases(t, []listCase{{"/malls/", []int{evropeisky, afimall, galeria, atrium}, 4}})
+}
+
+func TestDeleteMall(t *testing.T) {
+	useFreshStore(t)
+	w := doAdmin(t, http.MethodDelete, "/malls/1/", "")
+	checkStatus(t, w, http.MethodDelete, "/malls/1/", http.StatusNoContent)
+	checkNotFound(t, []string{"/malls/1/"})
+	// the links of the mall go with it, and the shops count it no more
+	mallsCounts := map[int]int{}
+	for _, raw := range getPage(t, "/shops/").Results {
+		shop := struct {
+			ID         int `json:"id"`
+			MallsCount int `json:"malls_count"`
+		}{}
+		err := json.Unmarshal(raw, &shop)
+		if err != nil {
+			t.Fatalf("cannot decode shop %s: %s", raw, err)
+		}
+		mallsCounts[shop.ID] = shop.MallsCount
+	}
+	expected := map[int]int{zara: 2, hm: 1, apple: 1, lego: 0}
+	if !reflect.DeepEqual(mallsCounts, expected) {
+		t.Errorf("malls_count of the shops %v, expected %v", mallsCounts, expected)
+	}
+	checkListCases(t, []listCase{
+		{"/malls/?shop=2", []int{galeria}, 1},
+		{"/shops/?city=1", []int{zara, apple}, 2},
+	})
+	checkStatus(t, doAdmin(t, http.MethodDelete, "/malls/1/", ""), http.MethodDelete, "/malls/1/", http.StatusNotFound)
+}
+
+func TestShopWrites(t *testing.T) {
+	useFreshStore(t)
+	w := doAdmin(t, http.MethodPost, "/shops/", `{"name": "Uniqlo", "score": 3}`)
+	checkStatus(t, w, http.MethodPost, "/shops/", http.StatusCreated)
+	checkCounts(t, []countsCase{{"/shops/5/", "malls_count", 0}})
+	checkListCases(t, []listCase{{"/shops/?sort=-score", []int{apple, lego, zara, hm, 5}, 5}})
+
+	w = doAdmin(t, http.MethodPatch, "/shops/5/", `{"score": 10}`)
+	checkStatus(t, w, http.MethodPatch, "/shops/5/", http.StatusOK)
+	checkListCases(t, []listCase{{"/shops/?sort=-score&limit=1", []int{5}, 5}})
+
+	for _, c := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPost, "/shops/", `{"score": 3}`, http.StatusBadRequest},
+		{http.MethodPost, "/shops/", `{"name": ""}`, http.StatusBadRequest},
+		{http.MethodPost, "/shops/", `{"name": "Uniqlo", "score": -1}`, http.StatusBadRequest},
+		{http.MethodPut, "/shops/5/", `{"score": 3}`, http.StatusBadRequest},
+		{http.MethodPatch, "/shops/100/", `{"score": 3}`, http.StatusNotFound},
+		{http.MethodDelete, "/shops/100/", "", http.StatusNotFound},
+	} {
+		checkStatus(t, doAdmin(t, c.method, c.target, c.body), c.method, c.target, c.status)
+	}
+}
+
+func TestShopMallLinks(t *testing.T) {
+	useFreshStore(t)
+	for _, c := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPost, "/shops/4/malls/", `{"attach": [1, 2]}`, http.StatusOK},
+		// attaching a linked mall again changes nothing
+		{http.MethodPut, "/shops/4/malls/1/", "", http.StatusOK},
+		{http.MethodPost, "/shops/4/malls/", `{"attach": [1], "detach": [2, 3]}`, http.StatusOK},
+		{http.MethodPost, "/shops/4/malls/", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/shops/4/malls/", `{"attach": [0]}`, http.StatusBadRequest},
+		{http.MethodPost, "/shops/4/malls/", `{"attach": [4, 100]}`, http.StatusBadRequest},
+		{http.MethodPut, "/shops/100/malls/1/", "", http.StatusNotFound},
+	} {
+		checkStatus(t, doAdmin(t, c.method, c.target, c.body), c.method, c.target, c.status)
+	}
+	checkCounts(t, []countsCase{
+		{"/shops/4/", "malls_count", 1},
+		{"/malls/1/", "shops_count", 4},
+		{"/malls/2/", "shops_count", 1},
+		{"/malls/3/", "shops_count", 2},
+		{"/malls/4/", "shops_count", 1},
+	})
+	checkListCases(t, []listCase{{"/malls/?shop=4", []int{evropeisky}, 1}})
+
+	w := doAdmin(t, http.MethodDelete, "/shops/1/malls/3/", "")
+	checkStatus(t, w, http.MethodDelete, "/shops/1/malls/3/", http.StatusOK)
+	checkCounts(t, []countsCase{
+		{"/shops/1/", "malls_count", 2},
+		{"/malls/3/", "shops_count", 1},
+	})
+	checkListCases(t, []listCase{{"/shops/?city=2", []int{hm}, 1}})
+}
+
+func TestShopCategoryLinks(t *testing.T) {
+	useFreshStore(t)
+	for _, c := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPut, "/shops/4/categories/2/", "", http.StatusOK},
+		{http.MethodPost, "/shops/1/categories/", `{"attach": [2], "detach": [1]}`, http.StatusOK},
+		{http.MethodPut, "/shops/4/categories/100/", "", http.StatusBadRequest},
+	} {
+		checkStatus(t, doAdmin(t, c.method, c.target, c.body), c.method, c.target, c.status)
+	}
+	checkCounts(t, []countsCase{
+		{"/categories/1/", "shops_count", 1},
+		{"/categories/2/", "shops_count", 3},
+	})
+	checkListCases(t, []listCase{{"/shops/?category=2", []int{zara, apple, lego}, 3}})
+
+	w := doAdmin(t, http.MethodDelete, "/shops/4/categories/2/", "")
+	checkStatus(t, w, http.MethodDelete, "/shops/4/categories/2/", http.StatusOK)
+	checkCounts(t, []countsCase{{"/categories/2/", "shops_count", 2}})
+}
+
+func TestDeleteShop(t *testing.T) {
+	useFreshStore(t)
+	w := doAdmin(t, http.MethodDelete, "/shops/1/", "")
+	checkStatus(t, w, http.MethodDelete, "/shops/1/", http.StatusNoContent)
+	checkNotFound(t, []string{"/shops/1/", "/malls/?shop=1"})
+	// the mall and category links of the shop go with it
+	checkCounts(t, []countsCase{
+		{"/malls/1/", "shops_count", 2},
+		{"/malls/2/", "shops_count", 0},
+		{"/malls/3/", "shops_count", 1},
+		{"/categories/1/", "shops_count", 1},
+	})
+	checkListCases(t, []listCase{
+		{"/search/?shops=1&shops=2", []int{evropeisky, galeria}, 2},
+		{"/shops/?category=1", []int{hm}, 1},
+	})
+}
+
+func doCached(t *testing.T, target, expectedCache string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	w := httptest.NewRecorder()
+	middlewares.CacheMiddleware(w, req, testRouter.ServeHTTP)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	if cached := w.Header().Get("X-Cache"); cached != expectedCache {
+		t.Errorf("GET %s: X-Cache %q, expected %q", target, cached, expectedCache)
+	}
+	return w
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	useFreshStore(t)
+	first := doCached(t, "/malls/1/", "MISS")
+	second := doCached(t, "/malls/1/", "HIT")
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("Cached body %s differs from %s", second.Body, first.Body)
+	}
+	doCached(t, "/malls/?city=1", "MISS")
+	doCached(t, "/malls/?city=1", "HIT")
+	// the order of the shops does not make a new entry
+	doCached(t, "/search/?shops=1&shops=2", "MISS")
+	doCached(t, "/search/?shops=2&shops=1", "HIT")
+
+	w := doAdmin(t, http.MethodPatch, "/malls/1/", `{"name": "Evropeisky"}`)
+	checkStatus(t, w, http.MethodPatch, "/malls/1/", http.StatusOK)
+	w = doCached(t, "/malls/1/", "MISS")
+	if !strings.Contains(w.Body.String(), `"Evropeisky"`) {
+		t.Errorf("GET /malls/1/ after the update: %s", w.Body)
+	}
+	doCached(t, "/malls/?city=1", "MISS")
+	doCached(t, "/search/?shops=1&shops=2", "MISS")
+
+	// the links of a shop change the mall details and the search
+	doCached(t, "/malls/2/", "MISS")
+	doCached(t, "/malls/2/", "HIT")
+	w = doAdmin(t, http.MethodPut, "/shops/2/malls/2/", "")
+	checkStatus(t, w, http.MethodPut, "/shops/2/malls/2/", http.StatusOK)
+	doCached(t, "/malls/2/", "MISS")
+	doCached(t, "/search/?shops=1&shops=2", "MISS")
+}
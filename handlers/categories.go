@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"mallfin_api/db"
 	"mallfin_api/models"
 	"mallfin_api/serializers"
 
@@ -33,7 +32,7 @@ func CategoriesList(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 			return
 		}
 		var err error
-		categories, err = db.GetCategoriesByShop(shopID, sorting)
+		categories, err = stores.Categories.GetCategoriesByShop(ctx, shopID, sorting)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
@@ -41,7 +40,7 @@ func CategoriesList(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 		}
 	} else {
 		var err error
-		categories, err = db.GetCategories(sorting)
+		categories, err = stores.Categories.GetCategories(ctx, sorting)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
@@ -70,7 +69,7 @@ func CategoryDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 	if !checkCity(ctx, w, cityID) {
 		return
 	}
-	category, err := db.GetCategoryDetails(categoryID)
+	category, err := stores.Categories.GetCategoryDetails(ctx, categoryID)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
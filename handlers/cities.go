@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"mallfin_api/db"
 	"mallfin_api/models"
 	"mallfin_api/serializers"
 
@@ -27,7 +26,7 @@ func CitiesList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	if formData.Query != nil {
 		name := *formData.Query
 		var err error
-		cities, err = db.GetCitiesByName(name, sorting)
+		cities, err = stores.Cities.GetCitiesByName(ctx, name, sorting)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
@@ -35,7 +34,7 @@ func CitiesList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		}
 	} else {
 		var err error
-		cities, err = db.GetCities(sorting)
+		cities, err = stores.Cities.GetCities(ctx, sorting)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
@@ -59,7 +58,7 @@ func CurrentCity(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		Lat: formData.LocationLat,
 		Lon: formData.LocationLon,
 	}
-	city, err := db.GetCityByLocation(userLocation)
+	city, err := stores.Cities.GetCityByLocation(ctx, userLocation)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"mallfin_api/db"
+	"mallfin_api/serializers"
+
+	"github.com/gazoon/httprouter"
+)
+
+func SlowQueries(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	response(ctx, w, serializers.SerializeSlowQueries(db.SlowQueries()))
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"mallfin_api/logging"
+	"mallfin_api/models"
+	"mallfin_api/serializers"
+
+	"github.com/gazoon/binding"
+	"github.com/gazoon/httprouter"
+)
+
+func MallFloors(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	mallID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkMall(ctx, w, mallID) {
+		return
+	}
+	plans, err := stores.FloorPlans.GetMallFloors(ctx, mallID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	geoJSONResponse(ctx, w, serializers.SerializeMallFloors(plans))
+}
+
+func FloorPlan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	mallID, floor, ok := floorParams(ctx, w, ps)
+	if !ok {
+		return
+	}
+	plan, err := stores.FloorPlans.GetFloorPlan(ctx, mallID, floor)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if plan == nil {
+		notFoundResponse(ctx, w, FLOOR_NOT_FOUND)
+		return
+	}
+	var shopIDs []int
+	for _, unit := range plan.Units {
+		if unit.ShopID != nil {
+			shopIDs = append(shopIDs, *unit.ShopID)
+		}
+	}
+	shops, err := unitShops(ctx, shopIDs)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	geoJSONResponse(ctx, w, serializers.SerializeFloorPlan(plan, shops))
+}
+
+func FloorUnitAt(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := indoorPointForm{}
+	errs := binding.Form(r, &formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	mallID, floor, ok := floorParams(ctx, w, ps)
+	if !ok {
+		return
+	}
+	point := models.IndoorPoint{X: formData.X, Y: formData.Y}
+	unit, err := stores.FloorPlans.GetFloorUnitAt(ctx, mallID, floor, point)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if unit == nil {
+		notFoundResponse(ctx, w, UNIT_NOT_FOUND)
+		return
+	}
+	var shop *models.Shop
+	if unit.ShopID != nil {
+		shops, err := unitShops(ctx, []int{*unit.ShopID})
+		if err != nil {
+			logger.Error(err)
+			internalErrorResponse(w)
+			return
+		}
+		shop = shops[*unit.ShopID]
+	}
+	geoJSONResponse(ctx, w, serializers.FloorUnitFeature(unit, floor, shop))
+}
+
+func floorParams(ctx context.Context, w http.ResponseWriter, ps httprouter.Params) (int, int, bool) {
+	mallID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return 0, 0, false
+	}
+	floor, err := ps.ByNameInt("floor")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return mallID, floor, true
+}
+
+func unitShops(ctx context.Context, shopIDs []int) (map[int]*models.Shop, error) {
+	shops, err := stores.Shops.GetShopsByIDs(ctx, shopIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.Shop, len(shops))
+	for _, shop := range shops {
+		byID[shop.ID] = shop
+	}
+	return byID, nil
+}
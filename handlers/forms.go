@@ -1,10 +1,35 @@
 package handlers
 
 import (
+	"fmt"
 	"mallfin_api/models"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gazoon/binding"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultScheduleDays = 7
+	maxScheduleDays     = models.MaxScheduleDays
+
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+
+	// in meters
+	defaultMaxDetour = 1000
+	maxMaxDetour     = 20000
+	maxRoutePoints   = 500
+
+	maxPlanShops = 50
+
+	// in meters
+	defaultStationsRadius = 2000
+	maxStationsRadius     = 20000
 )
 
 type checkSortKeyFn func(string) (models.Sorting, error)
@@ -37,24 +62,291 @@ func checkLimitOffset(limit, offset *int, errs binding.Errors) binding.Errors {
 	return errs
 }
 
+func sortingOrDefault(sorting, defaultSorting models.Sorting) models.Sorting {
+	if sorting == nil {
+		return defaultSorting
+	}
+	return sorting
+}
+
+// checkRelevanceSort makes relevance the default order of name queries, other lists cannot be sorted by it.
+func checkRelevanceSort(sorting models.Sorting, byName bool, errs binding.Errors) (models.Sorting, binding.Errors) {
+	if byName {
+		return sortingOrDefault(sorting, models.DefaultRelevanceSorting), errs
+	}
+	if sorting != nil && sorting.Key() == models.RelevanceSortKey {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"sort"},
+			Message:    "relevance sort is available only for query",
+		})
+	}
+	return sorting, errs
+}
+
+func checkCoordinates(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// checkLocationParams returns nil if neither location_lat nor location_lon is passed.
+func checkLocationParams(lat, lon *float64, errs binding.Errors) (*models.Location, binding.Errors) {
+	if (lat == nil) != (lon == nil) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"location_lat", "location_lon"},
+			Message:    "location_lat and location_lon go together",
+		})
+		return nil, errs
+	}
+	if lat == nil {
+		return nil, errs
+	}
+	if !checkCoordinates(*lat, *lon) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"location_lat", "location_lon"},
+			Message:    "location_lat and location_lon are out of range",
+		})
+		return nil, errs
+	}
+	return &models.Location{Lat: *lat, Lon: *lon}, errs
+}
+
+func parseBBox(rawBBox string) (*models.BoundingBox, error) {
+	parts := strings.Split(rawBBox, ",")
+	if len(parts) != 4 {
+		return nil, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		values[i] = value
+	}
+	bbox := &models.BoundingBox{MinLon: values[0], MinLat: values[1], MaxLon: values[2], MaxLat: values[3]}
+	if !checkCoordinates(bbox.MinLat, bbox.MinLon) || !checkCoordinates(bbox.MaxLat, bbox.MaxLon) {
+		return nil, errors.New("bbox coordinates are out of range")
+	}
+	if bbox.MinLon > bbox.MaxLon || bbox.MinLat > bbox.MaxLat {
+		return nil, errors.New("bbox min values must not exceed max values")
+	}
+	return bbox, nil
+}
+
+// checkGeoFilter returns nil if no geo params are passed.
+func checkGeoFilter(nearLat, nearLon, radius *float64, rawBBox *string, errs binding.Errors) (*models.GeoFilter, binding.Errors) {
+	geo := &models.GeoFilter{}
+	if (nearLat == nil) != (nearLon == nil) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"near_lat", "near_lon"},
+			Message:    "near_lat and near_lon go together",
+		})
+		return nil, errs
+	}
+	if nearLat != nil {
+		if !checkCoordinates(*nearLat, *nearLon) {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"near_lat", "near_lon"},
+				Message:    "near_lat and near_lon are out of range",
+			})
+			return nil, errs
+		}
+		geo.Near = &models.Location{Lat: *nearLat, Lon: *nearLon}
+	}
+	if radius != nil {
+		// NaN and Inf parse as floats, but cannot go to the query
+		if geo.Near == nil || math.IsNaN(*radius) || math.IsInf(*radius, 0) || *radius <= 0 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"radius_m"},
+				Message:    "radius_m must be a positive finite number and requires near_lat and near_lon",
+			})
+			return nil, errs
+		}
+		geo.Radius = radius
+	}
+	if rawBBox != nil {
+		bbox, err := parseBBox(*rawBBox)
+		if err != nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"bbox"},
+				Message:    err.Error(),
+			})
+			return nil, errs
+		}
+		geo.BBox = bbox
+	}
+	if geo.Near == nil && geo.BBox == nil {
+		return nil, errs
+	}
+	return geo, errs
+}
+
+// checkRoute returns nil if neither route nor from and to are passed.
+func checkRoute(rawRoute *string, fromLat, fromLon, toLat, toLon, maxDetour *float64,
+	errs binding.Errors) (*models.RouteFilter, binding.Errors) {
+
+	endpoints := []*float64{fromLat, fromLon, toLat, toLon}
+	endpointsCount := 0
+	for _, endpoint := range endpoints {
+		if endpoint != nil {
+			endpointsCount++
+		}
+	}
+	if endpointsCount != 0 && endpointsCount != len(endpoints) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"from_lat", "from_lon", "to_lat", "to_lon"},
+			Message:    "from_lat, from_lon, to_lat and to_lon go together",
+		})
+		return nil, errs
+	}
+	if rawRoute == nil && endpointsCount == 0 {
+		if maxDetour != nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"max_detour_m"},
+				Message:    "max_detour_m requires route or from and to",
+			})
+		}
+		return nil, errs
+	}
+	if rawRoute != nil && endpointsCount != 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"route", "from_lat", "from_lon", "to_lat", "to_lon"},
+			Message:    "route cannot be used together with from and to",
+		})
+		return nil, errs
+	}
+	route := &models.RouteFilter{MaxDetour: defaultMaxDetour}
+	if maxDetour != nil {
+		// written so that NaN fails it too
+		if !(*maxDetour > 0 && *maxDetour <= maxMaxDetour) {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"max_detour_m"},
+				Message:    fmt.Sprintf("max_detour_m must be positive and not greater than %d", maxMaxDetour),
+			})
+			return nil, errs
+		}
+		route.MaxDetour = *maxDetour
+	}
+	if rawRoute != nil {
+		path, err := models.DecodePolyline(*rawRoute)
+		if err != nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"route"},
+				Message:    err.Error(),
+			})
+			return nil, errs
+		}
+		route.Path = path
+	} else {
+		route.Path = []models.Location{{Lat: *fromLat, Lon: *fromLon}, {Lat: *toLat, Lon: *toLon}}
+	}
+	if len(route.Path) < 2 || len(route.Path) > maxRoutePoints {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"route"},
+			Message:    fmt.Sprintf("route must have from 2 to %d points", maxRoutePoints),
+		})
+		return nil, errs
+	}
+	for _, point := range route.Path {
+		if !checkCoordinates(point.Lat, point.Lon) {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"route", "from_lat", "from_lon", "to_lat", "to_lon"},
+				Message:    "route coordinates are out of range",
+			})
+			return nil, errs
+		}
+	}
+	return route, errs
+}
+
+type sortValuesFn func(models.Sorting) []interface{}
+
+func checkCursor(rawCursor *string, offset *int, sorting models.Sorting, sortValues sortValuesFn,
+	errs binding.Errors) (*models.Cursor, binding.Errors) {
+
+	if rawCursor == nil {
+		return nil, errs
+	}
+	if offset != nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"cursor", "offset"},
+			Message:    "cursor cannot be used together with offset",
+		})
+		return nil, errs
+	}
+	cursor, err := models.ParseCursor(*rawCursor, sorting, sortValues(sorting))
+	if err != nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"cursor"},
+			Message:    err.Error(),
+		})
+		return nil, errs
+	}
+	return cursor, errs
+}
+
+func checkOpenAt(openNow *bool, rawOpenAt *string, errs binding.Errors) (*time.Time, binding.Errors) {
+	if rawOpenAt != nil {
+		if openNow != nil && *openNow {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"open_now", "open_at"},
+				Message:    "open_now cannot be used together with open_at",
+			})
+			return nil, errs
+		}
+		openAt, err := time.Parse(time.RFC3339, *rawOpenAt)
+		if err != nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"open_at"},
+				Message:    "open_at must be ISO 8601 datetime with timezone, e.g. 2017-03-08T10:00:00+03:00",
+			})
+			return nil, errs
+		}
+		return &openAt, errs
+	}
+	if openNow != nil && *openNow {
+		now := time.Now()
+		return &now, errs
+	}
+	return nil, errs
+}
+
 type mallsListForm struct {
-	City          *int
-	Shop          *int
-	Query         *string
-	SubwayStation *int
-	Sort          models.Sorting
-	Limit         *int
-	Offset        *int
+	City           *int
+	Shop           *int
+	Query          *string
+	SubwayStation  *int
+	MaxWalkMinutes *int
+	Sort           models.Sorting
+	Limit          *int
+	Offset         *int
+	RawCursor      *string
+	Cursor         *models.Cursor
+	OpenNow        *bool
+	RawOpenAt      *string
+	OpenAt         *time.Time
+	NearLat        *float64
+	NearLon        *float64
+	Radius         *float64
+	RawBBox        *string
+	Geo            *models.GeoFilter
 }
 
 func (mlf *mallsListForm) FieldMap(req *http.Request) binding.FieldMap {
 	return binding.FieldMap{
-		&mlf.City:          "city",
-		&mlf.Shop:          "shop",
-		&mlf.SubwayStation: "subway_station",
-		&mlf.Query:         "query",
-		&mlf.Limit:         "limit",
-		&mlf.Offset:        "offset",
+		&mlf.City:           "city",
+		&mlf.Shop:           "shop",
+		&mlf.SubwayStation:  "subway_station",
+		&mlf.MaxWalkMinutes: "max_walk_minutes",
+		&mlf.Query:          "query",
+		&mlf.Limit:          "limit",
+		&mlf.RawCursor:      "cursor",
+		&mlf.OpenNow:        "open_now",
+		&mlf.RawOpenAt:      "open_at",
+		&mlf.NearLat:        "near_lat",
+		&mlf.NearLon:        "near_lon",
+		&mlf.Radius:         "radius_m",
+		&mlf.RawBBox:        "bbox",
+		&mlf.Offset:         "offset",
 		&mlf.Sort: binding.Field{
 			Form: "sort",
 			Binder: func(fieldName string, formVals []string, errs binding.Errors) binding.Errors {
@@ -68,27 +360,135 @@ func (mlf *mallsListForm) FieldMap(req *http.Request) binding.FieldMap {
 
 func (mlf *mallsListForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
 	errs = checkLimitOffset(mlf.Limit, mlf.Offset, errs)
+	mlf.Sort, errs = checkRelevanceSort(mlf.Sort, mlf.Query != nil && mlf.SubwayStation == nil, errs)
+	mlf.Geo, errs = checkGeoFilter(mlf.NearLat, mlf.NearLon, mlf.Radius, mlf.RawBBox, errs)
+	if mlf.Sort != nil && mlf.Sort.Key() == models.DistanceSortKey && mlf.Geo.Origin() == nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"sort"},
+			Message:    "cannot sort by distance without near_lat, near_lon or bbox",
+		})
+		return errs
+	}
+	sorting := sortingOrDefault(mlf.Sort, models.DefaultMallSorting)
+	mlf.Cursor, errs = checkCursor(mlf.RawCursor, mlf.Offset, sorting, (&models.Mall{}).SortValues, errs)
+	mlf.OpenAt, errs = checkOpenAt(mlf.OpenNow, mlf.RawOpenAt, errs)
+	errs = checkMaxWalkMinutes(mlf.MaxWalkMinutes, mlf.SubwayStation, errs)
+	return errs
+}
+
+func checkMaxWalkMinutes(maxWalkMinutes, subwayStation *int, errs binding.Errors) binding.Errors {
+	if maxWalkMinutes == nil {
+		return errs
+	}
+	if subwayStation == nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"max_walk_minutes"},
+			Message:    "max_walk_minutes filters malls only together with subway_station",
+		})
+	} else if *maxWalkMinutes < 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"max_walk_minutes"},
+			Message:    "max_walk_minutes must not be negative",
+		})
+	}
+	return errs
+}
+
+type mallClustersForm struct {
+	City           *int
+	Shop           *int
+	Query          *string
+	SubwayStation  *int
+	MaxWalkMinutes *int
+	OpenNow        *bool
+	RawOpenAt      *string
+	OpenAt         *time.Time
+	RawBBox        string
+	Zoom           int
+	Geo            *models.GeoFilter
+}
+
+func (mcf *mallClustersForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&mcf.City:           "city",
+		&mcf.Shop:           "shop",
+		&mcf.SubwayStation:  "subway_station",
+		&mcf.MaxWalkMinutes: "max_walk_minutes",
+		&mcf.Query:          "query",
+		&mcf.OpenNow:        "open_now",
+		&mcf.RawOpenAt:      "open_at",
+		&mcf.RawBBox: binding.Field{
+			Form:     "bbox",
+			Required: true,
+		},
+		&mcf.Zoom: binding.Field{
+			Form:     "zoom",
+			Required: true,
+		},
+	}
+}
+
+func (mcf *mallClustersForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if mcf.Zoom < 0 || mcf.Zoom > models.MaxClusterZoom {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"zoom"},
+			Message:    fmt.Sprintf("zoom must be between 0 and %d", models.MaxClusterZoom),
+		})
+	}
+	mcf.Geo, errs = checkGeoFilter(nil, nil, nil, &mcf.RawBBox, errs)
+	mcf.OpenAt, errs = checkOpenAt(mcf.OpenNow, mcf.RawOpenAt, errs)
+	errs = checkMaxWalkMinutes(mcf.MaxWalkMinutes, mcf.SubwayStation, errs)
 	return errs
 }
 
+// MallFilter applies the filters in the same precedence as the malls list:
+// subway_station ignores city, query ignores shop.
+func (mcf *mallClustersForm) MallFilter() *models.MallFilter {
+	filter := &models.MallFilter{OpenAt: mcf.OpenAt, Geo: mcf.Geo}
+	if mcf.SubwayStation != nil {
+		filter.SubwayStation, filter.MaxWalkMinutes = mcf.SubwayStation, mcf.MaxWalkMinutes
+		return filter
+	}
+	filter.City = mcf.City
+	if mcf.Query != nil {
+		filter.Query = mcf.Query
+	} else {
+		filter.Shop = mcf.Shop
+	}
+	return filter
+}
+
 type shopsListForm struct {
-	City     *int
-	Mall     *int
-	Query    *string
-	Category *int
-	Sort     models.Sorting
-	Limit    *int
-	Offset   *int
+	City        *int
+	Mall        *int
+	Query       *string
+	Category    *int
+	Sort        models.Sorting
+	Limit       *int
+	Offset      *int
+	RawCursor   *string
+	Cursor      *models.Cursor
+	OpenNow     *bool
+	RawOpenAt   *string
+	OpenAt      *time.Time
+	LocationLat *float64
+	LocationLon *float64
+	Location    *models.Location
 }
 
 func (slf *shopsListForm) FieldMap(req *http.Request) binding.FieldMap {
 	return binding.FieldMap{
-		&slf.City:     "city",
-		&slf.Mall:     "mall",
-		&slf.Category: "category",
-		&slf.Query:    "query",
-		&slf.Limit:    "limit",
-		&slf.Offset:   "offset",
+		&slf.City:        "city",
+		&slf.Mall:        "mall",
+		&slf.Category:    "category",
+		&slf.Query:       "query",
+		&slf.Limit:       "limit",
+		&slf.RawCursor:   "cursor",
+		&slf.OpenNow:     "open_now",
+		&slf.RawOpenAt:   "open_at",
+		&slf.LocationLat: "location_lat",
+		&slf.LocationLon: "location_lon",
+		&slf.Offset:      "offset",
 		&slf.Sort: binding.Field{
 			Form: "sort",
 			Binder: func(fieldName string, formVals []string, errs binding.Errors) binding.Errors {
@@ -102,13 +502,63 @@ func (slf *shopsListForm) FieldMap(req *http.Request) binding.FieldMap {
 
 func (slf *shopsListForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
 	errs = checkLimitOffset(slf.Limit, slf.Offset, errs)
+	slf.Sort, errs = checkRelevanceSort(slf.Sort, slf.Query != nil && slf.Mall == nil, errs)
+	errsCount := len(errs)
+	slf.Location, errs = checkLocationParams(slf.LocationLat, slf.LocationLon, errs)
+	if len(errs) > errsCount {
+		return errs
+	}
+	if slf.Sort != nil && slf.Sort.Key() == models.NearestSortKey && slf.Location == nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"sort"},
+			Message:    "cannot sort by nearest without location",
+		})
+		return errs
+	}
+	sorting := sortingOrDefault(slf.Sort, models.DefaultShopSorting)
+	slf.Cursor, errs = checkCursor(slf.RawCursor, slf.Offset, sorting, (&models.Shop{}).SortValues, errs)
+	slf.OpenAt, errs = checkOpenAt(slf.OpenNow, slf.RawOpenAt, errs)
+	if slf.OpenAt != nil && slf.Mall == nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"open_now", "open_at"},
+			Message:    "open_now and open_at filter shops only together with mall",
+		})
+	}
+	return errs
+}
+
+type mallDetailsForm struct {
+	Days *int
+}
+
+func (mdf *mallDetailsForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&mdf.Days: "days",
+	}
+}
+
+func (mdf *mallDetailsForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if mdf.Days != nil && (*mdf.Days < 1 || *mdf.Days > maxScheduleDays) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"days"},
+			Message:    fmt.Sprintf("days must be between 1 and %d", maxScheduleDays),
+		})
+	}
 	return errs
 }
 
+func (mdf *mallDetailsForm) ScheduleDays() int {
+	if mdf.Days == nil {
+		return defaultScheduleDays
+	}
+	return *mdf.Days
+}
+
 type shopDetailsForm struct {
 	City        *int
 	LocationLat *float64
 	LocationLon *float64
+	Location    *models.Location
 }
 
 func (sdf *shopDetailsForm) FieldMap(req *http.Request) binding.FieldMap {
@@ -119,6 +569,11 @@ func (sdf *shopDetailsForm) FieldMap(req *http.Request) binding.FieldMap {
 	}
 }
 
+func (sdf *shopDetailsForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	sdf.Location, errs = checkLocationParams(sdf.LocationLat, sdf.LocationLon, errs)
+	return errs
+}
+
 type categoriesListForm struct {
 	City *int
 	Shop *int
@@ -169,6 +624,115 @@ func (clf *citiesListForm) FieldMap(req *http.Request) binding.FieldMap {
 	}
 }
 
+type subwayStationsListForm struct {
+	City        *int
+	Query       *string
+	LocationLat *float64
+	LocationLon *float64
+	Location    *models.Location
+	Radius      *float64
+	Sort        models.Sorting
+}
+
+func (sslf *subwayStationsListForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&sslf.City:        "city",
+		&sslf.Query:       "query",
+		&sslf.LocationLat: "location_lat",
+		&sslf.LocationLon: "location_lon",
+		&sslf.Radius:      "radius_m",
+		&sslf.Sort: binding.Field{
+			Form: "sort",
+			Binder: func(fieldName string, formVals []string, errs binding.Errors) binding.Errors {
+				sorting := bindSortKey(models.SubwayStationSorting, fieldName, formVals, &errs)
+				sslf.Sort = sorting
+				return errs
+			},
+		},
+	}
+}
+
+func (sslf *subwayStationsListForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	errsCount := len(errs)
+	sslf.Location, errs = checkLocationParams(sslf.LocationLat, sslf.LocationLon, errs)
+	if len(errs) > errsCount {
+		return errs
+	}
+	if sslf.Location != nil && sslf.Query != nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"query", "location_lat", "location_lon"},
+			Message:    "query and location cannot be used together",
+		})
+		return errs
+	}
+	if sslf.Radius != nil {
+		if sslf.Location == nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"radius_m"},
+				Message:    "radius_m requires location",
+			})
+		} else if !(*sslf.Radius > 0 && *sslf.Radius <= maxStationsRadius) {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"radius_m"},
+				Message:    fmt.Sprintf("radius_m must be positive and at most %d", maxStationsRadius),
+			})
+		}
+	} else {
+		radius := float64(defaultStationsRadius)
+		sslf.Radius = &radius
+	}
+	if sslf.Sort != nil && sslf.Sort.Key() == models.DistanceSortKey && sslf.Location == nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"sort"},
+			Message:    "cannot sort by distance without location",
+		})
+	}
+	if sslf.Sort == nil && sslf.Location != nil {
+		sslf.Sort = models.DefaultDistanceSorting
+	}
+	return errs
+}
+
+type suggestForm struct {
+	Query string
+	City  *int
+	Limit *int
+}
+
+func (sf *suggestForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&sf.Query: binding.Field{
+			Form:     "q",
+			Required: true,
+		},
+		&sf.City:  "city",
+		&sf.Limit: "limit",
+	}
+}
+
+func (sf *suggestForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if strings.TrimSpace(sf.Query) == "" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"q"},
+			Message:    "q must not be blank",
+		})
+	}
+	if sf.Limit != nil && (*sf.Limit < 1 || *sf.Limit > maxSuggestLimit) {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"limit"},
+			Message:    fmt.Sprintf("limit must be between 1 and %d", maxSuggestLimit),
+		})
+	}
+	return errs
+}
+
+func (sf *suggestForm) SuggestLimit() int {
+	if sf.Limit == nil {
+		return defaultSuggestLimit
+	}
+	return *sf.Limit
+}
+
 type CoordinatesForm struct {
 	LocationLat float64
 	LocationLon float64
@@ -187,6 +751,24 @@ func (cmf *CoordinatesForm) FieldMap(req *http.Request) binding.FieldMap {
 	}
 }
 
+type indoorPointForm struct {
+	X float64
+	Y float64
+}
+
+func (ipf *indoorPointForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&ipf.X: binding.Field{
+			Form:     "x",
+			Required: true,
+		},
+		&ipf.Y: binding.Field{
+			Form:     "y",
+			Required: true,
+		},
+	}
+}
+
 type shopsInMallsForm struct {
 	Shops []int
 	Malls []int
@@ -207,6 +789,18 @@ type searchForm struct {
 	Sort        models.Sorting
 	Limit       *int
 	Offset      *int
+	RawCursor   *string
+	Cursor      *models.Cursor
+	OpenNow     *bool
+	RawOpenAt   *string
+	OpenAt      *time.Time
+	RawRoute    *string
+	FromLat     *float64
+	FromLon     *float64
+	ToLat       *float64
+	ToLon       *float64
+	MaxDetour   *float64
+	Route       *models.RouteFilter
 }
 
 func (sf *searchForm) FieldMap(req *http.Request) binding.FieldMap {
@@ -216,7 +810,16 @@ func (sf *searchForm) FieldMap(req *http.Request) binding.FieldMap {
 		&sf.LocationLat: "location_lat",
 		&sf.LocationLon: "location_lon",
 		&sf.Limit:       "limit",
+		&sf.RawCursor:   "cursor",
+		&sf.OpenNow:     "open_now",
+		&sf.RawOpenAt:   "open_at",
 		&sf.Offset:      "offset",
+		&sf.RawRoute:    "route",
+		&sf.FromLat:     "from_lat",
+		&sf.FromLon:     "from_lon",
+		&sf.ToLat:       "to_lat",
+		&sf.ToLon:       "to_lon",
+		&sf.MaxDetour:   "max_detour_m",
 		&sf.Sort: binding.Field{
 			Form: "sort",
 			Binder: func(fieldName string, formVals []string, errs binding.Errors) binding.Errors {
@@ -229,6 +832,17 @@ func (sf *searchForm) FieldMap(req *http.Request) binding.FieldMap {
 }
 
 func (sf *searchForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	sf.Route, errs = checkRoute(sf.RawRoute, sf.FromLat, sf.FromLon, sf.ToLat, sf.ToLon, sf.MaxDetour, errs)
+	if sf.Route != nil {
+		if sf.LocationLat != nil || sf.LocationLon != nil {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"location_lat", "location_lon"},
+				Message:    "location cannot be used together with route",
+			})
+			return errs
+		}
+		sf.Sort = sortingOrDefault(sf.Sort, models.DefaultRouteSearchSorting)
+	}
 	sorting := sf.Sort
 	if sorting != nil && sorting.Key() == models.DistanceSortKey && (sf.LocationLon == nil || sf.LocationLat == nil) {
 		errs = append(errs, binding.Error{
@@ -237,6 +851,401 @@ func (sf *searchForm) Validate(req *http.Request, errs binding.Errors) binding.E
 		})
 		return errs
 	}
+	if sorting != nil && sorting.Key() == models.RouteDistanceSortKey && sf.Route == nil {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"sort"},
+			Message:    "cannot sort by route distance without route or from and to",
+		})
+		return errs
+	}
 	errs = checkLimitOffset(sf.Limit, sf.Offset, errs)
+	searchResultSample := &models.SearchResult{Mall: &models.Mall{}}
+	sf.Cursor, errs = checkCursor(sf.RawCursor, sf.Offset, sortingOrDefault(sorting, models.DefaultSearchSorting),
+		searchResultSample.SortValues, errs)
+	sf.OpenAt, errs = checkOpenAt(sf.OpenNow, sf.RawOpenAt, errs)
+	return errs
+}
+
+type planForm struct {
+	Shops       []int
+	City        *int
+	LocationLat *float64
+	LocationLon *float64
+	OpenNow     *bool
+	RawOpenAt   *string
+	OpenAt      *time.Time
+	Location    *models.Location
+}
+
+func (pf *planForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&pf.Shops: binding.Field{
+			Form:     "shops",
+			Required: true,
+		},
+		&pf.City:        "city",
+		&pf.LocationLat: "location_lat",
+		&pf.LocationLon: "location_lon",
+		&pf.OpenNow:     "open_now",
+		&pf.RawOpenAt:   "open_at",
+	}
+}
+
+func (pf *planForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if len(pf.Shops) == 0 || len(pf.Shops) > maxPlanShops {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"shops"},
+			Message:    fmt.Sprintf("shops must have from 1 to %d ids", maxPlanShops),
+		})
+	}
+	pf.Location, errs = checkLocationParams(pf.LocationLat, pf.LocationLon, errs)
+	pf.OpenAt, errs = checkOpenAt(pf.OpenNow, pf.RawOpenAt, errs)
+	return errs
+}
+
+type logoForm struct {
+	Small string `json:"small"`
+	Large string `json:"large"`
+}
+
+type subwayStationWalkForm struct {
+	ID           int `json:"id"`
+	WalkDistance int `json:"walk_distance"`
+	WalkMinutes  int `json:"walk_minutes"`
+}
+
+type locationForm struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func checkLocation(location *locationForm, fieldName string, errs binding.Errors) binding.Errors {
+	if location == nil {
+		return errs
+	}
+	if location.Lat < -90 || location.Lat > 90 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{fieldName},
+			Message:    "lat must be between -90 and 90",
+		})
+	}
+	if location.Lon < -180 || location.Lon > 180 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{fieldName},
+			Message:    "lon must be between -180 and 180",
+		})
+	}
+	return errs
+}
+
+func checkRequired(fieldName string, isSet bool, errs binding.Errors) binding.Errors {
+	if !isSet {
+		errs = append(errs, binding.Error{
+			FieldNames:     []string{fieldName},
+			Classification: binding.RequiredError,
+			Message:        "Required",
+		})
+	}
+	return errs
+}
+
+func checkNotBlank(fieldName string, value *string, errs binding.Errors) binding.Errors {
+	if value != nil && strings.TrimSpace(*value) == "" {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{fieldName},
+			Message:    fieldName + " must not be blank",
+		})
+	}
+	return errs
+}
+
+func checkAliases(aliases []string, errs binding.Errors) binding.Errors {
+	for _, alias := range aliases {
+		if strings.TrimSpace(alias) == "" {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"aliases"},
+				Message:    "aliases must not contain blank names",
+			})
+			break
+		}
+	}
+	return errs
+}
+
+type mallForm struct {
+	partial        bool
+	Name           *string                  `json:"name"`
+	Aliases        []string                 `json:"aliases"`
+	Phone          *string                  `json:"phone"`
+	Logo           *logoForm                `json:"logo"`
+	Location       *locationForm            `json:"location"`
+	Radius         *float64                 `json:"radius"`
+	Address        *string                  `json:"address"`
+	Site           *string                  `json:"site"`
+	SubwayStations []*subwayStationWalkForm `json:"subway_stations"`
+	DayAndNight    *bool                    `json:"day_and_night"`
+	City           *int                     `json:"city"`
+}
+
+func (mf *mallForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&mf.Name:           "name",
+		&mf.Aliases:        "aliases",
+		&mf.Phone:          "phone",
+		&mf.Logo:           "logo",
+		&mf.Location:       "location",
+		&mf.Radius:         "radius",
+		&mf.Address:        "address",
+		&mf.Site:           "site",
+		&mf.SubwayStations: "subway_stations",
+		&mf.DayAndNight:    "day_and_night",
+		&mf.City:           "city",
+	}
+}
+
+func (mf *mallForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if !mf.partial {
+		errs = checkRequired("name", mf.Name != nil, errs)
+		errs = checkRequired("location", mf.Location != nil, errs)
+		errs = checkRequired("radius", mf.Radius != nil, errs)
+		errs = checkRequired("city", mf.City != nil, errs)
+	}
+	errs = checkNotBlank("name", mf.Name, errs)
+	errs = checkAliases(mf.Aliases, errs)
+	errs = checkLocation(mf.Location, "location", errs)
+	if mf.Radius != nil && *mf.Radius <= 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"radius"},
+			Message:    "radius must be positive",
+		})
+	}
+	stationIDs := map[int]bool{}
+	for _, station := range mf.SubwayStations {
+		if station == nil || station.ID <= 0 || station.WalkDistance < 0 || station.WalkMinutes < 0 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"subway_stations"},
+				Message:    "subway_stations must have positive id and not negative walk_distance and walk_minutes",
+			})
+			break
+		}
+		if stationIDs[station.ID] {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"subway_stations"},
+				Message:    fmt.Sprintf("subway station %d is repeated", station.ID),
+			})
+			break
+		}
+		stationIDs[station.ID] = true
+	}
+	return errs
+}
+
+func (mf *mallForm) toChanges() *models.MallChanges {
+	changes := &models.MallChanges{
+		Name:        mf.Name,
+		Aliases:     mf.Aliases,
+		Phone:       mf.Phone,
+		Radius:      mf.Radius,
+		Address:     mf.Address,
+		Site:        mf.Site,
+		DayAndNight: mf.DayAndNight,
+		CityID:      mf.City,
+	}
+	if mf.Logo != nil {
+		changes.Logo = &models.Logo{Small: mf.Logo.Small, Large: mf.Logo.Large}
+	}
+	if mf.Location != nil {
+		changes.Location = &models.Location{Lat: mf.Location.Lat, Lon: mf.Location.Lon}
+	}
+	if mf.SubwayStations != nil {
+		changes.SubwayStations = make([]*models.SubwayStationWalk, len(mf.SubwayStations))
+		for i, station := range mf.SubwayStations {
+			changes.SubwayStations[i] = &models.SubwayStationWalk{
+				StationID:    station.ID,
+				WalkDistance: station.WalkDistance,
+				WalkMinutes:  station.WalkMinutes,
+			}
+		}
+	}
+	if !mf.partial {
+		fillMallDefaults(changes)
+	}
+	return changes
+}
+
+func fillMallDefaults(changes *models.MallChanges) {
+	empty := ""
+	if changes.Aliases == nil {
+		changes.Aliases = []string{}
+	}
+	if changes.Phone == nil {
+		changes.Phone = &empty
+	}
+	if changes.Logo == nil {
+		changes.Logo = &models.Logo{}
+	}
+	if changes.Address == nil {
+		changes.Address = &empty
+	}
+	if changes.Site == nil {
+		changes.Site = &empty
+	}
+	if changes.SubwayStations == nil {
+		changes.SubwayStations = []*models.SubwayStationWalk{}
+	}
+	if changes.DayAndNight == nil {
+		dayAndNight := false
+		changes.DayAndNight = &dayAndNight
+	}
+}
+
+type shopForm struct {
+	partial bool
+	Name    *string   `json:"name"`
+	Aliases []string  `json:"aliases"`
+	Phone   *string   `json:"phone"`
+	Site    *string   `json:"site"`
+	Logo    *logoForm `json:"logo"`
+	Score   *int      `json:"score"`
+}
+
+func (sf *shopForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&sf.Name:    "name",
+		&sf.Aliases: "aliases",
+		&sf.Phone:   "phone",
+		&sf.Site:    "site",
+		&sf.Logo:    "logo",
+		&sf.Score:   "score",
+	}
+}
+
+func (sf *shopForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if !sf.partial {
+		errs = checkRequired("name", sf.Name != nil, errs)
+	}
+	errs = checkNotBlank("name", sf.Name, errs)
+	errs = checkAliases(sf.Aliases, errs)
+	if sf.Score != nil && *sf.Score < 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"score"},
+			Message:    "score must be non-negative int",
+		})
+	}
+	return errs
+}
+
+func (sf *shopForm) toChanges() *models.ShopChanges {
+	changes := &models.ShopChanges{
+		Name:    sf.Name,
+		Aliases: sf.Aliases,
+		Phone:   sf.Phone,
+		Site:    sf.Site,
+		Score:   sf.Score,
+	}
+	if sf.Logo != nil {
+		changes.Logo = &models.Logo{Small: sf.Logo.Small, Large: sf.Logo.Large}
+	}
+	if !sf.partial {
+		fillShopDefaults(changes)
+	}
+	return changes
+}
+
+func fillShopDefaults(changes *models.ShopChanges) {
+	empty := ""
+	if changes.Aliases == nil {
+		changes.Aliases = []string{}
+	}
+	if changes.Phone == nil {
+		changes.Phone = &empty
+	}
+	if changes.Site == nil {
+		changes.Site = &empty
+	}
+	if changes.Logo == nil {
+		changes.Logo = &models.Logo{}
+	}
+	if changes.Score == nil {
+		score := 0
+		changes.Score = &score
+	}
+}
+
+type shopPlacementForm struct {
+	Floor    *int   `json:"floor"`
+	Unit     string `json:"unit"`
+	Entrance string `json:"entrance"`
+	Phone    string `json:"phone"`
+}
+
+func (spf *shopPlacementForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&spf.Floor:    "floor",
+		&spf.Unit:     "unit",
+		&spf.Entrance: "entrance",
+		&spf.Phone:    "phone",
+	}
+}
+
+func (spf *shopPlacementForm) toPlacement() *models.ShopPlacement {
+	placement := &models.ShopPlacement{
+		Floor:    spf.Floor,
+		Unit:     strings.TrimSpace(spf.Unit),
+		Entrance: strings.TrimSpace(spf.Entrance),
+		Phone:    strings.TrimSpace(spf.Phone),
+	}
+	return placement
+}
+
+type shopLinksForm struct {
+	Attach []int `json:"attach"`
+	Detach []int `json:"detach"`
+}
+
+func (slf *shopLinksForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&slf.Attach: "attach",
+		&slf.Detach: "detach",
+	}
+}
+
+func (slf *shopLinksForm) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if len(slf.Attach) == 0 && len(slf.Detach) == 0 {
+		errs = append(errs, binding.Error{
+			FieldNames: []string{"attach", "detach"},
+			Message:    "attach or detach must be specified",
+		})
+		return errs
+	}
+	attach := make(map[int]bool, len(slf.Attach))
+	for _, id := range slf.Attach {
+		attach[id] = true
+	}
+	for id := range attach {
+		if id <= 0 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"attach"},
+				Message:    "attach must contain positive ints",
+			})
+			return errs
+		}
+	}
+	for _, id := range slf.Detach {
+		if id <= 0 {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"detach"},
+				Message:    "detach must contain positive ints",
+			})
+			return errs
+		}
+		if attach[id] {
+			errs = append(errs, binding.Error{
+				FieldNames: []string{"attach", "detach"},
+				Message:    "the same id cannot be attached and detached",
+			})
+			return errs
+		}
+	}
 	return errs
 }
@@ -0,0 +1,1079 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mallfin_api/cache"
+	"mallfin_api/config"
+	"mallfin_api/db"
+	"mallfin_api/health"
+	"mallfin_api/middlewares"
+	"mallfin_api/models"
+
+	"github.com/gazoon/httprouter"
+)
+
+const (
+	moscow = 1
+	spb    = 2
+
+	evropeisky = 1
+	afimall    = 2
+	galeria    = 3
+	atrium     = 4
+
+	zara  = 1
+	hm    = 2
+	apple = 3
+	lego  = 4
+
+	clothes     = 1
+	electronics = 2
+)
+
+const (
+	testAdminToken  = "test-admin-token"
+	testCacheRoutes = `{"/malls/": 60, "/malls/:id/": 60, "/search/": 60}`
+)
+
+var testRouter *httprouter.Router
+
+func init() {
+	initTestConfig()
+	cache.Initialization(cache.NewMemoryStorage())
+	Initialization(newTestStores(newTestStore()))
+	testRouter = httprouter.New()
+	testRouter.GET("/malls/", MallsList)
+	testRouter.GET("/malls/:id/", MallDetails)
+	testRouter.GET("/malls/:id/directory/", MallDirectory)
+	testRouter.GET("/malls/:id/floors/", MallFloors)
+	testRouter.GET("/malls/:id/floors/:floor/", FloorPlan)
+	testRouter.GET("/malls/:id/floors/:floor/unit/", FloorUnitAt)
+	testRouter.POST("/malls/", AdminOnly(CreateMall))
+	testRouter.PUT("/malls/:id/", AdminOnly(ReplaceMall))
+	testRouter.PATCH("/malls/:id/", AdminOnly(UpdateMall))
+	testRouter.DELETE("/malls/:id/", AdminOnly(DeleteMall))
+	testRouter.GET("/shops/", ShopsList)
+	testRouter.GET("/shops/:id/", ShopDetails)
+	testRouter.POST("/shops/", AdminOnly(CreateShop))
+	testRouter.PUT("/shops/:id/", AdminOnly(ReplaceShop))
+	testRouter.PATCH("/shops/:id/", AdminOnly(UpdateShop))
+	testRouter.DELETE("/shops/:id/", AdminOnly(DeleteShop))
+	testRouter.POST("/shops/:id/malls/", AdminOnly(ChangeShopMalls))
+	testRouter.PUT("/shops/:id/malls/:mall_id/", AdminOnly(AttachShopMall))
+	testRouter.DELETE("/shops/:id/malls/:mall_id/", AdminOnly(DetachShopMall))
+	testRouter.PUT("/shops/:id/malls/:mall_id/placement/", AdminOnly(SetShopPlacement))
+	testRouter.POST("/shops/:id/categories/", AdminOnly(ChangeShopCategories))
+	testRouter.PUT("/shops/:id/categories/:category_id/", AdminOnly(AttachShopCategory))
+	testRouter.DELETE("/shops/:id/categories/:category_id/", AdminOnly(DetachShopCategory))
+	testRouter.GET("/categories/:id/", CategoryDetails)
+	testRouter.GET("/search/", Search)
+	testRouter.GET("/plan/", Plan)
+	testRouter.GET("/subway_stations/", SubwayStationsList)
+	testRouter.GET("/suggest/", Suggest)
+	testRouter.GET("/current_mall/", CurrentMall)
+	testRouter.GET("/mall_clusters/", MallClusters)
+	testRouter.GET("/healthz", Healthz)
+	testRouter.GET("/readyz", Readyz)
+}
+
+// initTestConfig sets only the admin token, the handlers under test read nothing else from the config.
+func initTestConfig() {
+	file, err := ioutil.TempFile("", "mallfin_api_config")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(file.Name())
+	_, err = fmt.Fprintf(file, `{"admin_token": %q, "cache": {"enabled": true, "routes": %s}}`, testAdminToken, testCacheRoutes)
+	if err != nil {
+		panic(err)
+	}
+	file.Close()
+	config.Initialization(file.Name())
+}
+
+func newTestStores(store *db.MemoryStore) *Stores {
+	return &Stores{
+		Malls:      store,
+		Shops:      store,
+		Categories: store,
+		Cities:     store,
+		Search:     store,
+		Suggest:    store,
+
+		SubwayStations: store,
+		FloorPlans:     store,
+	}
+}
+
+// newTestStore: Zara is in three malls, H&M in two, Apple Store in two, Lego in none.
+func newTestStore() *db.MemoryStore {
+	store := db.NewMemoryStore()
+	store.AddCity(&models.City{ID: moscow, Name: "Moscow"}, models.Location{Lat: 55.75, Lon: 37.61}, 50000)
+	store.AddCity(&models.City{ID: spb, Name: "Saint Petersburg"}, models.Location{Lat: 59.93, Lon: 30.33}, 50000)
+
+	store.AddMall(&models.Mall{ID: evropeisky, Name: "Evropeisky", Location: models.Location{Lat: 55.744, Lon: 37.566}, DayAndNight: true}, moscow, 300, "Европейский")
+	store.AddMall(&models.Mall{ID: afimall, Name: "Afimall", Location: models.Location{Lat: 55.749, Lon: 37.539}, DayAndNight: true}, moscow, 300)
+	store.AddMall(&models.Mall{ID: galeria, Name: "Galeria", Location: models.Location{Lat: 59.927, Lon: 30.360}, DayAndNight: true}, spb, 300)
+	store.AddMall(&models.Mall{ID: atrium, Name: "Atrium", Location: models.Location{Lat: 55.757, Lon: 37.659}, DayAndNight: true}, moscow, 300)
+
+	store.AddShop(&models.Shop{ID: zara, Name: "Zara", Score: 5})
+	store.AddShop(&models.Shop{ID: hm, Name: "H&M", Score: 4})
+	store.AddShop(&models.Shop{ID: apple, Name: "Apple Store", Score: 9})
+	store.AddShop(&models.Shop{ID: lego, Name: "Lego", Score: 7})
+
+	store.AddCategory(&models.Category{ID: clothes, Name: "Clothes"})
+	store.AddCategory(&models.Category{ID: electronics, Name: "Electronics"})
+
+	for _, link := range [][2]int{
+		{zara, evropeisky}, {zara, afimall}, {zara, galeria},
+		{hm, evropeisky}, {hm, galeria},
+		{apple, evropeisky}, {apple, atrium},
+	} {
+		store.AddShopToMall(link[0], link[1])
+	}
+	store.AddShopToCategory(zara, clothes)
+	store.AddShopToCategory(hm, clothes)
+	store.AddShopToCategory(apple, electronics)
+	return store
+}
+
+type testPage struct {
+	Count      int               `json:"count"`
+	TotalCount int               `json:"total_count"`
+	Next       *string           `json:"next"`
+	Prev       *string           `json:"prev"`
+	Results    []json.RawMessage `json:"results"`
+}
+
+func doGet(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+func getPage(t *testing.T, target string) *testPage {
+	t.Helper()
+	w := doGet(t, target)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	resp := struct {
+		Data *testPage `json:"data"`
+	}{}
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("GET %s: cannot decode %s: %s", target, w.Body, err)
+	}
+	return resp.Data
+}
+
+// pageURI strips the host from a next or prev link, so that it can be requested again.
+func pageURI(t *testing.T, link *string) string {
+	t.Helper()
+	if link == nil {
+		t.Fatal("expected a page link, got nil")
+	}
+	u, err := url.Parse(*link)
+	if err != nil {
+		t.Fatalf("cannot parse page link %s: %s", *link, err)
+	}
+	return u.RequestURI()
+}
+
+func resultIDs(t *testing.T, page *testPage) []int {
+	t.Helper()
+	ids := []int{}
+	for _, raw := range page.Results {
+		result := struct {
+			ID   int `json:"id"`
+			Mall *struct {
+				ID int `json:"id"`
+			} `json:"mall"`
+		}{}
+		err := json.Unmarshal(raw, &result)
+		if err != nil {
+			t.Fatalf("cannot decode result %s: %s", raw, err)
+		}
+		if result.Mall != nil {
+			ids = append(ids, result.Mall.ID)
+		} else {
+			ids = append(ids, result.ID)
+		}
+	}
+	return ids
+}
+
+type listCase struct {
+	target     string
+	ids        []int
+	totalCount int
+}
+
+func checkListCases(t *testing.T, cases []listCase) {
+	t.Helper()
+	for _, c := range cases {
+		page := getPage(t, c.target)
+		ids := resultIDs(t, page)
+		if !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("GET %s: ids %v, expected %v", c.target, ids, c.ids)
+		}
+		if page.TotalCount != c.totalCount {
+			t.Errorf("GET %s: total_count %d, expected %d", c.target, page.TotalCount, c.totalCount)
+		}
+	}
+}
+
+func checkBadRequests(t *testing.T, targets []string) {
+	t.Helper()
+	for _, target := range targets {
+		w := doGet(t, target)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, expected 400, body %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+func checkNotFound(t *testing.T, targets []string) {
+	t.Helper()
+	for _, target := range targets {
+		w := doGet(t, target)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, expected 404, body %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMallsListFilters(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/malls/", []int{evropeisky, afimall, galeria, atrium}, 4},
+		{"/malls/?city=1", []int{evropeisky, afimall, atrium}, 3},
+		{"/malls/?shop=2", []int{evropeisky, galeria}, 2},
+		{"/malls/?shop=1&city=2", []int{galeria}, 1},
+		{"/malls/?shop=4", []int{}, 0},
+		{"/malls/?query=atrium", []int{atrium}, 1},
+	})
+	checkNotFound(t, []string{"/malls/?city=100", "/malls/?shop=100"})
+}
+
+func TestMallsListSorting(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/malls/?sort=name", []int{afimall, atrium, evropeisky, galeria}, 4},
+		{"/malls/?sort=-name", []int{galeria, evropeisky, atrium, afimall}, 4},
+		{"/malls/?sort=-id", []int{atrium, galeria, afimall, evropeisky}, 4},
+		{"/malls/?sort=shops_count", []int{afimall, atrium, galeria, evropeisky}, 4},
+		// ties are broken by id in the sort direction
+		{"/malls/?sort=-shops_count", []int{evropeisky, galeria, atrium, afimall}, 4},
+	})
+	checkBadRequests(t, []string{"/malls/?sort=score", "/malls/?sort=relevance"})
+}
+
+func TestMallsListOffsetPagination(t *testing.T) {
+	page := getPage(t, "/malls/?limit=2&offset=1")
+	if ids := resultIDs(t, page); !reflect.DeepEqual(ids, []int{afimall, galeria}) {
+		t.Errorf("ids %v, expected %v", ids, []int{afimall, galeria})
+	}
+	if page.TotalCount != 4 || page.Count != 2 {
+		t.Errorf("count %d and total_count %d, expected 2 and 4", page.Count, page.TotalCount)
+	}
+	next := getPage(t, pageURI(t, page.Next))
+	if ids := resultIDs(t, next); !reflect.DeepEqual(ids, []int{atrium}) {
+		t.Errorf("next page ids %v, expected %v", ids, []int{atrium})
+	}
+	if next.Next != nil {
+		t.Errorf("unexpected next page after the last one: %s", *next.Next)
+	}
+	prev := getPage(t, pageURI(t, page.Prev))
+	if ids := resultIDs(t, prev); !reflect.DeepEqual(ids, []int{evropeisky}) {
+		t.Errorf("prev page ids %v, expected %v", ids, []int{evropeisky})
+	}
+	checkBadRequests(t, []string{"/malls/?limit=-1", "/malls/?offset=-1"})
+}
+
+func TestMallsListCursorPagination(t *testing.T) {
+	page := getPage(t, "/malls/?limit=3&sort=name")
+	if ids := resultIDs(t, page); !reflect.DeepEqual(ids, []int{afimall, atrium, evropeisky}) {
+		t.Errorf("ids %v, expected %v", ids, []int{afimall, atrium, evropeisky})
+	}
+	next := getPage(t, pageURI(t, page.Next))
+	if ids := resultIDs(t, next); !reflect.DeepEqual(ids, []int{galeria}) {
+		t.Errorf("next page ids %v, expected %v", ids, []int{galeria})
+	}
+	if next.Next != nil {
+		t.Errorf("unexpected next page after the last one: %s", *next.Next)
+	}
+	prev := getPage(t, pageURI(t, next.Prev))
+	if ids := resultIDs(t, prev); !reflect.DeepEqual(ids, []int{afimall, atrium, evropeisky}) {
+		t.Errorf("prev page ids %v, expected %v", ids, []int{afimall, atrium, evropeisky})
+	}
+	checkBadRequests(t, []string{
+		"/malls/?cursor=garbage",
+		// the cursor was issued for another sort
+		"/malls/?limit=3&sort=-name&cursor=" + url.QueryEscape(models.NewCursor(models.DefaultMallSorting, []interface{}{1}, false).String()),
+		"/malls/?limit=3&offset=1&cursor=" + url.QueryEscape(models.NewCursor(models.DefaultMallSorting, []interface{}{1}, false).String()),
+	})
+}
+
+func TestNameQuery(t *testing.T) {
+	checkListCases(t, []listCase{
+		// a typo
+		{"/malls/?query=Galerea", []int{galeria}, 1},
+		// the cyrillic spelling of the name
+		{"/malls/?query=" + url.QueryEscape("Атриум"), []int{atrium}, 1},
+		{"/shops/?query=" + url.QueryEscape("Зара"), []int{zara}, 1},
+		// an alias
+		{"/malls/?query=" + url.QueryEscape("Европейский"), []int{evropeisky}, 1},
+		{"/malls/?query=xyz", []int{}, 0},
+		// the most relevant go first by default
+		{"/malls/?query=al", []int{afimall, galeria}, 2},
+		{"/malls/?query=al&sort=-id", []int{galeria, afimall}, 2},
+		{"/malls/?query=al&city=2", []int{galeria}, 1},
+	})
+}
+
+func TestMallsListGeoFilters(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/malls/?near_lat=55.744&near_lon=37.566&radius_m=2000", []int{evropeisky, afimall}, 2},
+		{"/malls/?near_lat=55.744&near_lon=37.566&radius_m=2000&sort=-distance", []int{afimall, evropeisky}, 2},
+		{"/malls/?near_lat=55.744&near_lon=37.566&sort=distance&limit=2", []int{evropeisky, afimall}, 4},
+		{"/malls/?bbox=37.5,55.7,37.6,55.8", []int{evropeisky, afimall}, 2},
+		{"/malls/?bbox=30,55,38,60&city=2", []int{galeria}, 1},
+		{"/malls/?near_lat=55.744&near_lon=37.566&radius_m=20000&shop=3", []int{evropeisky, atrium}, 2},
+	})
+	checkBadRequests(t, []string{
+		"/malls/?radius_m=1000",
+		"/malls/?near_lat=55.744&radius_m=1000",
+		"/malls/?near_lat=55.744&near_lon=37.566&radius_m=0",
+		"/malls/?near_lat=55.744&near_lon=37.566&radius_m=-1",
+		"/malls/?near_lat=55.744&near_lon=37.566&radius_m=NaN",
+		"/malls/?near_lat=55.744&near_lon=37.566&radius_m=Inf",
+		"/malls/?near_lat=55.744&near_lon=37.566&radius_m=-Inf",
+		"/malls/?near_lat=NaN&near_lon=37.566",
+		"/malls/?near_lat=91&near_lon=37.566",
+		"/malls/?bbox=37.5,55.7,37.6",
+		"/malls/?bbox=37.5,NaN,37.6,55.8",
+		"/malls/?bbox=37.6,55.7,37.5,55.8",
+		"/malls/?sort=distance",
+	})
+}
+
+func TestShopsListFilters(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/shops/", []int{zara, hm, apple, lego}, 4},
+		{"/shops/?city=2", []int{zara, hm}, 2},
+		{"/shops/?mall=1", []int{zara, hm, apple}, 3},
+		{"/shops/?category=1", []int{zara, hm}, 2},
+		{"/shops/?category=2&city=2", []int{}, 0},
+		{"/shops/?query=zara", []int{zara}, 1},
+	})
+	checkNotFound(t, []string{"/shops/?city=100", "/shops/?mall=100", "/shops/?category=100"})
+}
+
+func TestShopsListSorting(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/shops/?sort=name", []int{apple, hm, lego, zara}, 4},
+		{"/shops/?sort=-score", []int{apple, lego, zara, hm}, 4},
+		{"/shops/?sort=-malls_count", []int{zara, apple, hm, lego}, 4},
+		{"/shops/?mall=1&sort=-id", []int{apple, hm, zara}, 3},
+	})
+	checkBadRequests(t, []string{"/shops/?sort=shops_count", "/shops/?sort=relevance"})
+}
+
+func TestShopsListNearest(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/shops/?location_lat=59.927&location_lon=30.36&sort=nearest", []int{zara, hm, apple, lego}, 4},
+		{"/shops/?location_lat=55.757&location_lon=37.659&sort=nearest", []int{apple, zara, hm, lego}, 4},
+	})
+	page := getPage(t, "/shops/?location_lat=59.927&location_lon=30.36&query=zara")
+	var shop struct {
+		NearestMall *struct {
+			ID int `json:"id"`
+		} `json:"nearest_mall"`
+	}
+	if len(page.Results) != 1 {
+		t.Fatalf("%d results, expected 1", len(page.Results))
+	}
+	if err := json.Unmarshal(page.Results[0], &shop); err != nil {
+		t.Fatal(err)
+	}
+	if shop.NearestMall == nil || shop.NearestMall.ID != galeria {
+		t.Errorf("nearest mall %+v, expected %d", shop.NearestMall, galeria)
+	}
+	checkBadRequests(t, []string{
+		"/shops/?sort=nearest",
+		"/shops/?location_lat=55.744",
+		"/shops/?location_lat=NaN&location_lon=37.566",
+		"/shops/?location_lat=55.744&location_lon=-Inf",
+		"/shops/?location_lat=1000&location_lon=37.566",
+		"/shops/1/?location_lat=NaN&location_lon=37.566",
+		"/shops/1/?location_lat=55.744&location_lon=181",
+	})
+}
+
+func TestShopsListPagination(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/shops/?limit=1&offset=1", []int{hm}, 4},
+		{"/shops/?limit=10&offset=3", []int{lego}, 4},
+	})
+	page := getPage(t, "/shops/?limit=2&sort=-score")
+	if ids := resultIDs(t, page); !reflect.DeepEqual(ids, []int{apple, lego}) {
+		t.Errorf("ids %v, expected %v", ids, []int{apple, lego})
+	}
+	next := getPage(t, pageURI(t, page.Next))
+	if ids := resultIDs(t, next); !reflect.DeepEqual(ids, []int{zara, hm}) {
+		t.Errorf("next page ids %v, expected %v", ids, []int{zara, hm})
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	checkListCases(t, []listCase{
+		// the malls with more of the requested shops go first
+		{"/search/?shops=1&shops=2", []int{evropeisky, galeria, afimall}, 3},
+		{"/search/?shops=3", []int{evropeisky, atrium}, 2},
+		{"/search/?shops=1&shops=2&city=2", []int{galeria}, 1},
+		{"/search/?shops=4", []int{}, 0},
+	})
+	checkNotFound(t, []string{"/search/?shops=1&city=100"})
+	checkBadRequests(t, []string{"/search/?shops=1&format=xml", "/search/?shops=x"})
+}
+
+func TestSearchSorting(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/search/?shops=1&sort=mall_name", []int{afimall, evropeisky, galeria}, 3},
+		{"/search/?shops=1&sort=-mall_id", []int{galeria, afimall, evropeisky}, 3},
+		{"/search/?shops=1&location_lat=55.749&location_lon=37.539&sort=distance", []int{afimall, evropeisky, galeria}, 3},
+		{"/search/?shops=1&location_lat=55.749&location_lon=37.539&sort=-distance", []int{galeria, evropeisky, afimall}, 3},
+	})
+	checkBadRequests(t, []string{"/search/?shops=1&sort=distance", "/search/?shops=1&sort=name"})
+}
+
+func TestSearchAlongRoute(t *testing.T) {
+	route := "from_lat=55.744&from_lon=37.566&to_lat=55.757&to_lon=37.659"
+	checkListCases(t, []listCase{
+		// Afimall is 1.8 km away from the route
+		{"/search/?shops=1&shops=3&" + route, []int{evropeisky, atrium}, 2},
+		{"/search/?shops=1&shops=3&max_detour_m=2000&" + route, []int{evropeisky, atrium, afimall}, 3},
+	})
+	checkBadRequests(t, []string{
+		"/search/?shops=1&max_detour_m=2000",
+		"/search/?shops=1&from_lat=55.744&from_lon=37.566",
+		"/search/?shops=1&max_detour_m=0&" + route,
+		"/search/?shops=1&max_detour_m=30000&" + route,
+		"/search/?shops=1&max_detour_m=NaN&" + route,
+		"/search/?shops=1&max_detour_m=Inf&" + route,
+		"/search/?shops=1&location_lat=55.744&location_lon=37.566&" + route,
+	})
+}
+
+func TestSearchPagination(t *testing.T) {
+	checkListCases(t, []listCase{
+		{"/search/?shops=1&limit=1&offset=1", []int{afimall}, 3},
+	})
+	page := getPage(t, "/search/?shops=1&limit=2")
+	if ids := resultIDs(t, page); !reflect.DeepEqual(ids, []int{evropeisky, afimall}) {
+		t.Errorf("ids %v, expected %v", ids, []int{evropeisky, afimall})
+	}
+	next := getPage(t, pageURI(t, page.Next))
+	if ids := resultIDs(t, next); !reflect.DeepEqual(ids, []int{galeria}) {
+		t.Errorf("next page ids %v, expected %v", ids, []int{galeria})
+	}
+}
+
+func TestSubwayStationsListValidation(t *testing.T) {
+	target := "/subway_stations/?location_lat=55.744&location_lon=37.566&radius_m=1000"
+	if w := doGet(t, target); w.Code != http.StatusOK {
+		t.Errorf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	checkBadRequests(t, []string{
+		"/subway_stations/?location_lat=55.744",
+		"/subway_stations/?location_lat=NaN&location_lon=37.566",
+		"/subway_stations/?location_lat=55.744&location_lon=1000",
+		"/subway_stations/?location_lat=55.744&location_lon=37.566&radius_m=NaN",
+		"/subway_stations/?location_lat=55.744&location_lon=37.566&radius_m=0",
+		"/subway_stations/?radius_m=1000",
+	})
+}
+
+func TestPlanValidation(t *testing.T) {
+	for _, target := range []string{"/plan/?shops=1&shops=3", "/plan/?shops=1&shops=3&location_lat=55.744&location_lon=37.566"} {
+		w := doGet(t, target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d, body %s", target, w.Code, w.Body)
+		}
+	}
+	checkBadRequests(t, []string{
+		"/plan/",
+		"/plan/?shops=1&location_lat=55.744",
+		"/plan/?shops=1&location_lat=NaN&location_lon=37.566",
+		"/plan/?shops=1&location_lat=55.744&location_lon=Inf",
+		"/plan/?shops=1&location_lat=1000&location_lon=37.566",
+	})
+}
+
+type suggestCase struct {
+	target   string
+	expected []string
+}
+
+func TestSuggest(t *testing.T) {
+	store := newTestStore()
+	store.AddShop(&models.Shop{ID: 5, Name: "Atrium Kids", Score: 1})
+	store.AddShopToMall(5, atrium)
+	useStore(t, store)
+	for _, c := range []suggestCase{
+		// exact names go before prefixes, equal scores are ordered by type and id
+		{"/suggest/?q=atrium", []string{"mall:4", "shop:5"}},
+		{"/suggest/?q=a", []string{"mall:2", "mall:4", "shop:3", "shop:5"}},
+		{"/suggest/?q=a&limit=2", []string{"mall:2", "mall:4"}},
+		{"/suggest/?q=a&city=2", []string{}},
+		{"/suggest/?q=galer&city=2", []string{"mall:3"}},
+		{"/suggest/?q=galer&city=1", []string{}},
+		// a word of the name
+		{"/suggest/?q=store", []string{"shop:3"}},
+		{"/suggest/?q=e", []string{"category:2", "mall:1"}},
+		{"/suggest/?q=cloth", []string{"category:1"}},
+		// the alias of the mall
+		{"/suggest/?q=" + url.QueryEscape("Европ"), []string{"mall:1"}},
+	} {
+		w := doGet(t, c.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d, body %s", c.target, w.Code, w.Body)
+			continue
+		}
+		resp := struct {
+			Data []struct {
+				Type  string  `json:"type"`
+				ID    int     `json:"id"`
+				Score float64 `json:"score"`
+			} `json:"data"`
+		}{}
+		err := json.Unmarshal(w.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("GET %s: cannot decode %s: %s", c.target, w.Body, err)
+		}
+		suggestions := []string{}
+		for i, suggestion := range resp.Data {
+			suggestions = append(suggestions, fmt.Sprintf("%s:%d", suggestion.Type, suggestion.ID))
+			if i > 0 && suggestion.Score > resp.Data[i-1].Score {
+				t.Errorf("GET %s: suggestions are not ordered by score: %s", c.target, w.Body)
+			}
+		}
+		if !reflect.DeepEqual(suggestions, c.expected) {
+			t.Errorf("GET %s: suggestions %v, expected %v", c.target, suggestions, c.expected)
+		}
+	}
+	checkBadRequests(t, []string{
+		"/suggest/",
+		"/suggest/?q=%20",
+		"/suggest/?q=a&limit=0",
+		"/suggest/?q=a&limit=51",
+	})
+	checkNotFound(t, []string{"/suggest/?q=a&city=100"})
+}
+
+type testFeatureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"features"`
+	Pagination *testPage `json:"pagination"`
+}
+
+func getFeatures(t *testing.T, target, accept string) *testFeatureCollection {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	middlewares.FormatMiddleware(w, req, testRouter.ServeHTTP)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/geo+json") {
+		t.Errorf("GET %s: content type %s", target, contentType)
+	}
+	collection := &testFeatureCollection{}
+	err := json.Unmarshal(w.Body.Bytes(), collection)
+	if err != nil {
+		t.Fatalf("GET %s: cannot decode %s: %s", target, w.Body, err)
+	}
+	if collection.Type != "FeatureCollection" {
+		t.Errorf("GET %s: type %s, expected FeatureCollection", target, collection.Type)
+	}
+	return collection
+}
+
+// checkMallFeatures checks the ids of the features and that their points are the mall locations.
+func checkMallFeatures(t *testing.T, target string, collection *testFeatureCollection, expected []int) {
+	t.Helper()
+	locations := map[int][]float64{
+		evropeisky: {37.566, 55.744},
+		afimall:    {37.539, 55.749},
+		galeria:    {30.360, 59.927},
+		atrium:     {37.659, 55.757},
+	}
+	ids := []int{}
+	for _, feature := range collection.Features {
+		id, _ := feature.Properties["id"].(float64)
+		ids = append(ids, int(id))
+		if feature.Type != "Feature" || feature.Geometry.Type != "Point" ||
+			!reflect.DeepEqual(feature.Geometry.Coordinates, locations[int(id)]) {
+			t.Errorf("GET %s: feature %d is %s with %s %v", target, int(id), feature.Type, feature.Geometry.Type, feature.Geometry.Coordinates)
+		}
+	}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("GET %s: features %v, expected %v", target, ids, expected)
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	target := "/malls/?city=1&sort=name&limit=2&format=geojson"
+	collection := getFeatures(t, target, "")
+	checkMallFeatures(t, target, collection, []int{afimall, atrium})
+	if collection.Pagination == nil || collection.Pagination.Count != 2 || collection.Pagination.TotalCount != 3 ||
+		collection.Pagination.Next == nil || collection.Pagination.Prev != nil {
+		t.Errorf("GET %s: pagination %+v", target, collection.Pagination)
+	}
+
+	// the Accept header works like the format param
+	target = "/malls/?city=2"
+	checkMallFeatures(t, target, getFeatures(t, target, "application/json;q=0.5, application/geo+json"), []int{galeria})
+
+	target = "/search/?shops=1&shops=3&city=1&location_lat=55.744&location_lon=37.566&format=geojson"
+	collection = getFeatures(t, target, "")
+	checkMallFeatures(t, target, collection, []int{evropeisky, afimall, atrium})
+	for _, feature := range collection.Features {
+		if _, ok := feature.Properties["shops"].([]interface{}); !ok {
+			t.Errorf("GET %s: feature without shops %v", target, feature.Properties)
+		}
+		if _, ok := feature.Properties["distance"].(float64); !ok {
+			t.Errorf("GET %s: feature without distance %v", target, feature.Properties)
+		}
+	}
+
+	target = "/current_mall/?location_lat=55.744&location_lon=37.566&format=geojson"
+	collection = getFeatures(t, target, "")
+	checkMallFeatures(t, target, collection, []int{evropeisky})
+	if collection.Pagination != nil {
+		t.Errorf("GET %s: pagination of a single mall %+v", target, collection.Pagination)
+	}
+
+	checkBadRequests(t, []string{"/malls/?format=xml", "/search/?shops=1&format=kml"})
+}
+
+type clustersCase struct {
+	target   string
+	expected [][]int
+}
+
+// the whole world at zoom 0 is a single cell for the malls of the fixture
+const worldBBox = "bbox=-180,-85,180,85"
+
+func TestMallClusters(t *testing.T) {
+	store := newTestStore()
+	store.AddSubwayStation(&models.SubwayStation{ID: 1, Name: "Kurskaya", CityID: moscow}, moscow)
+	store.AddMallSubwayStation(atrium, 1, 500, 7)
+	useStore(t, store)
+	for _, c := range []clustersCase{
+		{"/mall_clusters/?zoom=0&" + worldBBox, [][]int{{evropeisky, afimall, galeria, atrium}}},
+		{"/mall_clusters/?zoom=0&city=1&" + worldBBox, [][]int{{evropeisky, afimall, atrium}}},
+		// the malls of a city are apart at a street zoom
+		{"/mall_clusters/?zoom=16&city=1&bbox=37.4,55.6,37.8,55.9", [][]int{{evropeisky}, {afimall}, {atrium}}},
+		{"/mall_clusters/?zoom=10&city=1&bbox=37.4,55.6,37.8,55.9", [][]int{{evropeisky, afimall}, {atrium}}},
+		{"/mall_clusters/?zoom=0&bbox=37.5,55.7,37.6,55.8", [][]int{{evropeisky, afimall}}},
+		{"/mall_clusters/?zoom=0&shop=1&" + worldBBox, [][]int{{evropeisky, afimall, galeria}}},
+		{"/mall_clusters/?zoom=0&shop=1&city=1&" + worldBBox, [][]int{{evropeisky, afimall}}},
+		// query goes before shop, like in the malls list
+		{"/mall_clusters/?zoom=0&query=afi&shop=3&" + worldBBox, [][]int{{afimall}}},
+		// subway station goes before city
+		{"/mall_clusters/?zoom=0&subway_station=1&city=2&" + worldBBox, [][]int{{atrium}}},
+		{"/mall_clusters/?zoom=0&subway_station=1&max_walk_minutes=5&" + worldBBox, [][]int{}},
+		{"/mall_clusters/?zoom=0&shop=4&" + worldBBox, [][]int{}},
+	} {
+		w := doGet(t, c.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d, body %s", c.target, w.Code, w.Body)
+			continue
+		}
+		resp := struct {
+			Data []struct {
+				Count int   `json:"count"`
+				Malls []int `json:"malls"`
+			} `json:"data"`
+		}{}
+		err := json.Unmarshal(w.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("GET %s: cannot decode %s: %s", c.target, w.Body, err)
+		}
+		clusters := [][]int{}
+		for _, cluster := range resp.Data {
+			clusters = append(clusters, cluster.Malls)
+			if cluster.Count != len(cluster.Malls) {
+				t.Errorf("GET %s: cluster of %v has count %d", c.target, cluster.Malls, cluster.Count)
+			}
+		}
+		if !reflect.DeepEqual(clusters, c.expected) {
+			t.Errorf("GET %s: clusters %v, expected %v", c.target, clusters, c.expected)
+		}
+	}
+	checkBadRequests(t, []string{
+		"/mall_clusters/?zoom=0",
+		"/mall_clusters/?" + worldBBox,
+		"/mall_clusters/?zoom=21&" + worldBBox,
+		"/mall_clusters/?zoom=0&max_walk_minutes=5&" + worldBBox,
+		// the clusters are not a mall anymore
+		"/malls/clusters/",
+	})
+	checkNotFound(t, []string{
+		"/mall_clusters/?zoom=0&city=100&" + worldBBox,
+		"/mall_clusters/?zoom=0&shop=100&" + worldBBox,
+		"/mall_clusters/?zoom=0&subway_station=100&" + worldBBox,
+	})
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestMallSubwayStations(t *testing.T) {
+	store := newTestStore()
+	store.AddSubwayStation(&models.SubwayStation{ID: 1, Name: "Kurskaya", LineName: "Koltsevaya", CityID: moscow}, moscow)
+	store.AddSubwayStation(&models.SubwayStation{ID: 2, Name: "Chkalovskaya", LineName: "Lyublinskaya", CityID: moscow}, moscow)
+	store.AddMallSubwayStation(atrium, 1, 500, 7)
+	store.AddMallSubwayStation(atrium, 2, 300, 4)
+	store.AddMallSubwayStation(evropeisky, 1, 900, 12)
+	useStore(t, store)
+
+	type walk struct {
+		ID           int `json:"id"`
+		WalkDistance int `json:"walk_distance"`
+		WalkMinutes  int `json:"walk_minutes"`
+	}
+	for _, c := range []struct {
+		target   string
+		nearest  *int
+		stations []walk
+	}{
+		// ordered by the walk distance, the nearest one is also the single station of the old clients
+		{"/malls/4/", intPtr(2), []walk{{2, 300, 4}, {1, 500, 7}}},
+		{"/malls/1/", intPtr(1), []walk{{1, 900, 12}}},
+		{"/malls/2/", nil, []walk{}},
+	} {
+		mall := struct {
+			SubwayStation *struct {
+				ID int `json:"id"`
+			} `json:"subway_staion"`
+			SubwayStations []walk `json:"subway_stations"`
+		}{}
+		getDetails(t, c.target, &mall)
+		if !reflect.DeepEqual(mall.SubwayStations, c.stations) {
+			t.Errorf("GET %s: subway stations %v, expected %v", c.target, mall.SubwayStations, c.stations)
+		}
+		if (mall.SubwayStation == nil) != (c.nearest == nil) || mall.SubwayStation != nil && mall.SubwayStation.ID != *c.nearest {
+			t.Errorf("GET %s: subway station %+v, expected %v", c.target, mall.SubwayStation, c.nearest)
+		}
+	}
+
+	checkListCases(t, []listCase{
+		{"/malls/?subway_station=1", []int{evropeisky, atrium}, 2},
+		{"/malls/?subway_station=2", []int{atrium}, 1},
+		{"/malls/?subway_station=1&max_walk_minutes=12", []int{evropeisky, atrium}, 2},
+		{"/malls/?subway_station=1&max_walk_minutes=7", []int{atrium}, 1},
+		{"/malls/?subway_station=1&sort=-id&limit=1", []int{atrium}, 2},
+	})
+	checkBadRequests(t, []string{
+		"/malls/?max_walk_minutes=10",
+		"/malls/?subway_station=1&max_walk_minutes=-1",
+	})
+	checkNotFound(t, []string{"/malls/?subway_station=100"})
+}
+
+type testDirectoryFloor struct {
+	Floor *int `json:"floor"`
+	Shops []struct {
+		ID        int `json:"id"`
+		Placement struct {
+			Floor *int   `json:"floor"`
+			Unit  string `json:"unit"`
+		} `json:"placement"`
+	} `json:"shops"`
+}
+
+// directoryFloors reduces the directory to the floor numbers (-1 for the unknown floor)
+// and the shop ids of every floor.
+func directoryFloors(t *testing.T, target string) ([]int, [][]int) {
+	t.Helper()
+	var directory []testDirectoryFloor
+	getDetails(t, target, &directory)
+	floors := []int{}
+	shops := [][]int{}
+	for _, floor := range directory {
+		number := -1
+		if floor.Floor != nil {
+			number = *floor.Floor
+		}
+		floors = append(floors, number)
+		ids := []int{}
+		for _, shop := range floor.Shops {
+			if !reflect.DeepEqual(shop.Placement.Floor, floor.Floor) {
+				t.Errorf("GET %s: shop %d placed on floor %v, listed on floor %v", target, shop.ID, shop.Placement.Floor, floor.Floor)
+			}
+			ids = append(ids, shop.ID)
+		}
+		shops = append(shops, ids)
+	}
+	return floors, shops
+}
+
+func TestMallDirectory(t *testing.T) {
+	store := newTestStore()
+	store.AddShop(&models.Shop{ID: 5, Name: "Bershka", Score: 3})
+	store.AddShopToMall(5, evropeisky)
+	useStore(t, store)
+	for _, c := range []struct {
+		shop int
+		body string
+	}{
+		{zara, `{"floor": 2, "unit": "B12"}`},
+		{5, `{"floor": 2, "unit": "A03"}`},
+		{hm, `{"floor": 0, "unit": "C01"}`},
+		{apple, `{"unit": "K1"}`},
+	} {
+		target := fmt.Sprintf("/shops/%d/malls/%d/placement/", c.shop, evropeisky)
+		w := doAdmin(t, http.MethodPut, target, c.body)
+		checkStatus(t, w, http.MethodPut, target, http.StatusOK)
+	}
+
+	// the known floors go up from the ground one, the shops without a floor are the last group
+	floors, shops := directoryFloors(t, "/malls/1/directory/")
+	if !reflect.DeepEqual(floors, []int{0, 2, -1}) {
+		t.Errorf("GET /malls/1/directory/: floors %v, expected [0 2 unknown]", floors)
+	}
+	if !reflect.DeepEqual(shops, [][]int{{hm}, {5, zara}, {apple}}) {
+		t.Errorf("GET /malls/1/directory/: shops %v, expected [[%d] [5 %d] [%d]]", shops, hm, zara, apple)
+	}
+
+	// a mall without any placements is a single group of the unknown floor ordered by name
+	floors, shops = directoryFloors(t, "/malls/3/directory/")
+	if !reflect.DeepEqual(floors, []int{-1}) || !reflect.DeepEqual(shops, [][]int{{hm, zara}}) {
+		t.Errorf("GET /malls/3/directory/: floors %v, shops %v, expected [unknown] [[%d %d]]", floors, shops, hm, zara)
+	}
+
+	store.AddMall(&models.Mall{ID: 5, Name: "Empty", Location: models.Location{Lat: 55.8, Lon: 37.6}}, moscow, 300)
+	floors, shops = directoryFloors(t, "/malls/5/directory/")
+	if len(floors) != 0 {
+		t.Errorf("GET /malls/5/directory/: floors %v, shops %v, expected none", floors, shops)
+	}
+
+	checkBadRequests(t, []string{"/malls/mall/directory/"})
+	checkNotFound(t, []string{"/malls/100/directory/"})
+
+	// the placement is set only for the shops of the mall
+	target := fmt.Sprintf("/shops/%d/malls/%d/placement/", lego, evropeisky)
+	w := doAdmin(t, http.MethodPut, target, `{"floor": 1}`)
+	checkStatus(t, w, http.MethodPut, target, http.StatusNotFound)
+}
+
+func rectangle(x1, y1, x2, y2 float64) []models.IndoorPoint {
+	return []models.IndoorPoint{{X: x1, Y: y1}, {X: x2, Y: y1}, {X: x2, Y: y2}, {X: x1, Y: y2}, {X: x1, Y: y1}}
+}
+
+type testFloorFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string        `json:"type"`
+		Coordinates [][][]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		ID         int     `json:"id"`
+		Unit       string  `json:"unit"`
+		Floor      int     `json:"floor"`
+		Width      float64 `json:"width"`
+		Height     float64 `json:"height"`
+		UnitsCount int     `json:"units_count"`
+		Shop       *struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"shop"`
+	} `json:"properties"`
+}
+
+// getGeoJSON decodes the whole body of a successful GeoJSON response into result.
+func getGeoJSON(t *testing.T, target string, result interface{}) {
+	t.Helper()
+	w := doGet(t, target)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/geo+json") {
+		t.Errorf("GET %s: content type %s", target, contentType)
+	}
+	err := json.Unmarshal(w.Body.Bytes(), result)
+	if err != nil {
+		t.Fatalf("GET %s: cannot decode %s: %s", target, w.Body, err)
+	}
+}
+
+func checkErrorCode(t *testing.T, target string, status int, code string) {
+	t.Helper()
+	w := doGet(t, target)
+	resp := struct {
+		Error *ErrorData `json:"error"`
+	}{}
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != status || err != nil || resp.Error == nil || resp.Error.Code != code {
+		t.Errorf("GET %s: status %d, body %s, expected %d %s", target, w.Code, w.Body, status, code)
+	}
+}
+
+func TestFloors(t *testing.T) {
+	store := newTestStore()
+	// zara has a hole for the escalator in the middle, the kiosk of h&m stands inside of it
+	store.AddFloorPlan(evropeisky, &models.FloorPlan{Floor: 1, Name: "First", Width: 20, Height: 10, Units: []*models.FloorUnit{
+		{ID: 1, Unit: "101", ShopID: intPtr(zara), Polygon: [][]models.IndoorPoint{rectangle(0, 0, 10, 10), rectangle(4, 4, 6, 6)}},
+		{ID: 2, Unit: "102", Polygon: [][]models.IndoorPoint{rectangle(10, 0, 20, 10)}},
+		{ID: 3, Unit: "K1", ShopID: intPtr(hm), Polygon: [][]models.IndoorPoint{rectangle(7, 7, 8, 8)}},
+	}})
+	store.AddFloorPlan(evropeisky, &models.FloorPlan{Floor: -1, Name: "Parking", Width: 30, Height: 30})
+	useStore(t, store)
+
+	floors := struct {
+		Type     string             `json:"type"`
+		Features []testFloorFeature `json:"features"`
+	}{}
+	getGeoJSON(t, "/malls/1/floors/", &floors)
+	if len(floors.Features) != 2 {
+		t.Fatalf("GET /malls/1/floors/: %d features, expected 2", len(floors.Features))
+	}
+	for i, expected := range []struct {
+		floor, unitsCount int
+		extent            [][]float64
+	}{
+		{-1, 0, [][]float64{{0, 0}, {30, 0}, {30, 30}, {0, 30}, {0, 0}}},
+		{1, 3, [][]float64{{0, 0}, {20, 0}, {20, 10}, {0, 10}, {0, 0}}},
+	} {
+		feature := floors.Features[i]
+		if feature.Properties.Floor != expected.floor || feature.Properties.UnitsCount != expected.unitsCount ||
+			feature.Geometry.Type != "Polygon" || !reflect.DeepEqual(feature.Geometry.Coordinates, [][][]float64{expected.extent}) {
+			t.Errorf("GET /malls/1/floors/: feature %d is %+v, expected floor %d with %d units and extent %v",
+				i, feature, expected.floor, expected.unitsCount, expected.extent)
+		}
+	}
+	getGeoJSON(t, "/malls/2/floors/", &floors)
+	if floors.Type != "FeatureCollection" || len(floors.Features) != 0 {
+		t.Errorf("GET /malls/2/floors/: %+v, expected an empty collection", floors)
+	}
+
+	plan := struct {
+		Type     string             `json:"type"`
+		Floor    int                `json:"floor"`
+		Name     string             `json:"name"`
+		Features []testFloorFeature `json:"features"`
+	}{}
+	getGeoJSON(t, "/malls/1/floors/1/", &plan)
+	if plan.Type != "FeatureCollection" || plan.Floor != 1 || plan.Name != "First" {
+		t.Errorf("GET /malls/1/floors/1/: %s of floor %d %q", plan.Type, plan.Floor, plan.Name)
+	}
+	units := []string{}
+	shops := []int{}
+	for _, feature := range plan.Features {
+		units = append(units, feature.Properties.Unit)
+		shopID := 0
+		if feature.Properties.Shop != nil {
+			shopID = feature.Properties.Shop.ID
+		}
+		shops = append(shops, shopID)
+	}
+	if !reflect.DeepEqual(units, []string{"101", "102", "K1"}) || !reflect.DeepEqual(shops, []int{zara, 0, hm}) {
+		t.Errorf("GET /malls/1/floors/1/: units %v with shops %v", units, shops)
+	}
+	if len(plan.Features) > 0 && len(plan.Features[0].Geometry.Coordinates) != 2 {
+		t.Errorf("GET /malls/1/floors/1/: unit 101 has %d rings, expected 2", len(plan.Features[0].Geometry.Coordinates))
+	}
+
+	for _, c := range []struct {
+		target string
+		unit   string
+		shop   int
+	}{
+		{"/malls/1/floors/1/unit/?x=2&y=2", "101", zara},
+		// the smallest unit wins when they overlap
+		{"/malls/1/floors/1/unit/?x=7.5&y=7.5", "K1", hm},
+		{"/malls/1/floors/1/unit/?x=15&y=5", "102", 0},
+	} {
+		unit := testFloorFeature{}
+		getGeoJSON(t, c.target, &unit)
+		shopID := 0
+		if unit.Properties.Shop != nil {
+			shopID = unit.Properties.Shop.ID
+		}
+		if unit.Type != "Feature" || unit.Properties.Unit != c.unit || unit.Properties.Floor != 1 || shopID != c.shop {
+			t.Errorf("GET %s: %+v, expected unit %s with shop %d", c.target, unit, c.unit, c.shop)
+		}
+	}
+	unit := testFloorFeature{}
+	getGeoJSON(t, "/malls/1/floors/1/unit/?x=2&y=2", &unit)
+	if unit.Properties.Shop == nil || unit.Properties.Shop.Name != "Zara" {
+		t.Errorf("GET /malls/1/floors/1/unit/?x=2&y=2: shop %+v, expected Zara", unit.Properties.Shop)
+	}
+
+	checkBadRequests(t, []string{
+		"/malls/mall/floors/",
+		"/malls/1/floors/first/",
+		"/malls/1/floors/1/unit/?x=2",
+		"/malls/1/floors/1/unit/?x=a&y=2",
+	})
+	checkErrorCode(t, "/malls/100/floors/", http.StatusNotFound, MALL_NOT_FOUND)
+	checkErrorCode(t, "/malls/1/floors/3/", http.StatusNotFound, FLOOR_NOT_FOUND)
+	checkErrorCode(t, "/malls/2/floors/1/", http.StatusNotFound, FLOOR_NOT_FOUND)
+	checkErrorCode(t, "/malls/1/floors/3/unit/?x=2&y=2", http.StatusNotFound, UNIT_NOT_FOUND)
+	// the escalator hole and the point outside of the plan
+	checkErrorCode(t, "/malls/1/floors/1/unit/?x=5&y=5", http.StatusNotFound, UNIT_NOT_FOUND)
+	checkErrorCode(t, "/malls/1/floors/1/unit/?x=50&y=50", http.StatusNotFound, UNIT_NOT_FOUND)
+}
+
+// TestReadyzShutdown cannot be undone, nothing else in the tests reads the readiness.
+// Before the shutdown /readyz checks postgres and redis, which the tests do not have.
+func TestReadyzShutdown(t *testing.T) {
+	health.StartShutdown()
+
+	w := doGet(t, "/readyz")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz: status %d, expected 503, body %s", w.Code, w.Body)
+	}
+	readiness := struct {
+		Status string                     `json:"status"`
+		Checks map[string]json.RawMessage `json:"checks"`
+	}{}
+	err := json.Unmarshal(w.Body.Bytes(), &readiness)
+	if err != nil {
+		t.Fatalf("GET /readyz: cannot decode %s: %s", w.Body, err)
+	}
+	// the dependencies are not checked while shutting down
+	if readiness.Status != "shutting_down" || len(readiness.Checks) != 0 {
+		t.Errorf("GET /readyz: status %s with checks %v, expected shutting_down without checks", readiness.Status, readiness.Checks)
+	}
+
+	// the liveness stays ok, so that the instance is not restarted while draining
+	w = doGet(t, "/healthz")
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /healthz: status %d, expected 200, body %s", w.Code, w.Body)
+	}
+}
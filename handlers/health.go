@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"mallfin_api/health"
+	"mallfin_api/serializers"
+
+	"mallfin_api/logging"
+
+	"github.com/gazoon/httprouter"
+)
+
+// Healthz is the liveness probe, it stays ok while shutting down so that the instance is not restarted mid-drain.
+func Healthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	writeJSON(ctx, w, JSONObject{"status": "ok"}, http.StatusOK)
+}
+
+func Readyz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	readiness := health.Readiness()
+	for _, check := range readiness.Checks {
+		if check.Err != nil {
+			logger.WithField("check", check.Name).Warnf("Dependency check failed: %s", check.Err)
+		}
+	}
+	status := http.StatusOK
+	if !readiness.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(ctx, w, serializers.SerializeReadiness(readiness), status)
+}
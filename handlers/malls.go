@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"mallfin_api/db"
 	"mallfin_api/models"
 	"mallfin_api/serializers"
 
@@ -20,16 +19,16 @@ func mallsBySubwayStation(w http.ResponseWriter, r *http.Request, formData *mall
 	if !checkSubwayStation(ctx, w, subwayStationID) {
 		return
 	}
-	malls, err := db.GetMallsBySubwayStation(subwayStationID, formData.Sort, formData.Limit, formData.Offset)
+	malls, err := stores.Malls.GetMallsBySubwayStation(ctx, subwayStationID, formData.MaxWalkMinutes, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
 		return
 	}
-	totalCount, ok := totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+	totalCount, ok := totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 	if !ok {
 		logger.Info("Getting count of malls by station from db")
-		totalCount, err = db.MallsBySubwayStationCount(subwayStationID)
+		totalCount, err = stores.Malls.MallsBySubwayStationCount(ctx, subwayStationID, formData.MaxWalkMinutes, formData.OpenAt, formData.Geo)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
@@ -37,7 +36,8 @@ func mallsBySubwayStation(w http.ResponseWriter, r *http.Request, formData *mall
 		}
 	}
 	serialized := serializers.SerializeMalls(malls)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		mallsPage(malls, formData.Sort, formData.Cursor))
 }
 
 func mallsByQuery(w http.ResponseWriter, r *http.Request, formData *mallsListForm) {
@@ -49,16 +49,16 @@ func mallsByQuery(w http.ResponseWriter, r *http.Request, formData *mallsListFor
 	if formData.City != nil {
 		userCity := *formData.City
 		var err error
-		malls, err = db.GetMallsByName(name, userCity, formData.Sort, formData.Limit, formData.Offset)
+		malls, err = stores.Malls.GetMallsByName(ctx, name, userCity, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.MallsByNameCount(name, userCity)
+			totalCount, err = stores.Malls.MallsByNameCount(ctx, name, userCity, formData.OpenAt, formData.Geo)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -67,16 +67,16 @@ func mallsByQuery(w http.ResponseWriter, r *http.Request, formData *mallsListFor
 		}
 	} else {
 		var err error
-		malls, err = db.GetMallsByNameWithoutCity(name, formData.Sort, formData.Limit, formData.Offset)
+		malls, err = stores.Malls.GetMallsByNameWithoutCity(ctx, name, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.MallsByNameWithoutCityCount(name)
+			totalCount, err = stores.Malls.MallsByNameWithoutCityCount(ctx, name, formData.OpenAt, formData.Geo)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -85,7 +85,8 @@ func mallsByQuery(w http.ResponseWriter, r *http.Request, formData *mallsListFor
 		}
 	}
 	serialized := serializers.SerializeMalls(malls)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		mallsPage(malls, formData.Sort, formData.Cursor))
 }
 
 func mallsByShop(w http.ResponseWriter, r *http.Request, formData *mallsListForm) {
@@ -100,16 +101,16 @@ func mallsByShop(w http.ResponseWriter, r *http.Request, formData *mallsListForm
 	if formData.City != nil {
 		userCity := *formData.City
 		var err error
-		malls, err = db.GetMallsByShop(shopID, userCity, formData.Sort, formData.Limit, formData.Offset)
+		malls, err = stores.Malls.GetMallsByShop(ctx, shopID, userCity, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.MallsByShopCount(shopID, userCity)
+			totalCount, err = stores.Malls.MallsByShopCount(ctx, shopID, userCity, formData.OpenAt, formData.Geo)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -118,16 +119,16 @@ func mallsByShop(w http.ResponseWriter, r *http.Request, formData *mallsListForm
 		}
 	} else {
 		var err error
-		malls, err = db.GetMallsByShopWithoutCity(shopID, formData.Sort, formData.Limit, formData.Offset)
+		malls, err = stores.Malls.GetMallsByShopWithoutCity(ctx, shopID, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.MallsByShopWithoutCityCount(shopID)
+			totalCount, err = stores.Malls.MallsByShopWithoutCityCount(ctx, shopID, formData.OpenAt, formData.Geo)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -136,7 +137,8 @@ func mallsByShop(w http.ResponseWriter, r *http.Request, formData *mallsListForm
 		}
 	}
 	serialized := serializers.SerializeMalls(malls)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		mallsPage(malls, formData.Sort, formData.Cursor))
 }
 
 func allMalls(w http.ResponseWriter, r *http.Request, formData *mallsListForm) {
@@ -147,16 +149,16 @@ func allMalls(w http.ResponseWriter, r *http.Request, formData *mallsListForm) {
 	if formData.City != nil {
 		userCity := *formData.City
 		var err error
-		malls, err = db.GetMalls(userCity, formData.Sort, formData.Limit, formData.Offset)
+		malls, err = stores.Malls.GetMalls(ctx, userCity, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.MallsCount(userCity)
+			totalCount, err = stores.Malls.MallsCount(ctx, userCity, formData.OpenAt, formData.Geo)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -165,16 +167,16 @@ func allMalls(w http.ResponseWriter, r *http.Request, formData *mallsListForm) {
 		}
 	} else {
 		var err error
-		malls, err = db.GetMallsWithoutCity(formData.Sort, formData.Limit, formData.Offset)
+		malls, err = stores.Malls.GetMallsWithoutCity(ctx, formData.OpenAt, formData.Geo, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(malls), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.MallsWithoutCityCount()
+			totalCount, err = stores.Malls.MallsWithoutCityCount(ctx, formData.OpenAt, formData.Geo)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -183,7 +185,8 @@ func allMalls(w http.ResponseWriter, r *http.Request, formData *mallsListForm) {
 		}
 	}
 	serialized := serializers.SerializeMalls(malls)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		mallsPage(malls, formData.Sort, formData.Cursor))
 }
 
 func MallsList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -195,7 +198,7 @@ func MallsList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
-	if !checkCity(ctx, w, formData.City) {
+	if !checkCity(ctx, w, formData.City) || !checkFormat(ctx, w, r) {
 		return
 	}
 
@@ -210,15 +213,53 @@ func MallsList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 }
 
+func MallClusters(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := &mallClustersForm{}
+	errs := binding.Form(r, formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkCity(ctx, w, formData.City) {
+		return
+	}
+	if formData.SubwayStation != nil {
+		if !checkSubwayStation(ctx, w, *formData.SubwayStation) {
+			return
+		}
+	} else if formData.Query == nil && formData.Shop != nil {
+		if !checkShop(ctx, w, *formData.Shop) {
+			return
+		}
+	}
+	malls, err := stores.Malls.GetMallLocations(ctx, formData.MallFilter())
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	clusters := models.ClusterMalls(malls, formData.Zoom)
+	serialized := serializers.SerializeMallClusters(clusters)
+	response(ctx, w, serialized)
+}
+
 func MallDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	ctx := r.Context()
 	logger := logging.FromContext(ctx)
+	formData := mallDetailsForm{}
+	errs := binding.Form(r, &formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
 	mallID, err := ps.ByNameInt("id")
 	if err != nil {
 		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
 		return
 	}
-	mall, err := db.GetMallDetails(mallID)
+	mall, err := stores.Malls.GetMallDetails(ctx, mallID)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -228,7 +269,29 @@ func MallDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		notFoundResponse(ctx, w, MALL_NOT_FOUND)
 		return
 	}
-	serialized := serializers.SerializeMall(mall)
+	serialized := serializers.SerializeMall(mall, formData.ScheduleDays())
+	response(ctx, w, serialized)
+}
+
+func MallDirectory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	mallID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkMall(ctx, w, mallID) {
+		return
+	}
+	shops, err := stores.Shops.GetMallDirectory(ctx, mallID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	floors := models.GroupShopsByFloor(shops)
+	serialized := serializers.SerializeMallDirectory(floors)
 	response(ctx, w, serialized)
 }
 
@@ -243,7 +306,7 @@ func ShopsInMalls(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 	mallIDs := formData.Malls
 	shopIDs := formData.Shops
-	mallsShops, err := db.GetShopsInMalls(mallIDs, shopIDs)
+	mallsShops, err := stores.Malls.GetShopsInMalls(ctx, mallIDs, shopIDs)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -262,11 +325,14 @@ func CurrentMall(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
 		return
 	}
+	if !checkFormat(ctx, w, r) {
+		return
+	}
 	userLocation := &models.Location{
 		Lat: formData.LocationLat,
 		Lon: formData.LocationLon,
 	}
-	mall, err := db.GetMallByLocation(userLocation)
+	mall, err := stores.Malls.GetMallByLocation(ctx, userLocation)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -276,6 +342,10 @@ func CurrentMall(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		notFoundResponse(ctx, w, MALL_NOT_FOUND)
 		return
 	}
-	serialized := serializers.SerializeMall(mall)
+	serialized := serializers.SerializeMall(mall, defaultScheduleDays)
+	if isGeoJSONRequested(r) {
+		geoJSONResponse(ctx, w, serializers.NewFeatureCollection([]*serializers.Feature{serializers.MallFeature(serialized)}))
+		return
+	}
 	response(ctx, w, serialized)
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"mallfin_api/models"
+	"mallfin_api/serializers"
+
+	"mallfin_api/logging"
+
+	"github.com/gazoon/binding"
+	"github.com/gazoon/httprouter"
+)
+
+// planCandidates returns all the malls that have any of the shops, with the distance if the location is known.
+func planCandidates(ctx context.Context, formData *planForm) ([]*models.SearchResult, error) {
+	if formData.City != nil {
+		if formData.Location != nil {
+			return stores.Search.GetSearchResultsWithDistance(ctx, formData.Shops, formData.Location, *formData.City, formData.OpenAt, nil, nil, nil, nil)
+		}
+		return stores.Search.GetSearchResults(ctx, formData.Shops, *formData.City, formData.OpenAt, nil, nil, nil, nil)
+	}
+	if formData.Location != nil {
+		return stores.Search.GetSearchResultsWithDistanceWithoutCity(ctx, formData.Shops, formData.Location, formData.OpenAt, nil, nil, nil, nil)
+	}
+	return stores.Search.GetSearchResultsWithoutCity(ctx, formData.Shops, formData.OpenAt, nil, nil, nil, nil)
+}
+
+func Plan(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := planForm{}
+	errs := binding.Form(r, &formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkCity(ctx, w, formData.City) {
+		return
+	}
+	candidates, err := planCandidates(ctx, &formData)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	plan := models.PlanShopping(formData.Shops, candidates)
+	serialized := serializers.SerializeShoppingPlan(plan)
+	response(ctx, w, serialized)
+}
@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"mallfin_api/db"
 	"mallfin_api/models"
 	"mallfin_api/serializers"
 
@@ -25,10 +24,13 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 	limit := formData.Limit
 	offset := formData.Offset
+	cursor := formData.Cursor
+	openAt := formData.OpenAt
 	cityID := formData.City
 	shopIDs := formData.Shops
 	sorting := formData.Sort
-	if !checkCity(ctx, w, cityID) {
+	route := formData.Route
+	if !checkCity(ctx, w, cityID) || !checkFormat(ctx, w, r) {
 		return
 	}
 	var searchResults []*models.SearchResult
@@ -42,9 +44,17 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 	if cityID != nil {
 		userCity := *cityID
-		if userLocation != nil {
+		if route != nil {
 			var err error
-			searchResults, err = db.GetSearchResultsWithDistance(shopIDs, userLocation, userCity, sorting, limit, offset)
+			searchResults, err = stores.Search.GetSearchResultsAlongRoute(ctx, shopIDs, route, userCity, openAt, sorting, limit, offset, cursor)
+			if err != nil {
+				logger.Error(err)
+				internalErrorResponse(w)
+				return
+			}
+		} else if userLocation != nil {
+			var err error
+			searchResults, err = stores.Search.GetSearchResultsWithDistance(ctx, shopIDs, userLocation, userCity, openAt, sorting, limit, offset, cursor)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -52,7 +62,7 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 			}
 		} else {
 			var err error
-			searchResults, err = db.GetSearchResults(shopIDs, userCity, sorting, limit, offset)
+			searchResults, err = stores.Search.GetSearchResults(ctx, shopIDs, userCity, openAt, sorting, limit, offset, cursor)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -60,10 +70,14 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 			}
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(searchResults), limit, offset)
+		totalCount, ok = totalCountFromResults(len(searchResults), limit, offset, cursor)
 		if !ok {
 			var err error
-			totalCount, err = db.SearchResultsCount(shopIDs, userCity)
+			if route != nil {
+				totalCount, err = stores.Search.SearchResultsAlongRouteCount(ctx, shopIDs, route, userCity, openAt)
+			} else {
+				totalCount, err = stores.Search.SearchResultsCount(ctx, shopIDs, userCity, openAt)
+			}
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -71,9 +85,17 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 			}
 		}
 	} else {
-		if userLocation != nil {
+		if route != nil {
 			var err error
-			searchResults, err = db.GetSearchResultsWithDistanceWithoutCity(shopIDs, userLocation, sorting, limit, offset)
+			searchResults, err = stores.Search.GetSearchResultsAlongRouteWithoutCity(ctx, shopIDs, route, openAt, sorting, limit, offset, cursor)
+			if err != nil {
+				logger.Error(err)
+				internalErrorResponse(w)
+				return
+			}
+		} else if userLocation != nil {
+			var err error
+			searchResults, err = stores.Search.GetSearchResultsWithDistanceWithoutCity(ctx, shopIDs, userLocation, openAt, sorting, limit, offset, cursor)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -81,7 +103,7 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 			}
 		} else {
 			var err error
-			searchResults, err = db.GetSearchResultsWithoutCity(shopIDs, sorting, limit, offset)
+			searchResults, err = stores.Search.GetSearchResultsWithoutCity(ctx, shopIDs, openAt, sorting, limit, offset, cursor)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -89,10 +111,14 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 			}
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(searchResults), limit, offset)
+		totalCount, ok = totalCountFromResults(len(searchResults), limit, offset, cursor)
 		if !ok {
 			var err error
-			totalCount, err = db.SearchResultsWithoutCityCount(shopIDs)
+			if route != nil {
+				totalCount, err = stores.Search.SearchResultsAlongRouteWithoutCityCount(ctx, shopIDs, route, openAt)
+			} else {
+				totalCount, err = stores.Search.SearchResultsWithoutCityCount(ctx, shopIDs, openAt)
+			}
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -101,5 +127,5 @@ func Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		}
 	}
 	serialized := serializers.SerializeSearchResults(searchResults)
-	paginateResponse(ctx, w, r, serialized, totalCount, limit, offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, limit, offset, searchResultsPage(searchResults, sorting, cursor))
 }
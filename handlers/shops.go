@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"mallfin_api/db"
 	"mallfin_api/models"
 	"mallfin_api/serializers"
 
@@ -20,15 +19,28 @@ func shopsByMall(w http.ResponseWriter, r *http.Request, formData *shopsListForm
 	if !checkMall(ctx, w, mallID) {
 		return
 	}
-	shops, err := db.GetShopsByMall(mallID, formData.Sort, formData.Limit, formData.Offset)
+	if formData.OpenAt != nil {
+		mall, err := stores.Malls.GetMallDetails(ctx, mallID)
+		if err != nil {
+			logger.Error(err)
+			internalErrorResponse(w)
+			return
+		}
+		if mall == nil || !mall.IsOpenAt(*formData.OpenAt) {
+			paginateResponse(ctx, w, r, serializers.SerializeShops(nil), 0, formData.Limit, formData.Offset,
+				shopsPage(nil, formData.Sort, formData.Cursor))
+			return
+		}
+	}
+	shops, err := stores.Shops.GetShopsByMall(ctx, mallID, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
 		return
 	}
-	totalCount, ok := totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+	totalCount, ok := totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 	if !ok {
-		totalCount, err = db.ShopsByMallCount(mallID)
+		totalCount, err = stores.Shops.ShopsByMallCount(ctx, mallID)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
@@ -36,7 +48,8 @@ func shopsByMall(w http.ResponseWriter, r *http.Request, formData *shopsListForm
 		}
 	}
 	serialized := serializers.SerializeShops(shops)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		shopsPage(shops, formData.Sort, formData.Cursor))
 }
 
 func shopsByQuery(w http.ResponseWriter, r *http.Request, formData *shopsListForm) {
@@ -48,16 +61,16 @@ func shopsByQuery(w http.ResponseWriter, r *http.Request, formData *shopsListFor
 	if formData.City != nil {
 		userCity := *formData.City
 		var err error
-		shops, err = db.GetShopsByName(name, userCity, formData.Sort, formData.Limit, formData.Offset)
+		shops, err = stores.Shops.GetShopsByName(ctx, name, userCity, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.ShopsByNameCount(name, userCity)
+			totalCount, err = stores.Shops.ShopsByNameCount(ctx, name, userCity)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -66,16 +79,16 @@ func shopsByQuery(w http.ResponseWriter, r *http.Request, formData *shopsListFor
 		}
 	} else {
 		var err error
-		shops, err = db.GetShopsByNameWithoutCity(name, formData.Sort, formData.Limit, formData.Offset)
+		shops, err = stores.Shops.GetShopsByNameWithoutCity(ctx, name, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.ShopsByNameWithoutCityCount(name)
+			totalCount, err = stores.Shops.ShopsByNameWithoutCityCount(ctx, name)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -84,7 +97,8 @@ func shopsByQuery(w http.ResponseWriter, r *http.Request, formData *shopsListFor
 		}
 	}
 	serialized := serializers.SerializeShops(shops)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		shopsPage(shops, formData.Sort, formData.Cursor))
 }
 
 func shopsByCategory(w http.ResponseWriter, r *http.Request, formData *shopsListForm) {
@@ -99,16 +113,16 @@ func shopsByCategory(w http.ResponseWriter, r *http.Request, formData *shopsList
 	if formData.City != nil {
 		userCity := *formData.City
 		var err error
-		shops, err = db.GetShopsByCategory(categoryID, userCity, formData.Sort, formData.Limit, formData.Offset)
+		shops, err = stores.Shops.GetShopsByCategory(ctx, categoryID, userCity, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.ShopsByCategoryCount(categoryID, userCity)
+			totalCount, err = stores.Shops.ShopsByCategoryCount(ctx, categoryID, userCity)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -117,16 +131,16 @@ func shopsByCategory(w http.ResponseWriter, r *http.Request, formData *shopsList
 		}
 	} else {
 		var err error
-		shops, err = db.GetShopsByCategoryWithoutCity(categoryID, formData.Sort, formData.Limit, formData.Offset)
+		shops, err = stores.Shops.GetShopsByCategoryWithoutCity(ctx, categoryID, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.ShopsByCategoryWithoutCityCount(categoryID)
+			totalCount, err = stores.Shops.ShopsByCategoryWithoutCityCount(ctx, categoryID)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -135,7 +149,8 @@ func shopsByCategory(w http.ResponseWriter, r *http.Request, formData *shopsList
 		}
 	}
 	serialized := serializers.SerializeShops(shops)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		shopsPage(shops, formData.Sort, formData.Cursor))
 }
 
 func allShops(w http.ResponseWriter, r *http.Request, formData *shopsListForm) {
@@ -146,16 +161,16 @@ func allShops(w http.ResponseWriter, r *http.Request, formData *shopsListForm) {
 	if formData.City != nil {
 		userCity := *formData.City
 		var err error
-		shops, err = db.GetShops(userCity, formData.Sort, formData.Limit, formData.Offset)
+		shops, err = stores.Shops.GetShops(ctx, userCity, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.ShopsCount(userCity)
+			totalCount, err = stores.Shops.ShopsCount(ctx, userCity)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -164,16 +179,16 @@ func allShops(w http.ResponseWriter, r *http.Request, formData *shopsListForm) {
 		}
 	} else {
 		var err error
-		shops, err = db.GetShopsWithoutCity(formData.Sort, formData.Limit, formData.Offset)
+		shops, err = stores.Shops.GetShopsWithoutCity(ctx, formData.Location, formData.Sort, formData.Limit, formData.Offset, formData.Cursor)
 		if err != nil {
 			logger.Error(err)
 			internalErrorResponse(w)
 			return
 		}
 		var ok bool
-		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset)
+		totalCount, ok = totalCountFromResults(len(shops), formData.Limit, formData.Offset, formData.Cursor)
 		if !ok {
-			totalCount, err = db.ShopsWithoutCityCount()
+			totalCount, err = stores.Shops.ShopsWithoutCityCount(ctx)
 			if err != nil {
 				logger.Error(err)
 				internalErrorResponse(w)
@@ -182,7 +197,8 @@ func allShops(w http.ResponseWriter, r *http.Request, formData *shopsListForm) {
 		}
 	}
 	serialized := serializers.SerializeShops(shops)
-	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset)
+	paginateResponse(ctx, w, r, serialized, totalCount, formData.Limit, formData.Offset,
+		shopsPage(shops, formData.Sort, formData.Cursor))
 }
 
 func ShopsList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -229,14 +245,10 @@ func ShopDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	}
 
 	var shop *models.Shop
-	if formData.LocationLat != nil && formData.LocationLon != nil {
-		userLocation := &models.Location{
-			Lat: *formData.LocationLat,
-			Lon: *formData.LocationLon,
-		}
-		shop, err = db.GetShopDetailsWithLocation(shopID, userLocation)
+	if formData.Location != nil {
+		shop, err = stores.Shops.GetShopDetailsWithLocation(ctx, shopID, formData.Location)
 	} else {
-		shop, err = db.GetShopDetails(shopID)
+		shop, err = stores.Shops.GetShopDetails(ctx, shopID)
 	}
 	if err != nil {
 		logger.Error(err)
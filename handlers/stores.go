@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"mallfin_api/db"
+)
+
+type Stores struct {
+	Malls      db.MallStore
+	Shops      db.ShopStore
+	Categories db.CategoryStore
+	Cities     db.CityStore
+	Search     db.SearchStore
+	Suggest    db.SuggestStore
+
+	SubwayStations db.SubwayStationStore
+	FloorPlans     db.FloorPlanStore
+}
+
+var stores *Stores
+
+func Initialization(handlerStores *Stores) {
+	stores = handlerStores
+}
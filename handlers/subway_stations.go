@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+
+	"mallfin_api/models"
+	"mallfin_api/serializers"
+
+	"mallfin_api/logging"
+
+	"github.com/gazoon/binding"
+	"github.com/gazoon/httprouter"
+)
+
+func SubwayStationsList(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := subwayStationsListForm{}
+	errs := binding.Form(r, &formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkCity(ctx, w, formData.City) {
+		return
+	}
+	sorting := formData.Sort
+	var stations []*models.SubwayStation
+	var err error
+	if formData.Query != nil {
+		name := *formData.Query
+		if formData.City != nil {
+			stations, err = stores.SubwayStations.GetSubwayStationsByName(ctx, name, *formData.City, sorting)
+		} else {
+			stations, err = stores.SubwayStations.GetSubwayStationsByNameWithoutCity(ctx, name, sorting)
+		}
+	} else if formData.Location != nil {
+		radius := *formData.Radius
+		if formData.City != nil {
+			stations, err = stores.SubwayStations.GetSubwayStationsNear(ctx, formData.Location, radius, *formData.City, sorting)
+		} else {
+			stations, err = stores.SubwayStations.GetSubwayStationsNearWithoutCity(ctx, formData.Location, radius, sorting)
+		}
+	} else {
+		if formData.City != nil {
+			stations, err = stores.SubwayStations.GetSubwayStations(ctx, *formData.City, sorting)
+		} else {
+			stations, err = stores.SubwayStations.GetSubwayStationsWithoutCity(ctx, sorting)
+		}
+	}
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	serialized := serializers.SerializeSubwayStations(stations)
+	response(ctx, w, serialized)
+}
+
+func SubwayStationDetails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	stationID, err := ps.ByNameInt("id")
+	if err != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, err.Error(), http.StatusBadRequest)
+		return
+	}
+	station, err := stores.SubwayStations.GetSubwayStationDetails(ctx, stationID)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if station == nil {
+		notFoundResponse(ctx, w, SUBWAY_STATION_NOT_FOUND)
+		return
+	}
+	serialized := serializers.SerializeSubwayStation(station)
+	response(ctx, w, serialized)
+}
+
+func NearestSubwayStation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := CoordinatesForm{}
+	errs := binding.Form(r, &formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	userLocation := &models.Location{
+		Lat: formData.LocationLat,
+		Lon: formData.LocationLon,
+	}
+	station, err := stores.SubwayStations.GetNearestSubwayStation(ctx, userLocation)
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	if station == nil {
+		errorResponse(ctx, w, SUBWAY_STATION_NOT_FOUND, "There are no subway stations with known location.", http.StatusNotFound)
+		return
+	}
+	serialized := serializers.SerializeSubwayStation(station)
+	response(ctx, w, serialized)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"mallfin_api/serializers"
+
+	"mallfin_api/logging"
+
+	"github.com/gazoon/binding"
+	"github.com/gazoon/httprouter"
+)
+
+func Suggest(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	formData := suggestForm{}
+	errs := binding.Form(r, &formData)
+	if errs != nil {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkCity(ctx, w, formData.City) {
+		return
+	}
+	suggestions, err := stores.Suggest.GetSuggestions(ctx, formData.Query, formData.City, formData.SuggestLimit())
+	if err != nil {
+		logger.Error(err)
+		internalErrorResponse(w)
+		return
+	}
+	serialized := serializers.SerializeSuggestions(suggestions)
+	response(ctx, w, serialized)
+}
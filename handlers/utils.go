@@ -8,8 +8,9 @@ import (
 	"strconv"
 
 	"context"
-	"mallfin_api/db"
 	"mallfin_api/logging"
+	"mallfin_api/models"
+	"mallfin_api/serializers"
 )
 
 const (
@@ -20,6 +21,9 @@ const (
 	SUBWAY_STATION_NOT_FOUND = "SUBWAY_STATION_NOT_FOUND"
 	SHOP_NOT_FOUND           = "SHOP_NOT_FOUND"
 	CATEGORY_NOT_FOUND       = "CATEGORY_NOT_FOUND"
+	FLOOR_NOT_FOUND          = "FLOOR_NOT_FOUND"
+	UNIT_NOT_FOUND           = "UNIT_NOT_FOUND"
+	UNAUTHORIZED             = "UNAUTHORIZED"
 )
 const DoesNotExistMsg = "%s with such id does not exists."
 
@@ -48,6 +52,10 @@ type PaginationData struct {
 }
 
 func writeJSON(ctx context.Context, w http.ResponseWriter, resp interface{}, status int) {
+	writeJSONWithType(ctx, w, resp, status, "application/json")
+}
+
+func writeJSONWithType(ctx context.Context, w http.ResponseWriter, resp interface{}, status int, contentType string) {
 	logger := logging.FromContext(ctx)
 	b, err := json.Marshal(resp)
 	if err != nil {
@@ -55,7 +63,7 @@ func writeJSON(ctx context.Context, w http.ResponseWriter, resp interface{}, sta
 		internalErrorResponse(w)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	_, err = w.Write(b)
 	if err != nil {
@@ -84,6 +92,39 @@ func response(ctx context.Context, w http.ResponseWriter, data interface{}) {
 	writeJSON(ctx, w, resp, http.StatusOK)
 }
 
+func geoJSONResponse(ctx context.Context, w http.ResponseWriter, data interface{}) {
+	writeJSONWithType(ctx, w, data, http.StatusOK, serializers.GeoJSONContentType)
+}
+
+func isGeoJSONRequested(r *http.Request) bool {
+	return r.URL.Query().Get("format") == serializers.GeoJSONFormat
+}
+
+// checkFormat validates the format param of the endpoints that support GeoJSON.
+func checkFormat(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != serializers.GeoJSONFormat {
+		errorResponse(ctx, w, INCORRECT_REQUEST_DATA, "format must be json or geojson", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// resultsFeatures converts serialized results to GeoJSON features, false if the results have no location.
+func resultsFeatures(resultsList interface{}) ([]*serializers.Feature, bool) {
+	switch results := resultsList.(type) {
+	case []*serializers.MallBase:
+		return serializers.MallsFeatures(results), true
+	case []*serializers.SearchResult:
+		return serializers.SearchResultsFeatures(results), true
+	}
+	return nil, false
+}
+
+func noContentResponse(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func nextPage(totalCount, limit, offset int) (int, int, bool) {
 	if limit+offset >= totalCount {
 		return 0, 0, false
@@ -122,28 +163,120 @@ func pageURL(r *http.Request, limit, offset int) string {
 	return url.String()
 }
 
-func paginateResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, resultsList interface{}, totalCount int, limit, offset *int) {
-	limitValue := totalCount
-	if limit != nil {
-		limitValue = *limit
+func cursorURL(r *http.Request, cursor *models.Cursor) string {
+	url := *r.URL
+	params := url.Query()
+	params.Set("cursor", cursor.String())
+	params.Del("offset")
+	url.RawQuery = params.Encode()
+	return url.String()
+}
+
+type cursorPage struct {
+	sorting models.Sorting
+	cursor  *models.Cursor
+	first   []interface{}
+	last    []interface{}
+}
+
+func (cp *cursorPage) links(r *http.Request, count, totalCount int, limit *int) (*string, *string) {
+	if cp.cursor == nil && limit == nil {
+		return nil, nil
 	}
-	offsetValue := 0
-	if offset != nil {
-		offsetValue = *offset
+	backward := cp.cursor != nil && cp.cursor.Backward
+	fullPage := limit != nil && *limit > 0 && count >= *limit
+	var hasNext, hasPrev bool
+	if cp.cursor == nil {
+		hasNext = count != 0 && count < totalCount
+	} else {
+		hasNext = backward || fullPage
+		hasPrev = !backward || fullPage
 	}
-	var nextPageURL *string = nil
-	if nextLimit, nextOffset, ok := nextPage(totalCount, limitValue, offsetValue); ok {
-		url := pageURL(r, nextLimit, nextOffset)
+	var nextPageURL *string
+	if hasNext {
+		values := cp.last
+		if values == nil {
+			values = cp.cursor.Values
+		}
+		url := cursorURL(r, models.NewCursor(cp.sorting, values, false))
 		nextPageURL = &url
 	}
-	var prevPageURL *string = nil
-	if prevLimit, prevOffset, ok := prevPage(totalCount, limitValue, offsetValue); ok {
-		url := pageURL(r, prevLimit, prevOffset)
+	var prevPageURL *string
+	if hasPrev {
+		values := cp.first
+		if values == nil {
+			values = cp.cursor.Values
+		}
+		url := cursorURL(r, models.NewCursor(cp.sorting, values, true))
 		prevPageURL = &url
 	}
+	return nextPageURL, prevPageURL
+}
+
+func mallsPage(malls []*models.Mall, sorting models.Sorting, cursor *models.Cursor) *cursorPage {
+	page := &cursorPage{sorting: sortingOrDefault(sorting, models.DefaultMallSorting), cursor: cursor}
+	if len(malls) != 0 {
+		page.first = malls[0].SortValues(page.sorting)
+		page.last = malls[len(malls)-1].SortValues(page.sorting)
+	}
+	return page
+}
+
+func shopsPage(shops []*models.Shop, sorting models.Sorting, cursor *models.Cursor) *cursorPage {
+	page := &cursorPage{sorting: sortingOrDefault(sorting, models.DefaultShopSorting), cursor: cursor}
+	if len(shops) != 0 {
+		page.first = shops[0].SortValues(page.sorting)
+		page.last = shops[len(shops)-1].SortValues(page.sorting)
+	}
+	return page
+}
+
+func searchResultsPage(results []*models.SearchResult, sorting models.Sorting, cursor *models.Cursor) *cursorPage {
+	page := &cursorPage{sorting: sortingOrDefault(sorting, models.DefaultSearchSorting), cursor: cursor}
+	if len(results) != 0 {
+		page.first = results[0].SortValues(page.sorting)
+		page.last = results[len(results)-1].SortValues(page.sorting)
+	}
+	return page
+}
+
+func paginateResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, resultsList interface{}, totalCount int,
+	limit, offset *int, page *cursorPage) {
+
+	count := reflect.ValueOf(resultsList).Len()
+	var nextPageURL *string = nil
+	var prevPageURL *string = nil
+	if offset != nil {
+		limitValue := totalCount
+		if limit != nil {
+			limitValue = *limit
+		}
+		offsetValue := *offset
+		if nextLimit, nextOffset, ok := nextPage(totalCount, limitValue, offsetValue); ok {
+			url := pageURL(r, nextLimit, nextOffset)
+			nextPageURL = &url
+		}
+		if prevLimit, prevOffset, ok := prevPage(totalCount, limitValue, offsetValue); ok {
+			url := pageURL(r, prevLimit, prevOffset)
+			prevPageURL = &url
+		}
+	} else {
+		nextPageURL, prevPageURL = page.links(r, count, totalCount, limit)
+	}
+	if features, ok := resultsFeatures(resultsList); ok && isGeoJSONRequested(r) {
+		collection := serializers.NewFeatureCollection(features)
+		collection.Pagination = &serializers.GeoJSONPagination{
+			TotalCount: totalCount,
+			Count:      count,
+			Next:       nextPageURL,
+			Prev:       prevPageURL,
+		}
+		geoJSONResponse(ctx, w, collection)
+		return
+	}
 	data := &PaginationData{
 		TotalCount: totalCount,
-		Count:      reflect.ValueOf(resultsList).Len(),
+		Count:      count,
 		Results:    resultsList,
 		Next:       nextPageURL,
 		Prev:       prevPageURL,
@@ -151,7 +284,10 @@ func paginateResponse(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	response(ctx, w, data)
 }
 
-func totalCountFromResults(resultsLen int, limit, offset *int) (int, bool) {
+func totalCountFromResults(resultsLen int, limit, offset *int, cursor *models.Cursor) (int, bool) {
+	if cursor != nil {
+		return 0, false
+	}
 	if (limit == nil || *limit == 0) && (offset == nil || *offset == 0 || resultsLen != 0) {
 		totalCount := resultsLen
 		if offset != nil {
@@ -166,7 +302,7 @@ func checkCity(ctx context.Context, w http.ResponseWriter, cityID *int) bool {
 	if cityID != nil {
 		logger := logging.FromContext(ctx)
 		logger.Info("Check city in db")
-		exists, err := db.IsCityExists(*cityID)
+		exists, err := stores.Cities.IsCityExists(ctx, *cityID)
 		if err != nil {
 			logger.Errorf("Cannot check city: %s", err)
 			internalErrorResponse(w)
@@ -183,7 +319,7 @@ func checkCity(ctx context.Context, w http.ResponseWriter, cityID *int) bool {
 
 func checkShop(ctx context.Context, w http.ResponseWriter, shopID int) bool {
 	logger := logging.FromContext(ctx)
-	exists, err := db.IsShopExists(shopID)
+	exists, err := stores.Shops.IsShopExists(ctx, shopID)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -198,7 +334,7 @@ func checkShop(ctx context.Context, w http.ResponseWriter, shopID int) bool {
 
 func checkSubwayStation(ctx context.Context, w http.ResponseWriter, stationID int) bool {
 	logger := logging.FromContext(ctx)
-	exists, err := db.IsSubwayStationExists(stationID)
+	exists, err := stores.Malls.IsSubwayStationExists(ctx, stationID)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -213,7 +349,7 @@ func checkSubwayStation(ctx context.Context, w http.ResponseWriter, stationID in
 
 func checkCategory(ctx context.Context, w http.ResponseWriter, categoryID int) bool {
 	logger := logging.FromContext(ctx)
-	exists, err := db.IsCategoryExists(categoryID)
+	exists, err := stores.Categories.IsCategoryExists(ctx, categoryID)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -228,7 +364,7 @@ func checkCategory(ctx context.Context, w http.ResponseWriter, categoryID int) b
 
 func checkMall(ctx context.Context, w http.ResponseWriter, mallID int) bool {
 	logger := logging.FromContext(ctx)
-	exists, err := db.IsMallExists(mallID)
+	exists, err := stores.Malls.IsMallExists(ctx, mallID)
 	if err != nil {
 		logger.Error(err)
 		internalErrorResponse(w)
@@ -0,0 +1,147 @@
+package health
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mallfin_api/config"
+	"mallfin_api/logging"
+	"mallfin_api/migrations"
+	"mallfin_api/models"
+	"mallfin_api/redisdb"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCheckTimeout    = time.Second
+	defaultDrain           = 5 * time.Second
+	defaultShutdownTimeout = 15 * time.Second
+)
+
+// set at build time: go build -ldflags "-X mallfin_api/health.Version=1.2.0 -X mallfin_api/health.Commit=$(git rev-parse HEAD)"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+var (
+	startedAt             time.Time
+	expectedSchemaVersion int
+	shuttingDown          int32
+	logger                = logging.WithPackage("health")
+	once                  sync.Once
+)
+
+// Initialization remembers the schema version the code expects, call it after the schema is checked.
+func Initialization() {
+	once.Do(func() {
+		startedAt = time.Now()
+		loaded, err := migrations.Load(migrations.Source())
+		if err != nil {
+			logger.Panicf("Cannot load migrations: %s", err)
+		}
+		expectedSchemaVersion = migrations.LatestVersion(loaded)
+	})
+}
+
+// StartShutdown makes the instance unready, so that the load balancers stop sending requests to it.
+func StartShutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+func checkTimeout() time.Duration {
+	conf := config.Health()
+	if conf == nil || conf.CheckTimeoutMs <= 0 {
+		return defaultCheckTimeout
+	}
+	return time.Duration(conf.CheckTimeoutMs) * time.Millisecond
+}
+
+func DrainPeriod() time.Duration {
+	conf := config.Health()
+	if conf == nil || conf.DrainSeconds <= 0 {
+		return defaultDrain
+	}
+	return time.Duration(conf.DrainSeconds) * time.Second
+}
+
+func ShutdownTimeout() time.Duration {
+	conf := config.Health()
+	if conf == nil || conf.ShutdownTimeoutSeconds <= 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(conf.ShutdownTimeoutSeconds) * time.Second
+}
+
+func Build() *models.BuildInfo {
+	return &models.BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// check gives up waiting after the timeout, the call itself is bounded by the client timeouts.
+func check(name string, timeout time.Duration, fn func() error) *models.DependencyCheck {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = errors.Errorf("no response in %s", timeout)
+	}
+	return &models.DependencyCheck{Name: name, Latency: time.Since(start), Err: err}
+}
+
+// Readiness checks postgres and redis concurrently, nothing is checked while shutting down.
+func Readiness() *models.Readiness {
+	readiness := &models.Readiness{
+		ShuttingDown:          isShuttingDown(),
+		ExpectedSchemaVersion: expectedSchemaVersion,
+		Build:                 Build(),
+		StartedAt:             startedAt,
+	}
+	if readiness.ShuttingDown {
+		return readiness
+	}
+	timeout := checkTimeout()
+	var wg sync.WaitGroup
+	var postgresCheck, redisCheck *models.DependencyCheck
+	// written before the check returns, so it is read only if the check did not time out
+	var schemaVersion int
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// the schema version query is the postgres check
+		postgresCheck = check("postgres", timeout, func() error {
+			var err error
+			schemaVersion, err = migrations.DatabaseVersion()
+			return err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		redisCheck = check("redis", timeout, func() error {
+			return redisdb.GetClient().Ping().Err()
+		})
+	}()
+	wg.Wait()
+	if postgresCheck.Err == nil {
+		readiness.SchemaVersion = schemaVersion
+	}
+	readiness.Checks = []*models.DependencyCheck{postgresCheck, redisCheck}
+	return readiness
+}
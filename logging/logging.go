@@ -1,12 +1,15 @@
 package logging
 
 import (
+	"io"
 	"mallfin_api/config"
+	"os"
 	"strings"
 
 	"context"
 
 	log "github.com/Sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type ContextKey int
@@ -15,25 +18,51 @@ const (
 	ServiceNameField = "service_name"
 	ServerIDField    = "server_id"
 	RequestIDField   = "request_id"
+	TraceIDField     = "trace_id"
 	PackageField     = "package"
 
 	loggerCtxKey = ContextKey(1)
 )
 
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
 type customFormatter struct {
 	logFormatter     log.Formatter
 	additionalFields log.Fields
+	defaultLevel     log.Level
+	// package name -> level
+	packageLevels map[string]log.Level
 }
 
 func WithPackage(packageName string) *log.Entry {
 	return log.WithField(PackageField, packageName)
 }
 
+// Format skips the entries below the level of their package, the logger level is the most verbose of them.
+// The entry is copied, so that its fields are not changed for the other formatting calls.
 func (cf *customFormatter) Format(e *log.Entry) ([]byte, error) {
+	level := cf.defaultLevel
+	if packageName, ok := e.Data[PackageField].(string); ok {
+		if packageLevel, ok := cf.packageLevels[packageName]; ok {
+			level = packageLevel
+		}
+	}
+	if e.Level > level {
+		return nil, nil
+	}
+	data := make(log.Fields, len(e.Data)+len(cf.additionalFields))
 	for field, value := range cf.additionalFields {
-		e.Data[field] = value
+		data[field] = value
 	}
-	return cf.logFormatter.Format(e)
+	for field, value := range e.Data {
+		data[field] = value
+	}
+	entry := *e
+	entry.Data = data
+	return cf.logFormatter.Format(&entry)
 }
 
 func FromContext(ctx context.Context) *log.Entry {
@@ -48,24 +77,63 @@ func NewContext(ctx context.Context, logger *log.Entry) context.Context {
 	return context.WithValue(ctx, loggerCtxKey, logger)
 }
 
-func Initialization() {
-	var logLevel log.Level
-	switch strings.ToLower(config.LogLevel()) {
+func parseLevel(name string) log.Level {
+	switch strings.ToLower(name) {
 	case "debug":
-		logLevel = log.DebugLevel
+		return log.DebugLevel
 	case "info":
-		logLevel = log.InfoLevel
+		return log.InfoLevel
 	case "warning":
-		logLevel = log.WarnLevel
+		return log.WarnLevel
 	case "error":
-		logLevel = log.ErrorLevel
+		return log.ErrorLevel
 	default:
-		logLevel = log.DebugLevel
+		return log.DebugLevel
+	}
+}
+
+func newOutput(conf *config.LoggingSettings) io.Writer {
+	if conf.File == "" {
+		return os.Stderr
+	}
+	return &lumberjack.Logger{
+		Filename:   conf.File,
+		MaxSize:    conf.MaxSizeMB,
+		MaxBackups: conf.MaxBackups,
+		MaxAge:     conf.MaxAgeDays,
+	}
+}
+
+func Initialization() {
+	conf := config.Logging()
+	if conf == nil {
+		conf = &config.LoggingSettings{}
+	}
+	var logFormatter log.Formatter
+	switch strings.ToLower(conf.Format) {
+	case JSONFormat:
+		logFormatter = &log.JSONFormatter{}
+	default:
+		logFormatter = &log.TextFormatter{}
+	}
+	formatter := &customFormatter{
+		logFormatter: logFormatter,
+		additionalFields: log.Fields{
+			ServiceNameField: config.ServiceName(),
+			ServerIDField:    config.ServerID(),
+		},
+		defaultLevel:  parseLevel(config.LogLevel()),
+		packageLevels: make(map[string]log.Level, len(conf.Levels)),
+	}
+	loggerLevel := formatter.defaultLevel
+	for packageName, levelName := range conf.Levels {
+		level := parseLevel(levelName)
+		formatter.packageLevels[packageName] = level
+		if level > loggerLevel {
+			loggerLevel = level
+		}
 	}
-	formatter := &customFormatter{logFormatter: &log.TextFormatter{}, additionalFields: log.Fields{
-		ServiceNameField: config.ServiceName(),
-		ServerIDField:    config.ServerID(),
-	}}
-	log.SetLevel(logLevel)
+	log.SetLevel(loggerLevel)
 	log.SetFormatter(formatter)
+	log.SetOutput(newOutput(conf))
 }
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"mallfin_api/cache"
 	"mallfin_api/config"
 	"mallfin_api/db"
 	"mallfin_api/handlers"
+	"mallfin_api/health"
+	"mallfin_api/metrics"
+	"mallfin_api/migrations"
 	"mallfin_api/redisdb"
+	"mallfin_api/tracing"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"mallfin_api/logging"
 	"mallfin_api/middlewares"
@@ -21,37 +31,103 @@ var logger = logging.WithPackage("main")
 
 func main() {
 	var configPath string
+	var migrateCommand string
 	flag.StringVar(&configPath, "conf", "", "Path to json config file.")
+	flag.StringVar(&migrateCommand, "migrate", "", "Run schema migrations (up, down or status) and exit.")
 	flag.Parse()
 
 	config.Initialization(configPath)
 	logging.Initialization()
+	metrics.Initialization()
+	tracing.Initialization()
+	defer tracing.Close()
+
+	db.Initialization()
+	defer db.Close()
+
+	if migrateCommand != "" {
+		err := migrations.Run(migrateCommand)
+		if err != nil {
+			logger.Panicf("Cannot run migrations: %s", err)
+		}
+		return
+	}
+	err := migrations.CheckSchema()
+	if err != nil {
+		logger.Panicf("Refusing to serve: %s", err)
+	}
+	health.Initialization()
 
 	redisdb.Initialization()
 	defer redisdb.Close()
+	cache.Initialization(cache.NewRedisStorage())
 
-	db.Initialization()
-	defer db.Close()
+	store := db.NewPostgresStore()
+	handlers.Initialization(&handlers.Stores{
+		Malls:      store,
+		Shops:      store,
+		Categories: store,
+		Cities:     store,
+		Search:     store,
+		Suggest:    store,
+
+		SubwayStations: store,
+		FloorPlans:     store,
+	})
 
 	r := httprouter.New()
+	r.GET("/healthz", handlers.Healthz)
+	r.GET("/readyz", handlers.Readyz)
 	r.GET("/malls/", handlers.MallsList)
 	r.GET("/malls/:id/", handlers.MallDetails)
+	r.GET("/malls/:id/directory/", handlers.MallDirectory)
+	r.GET("/malls/:id/floors/", handlers.MallFloors)
+	r.GET("/malls/:id/floors/:floor/", handlers.FloorPlan)
+	r.GET("/malls/:id/floors/:floor/unit/", handlers.FloorUnitAt)
+	r.GET("/mall_clusters/", handlers.MallClusters)
+	r.POST("/malls/", handlers.AdminOnly(handlers.CreateMall))
+	r.PUT("/malls/:id/", handlers.AdminOnly(handlers.ReplaceMall))
+	r.PATCH("/malls/:id/", handlers.AdminOnly(handlers.UpdateMall))
+	r.DELETE("/malls/:id/", handlers.AdminOnly(handlers.DeleteMall))
 	r.GET("/current_mall/", handlers.CurrentMall)
 	r.GET("/current_city/", handlers.CurrentCity)
 	r.GET("/shops_in_malls/", handlers.ShopsInMalls)
 	r.GET("/search/", handlers.Search)
+	r.GET("/plan/", handlers.Plan)
 	r.GET("/shops/", handlers.ShopsList)
 	r.GET("/shops/:id/", handlers.ShopDetails)
+	r.POST("/shops/", handlers.AdminOnly(handlers.CreateShop))
+	r.PUT("/shops/:id/", handlers.AdminOnly(handlers.ReplaceShop))
+	r.PATCH("/shops/:id/", handlers.AdminOnly(handlers.UpdateShop))
+	r.DELETE("/shops/:id/", handlers.AdminOnly(handlers.DeleteShop))
+	r.POST("/shops/:id/malls/", handlers.AdminOnly(handlers.ChangeShopMalls))
+	r.PUT("/shops/:id/malls/:mall_id/", handlers.AdminOnly(handlers.AttachShopMall))
+	r.DELETE("/shops/:id/malls/:mall_id/", handlers.AdminOnly(handlers.DetachShopMall))
+	r.PUT("/shops/:id/malls/:mall_id/placement/", handlers.AdminOnly(handlers.SetShopPlacement))
+	r.POST("/shops/:id/categories/", handlers.AdminOnly(handlers.ChangeShopCategories))
+	r.PUT("/shops/:id/categories/:category_id/", handlers.AdminOnly(handlers.AttachShopCategory))
+	r.DELETE("/shops/:id/categories/:category_id/", handlers.AdminOnly(handlers.DetachShopCategory))
 	r.GET("/categories/", handlers.CategoriesList)
 	r.GET("/categories/:id/", handlers.CategoryDetails)
 	r.GET("/cities/", handlers.CitiesList)
+	r.GET("/subway_stations/", handlers.SubwayStationsList)
+	r.GET("/subway_stations/:id/", handlers.SubwayStationDetails)
+	r.GET("/nearest_subway_station/", handlers.NearestSubwayStation)
+	r.GET("/suggest/", handlers.Suggest)
+	r.Handler(http.MethodGet, "/metrics", metrics.Handler())
+	r.GET("/debug/slow_queries/", handlers.AdminOnly(handlers.SlowQueries))
 
 	n := negroni.New()
+	n.Use(middlewares.RouteMiddleware(r))
+	// outside of the recovery, so that the recovered panics are counted and traced with their status
+	n.UseFunc(middlewares.MetricsMiddleware)
+	n.UseFunc(middlewares.TracingMiddleware)
 	n.UseFunc(middlewares.RecoveryMiddleware)
 	//c := cors.New(cors.Options{AllowedOrigins: []string{"*"}})
 	//n.Use(c)
-	n.UseFunc(middlewares.TracingMiddleware)
 	n.UseFunc(middlewares.LoggerMiddleware)
+	n.UseFunc(middlewares.FormatMiddleware)
+	n.UseFunc(middlewares.CacheMiddleware)
 	n.UseHandler(r)
 	if config.Debug() {
 		go func() {
@@ -61,9 +137,28 @@ func main() {
 			}
 		}()
 	}
-	logger.Infof("Starting server on port %d", config.Port())
-	err := http.ListenAndServe(fmt.Sprintf(":%d", config.Port()), n)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", config.Port()), Handler: n}
+	go func() {
+		logger.Infof("Starting server on port %d", config.Port())
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Panicf("Cannot run server: %s", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	// /readyz fails from now on, the load balancers stop sending requests while the server still serves them
+	health.StartShutdown()
+	logger.Infof("Got %s, draining for %s", sig, health.DrainPeriod())
+	time.Sleep(health.DrainPeriod())
+
+	ctx, cancel := context.WithTimeout(context.Background(), health.ShutdownTimeout())
+	defer cancel()
+	err = server.Shutdown(ctx)
 	if err != nil {
-		logger.Panicf("Cannot run server: %s", err)
+		logger.Errorf("Cannot finish in-flight requests: %s", err)
 	}
+	logger.Info("Server stopped")
 }
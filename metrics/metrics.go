@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mallfin"
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of the finished requests by route template, method and status.",
+	}, []string{"route", "method", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Request latency by route template, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	requestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of the requests being served.",
+	})
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Postgres query latency by the name of the db function.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Number of the failed postgres queries by the name of the db function.",
+	}, []string{"query"})
+	once sync.Once
+)
+
+// PoolStats is the part of the go-pg and redis pool stats that is exported.
+type PoolStats struct {
+	Requests   uint32
+	Hits       uint32
+	Timeouts   uint32
+	TotalConns uint32
+	FreeConns  uint32
+}
+
+func Initialization() {
+	once.Do(func() {
+		prometheus.MustRegister(requestsTotal, requestDuration, requestsInFlight, queryDuration, queryErrors)
+	})
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func RequestStarted() {
+	requestsInFlight.Inc()
+}
+
+func RequestFinished(route, method string, status int, duration time.Duration) {
+	requestsInFlight.Dec()
+	statusLabel := strconv.Itoa(status)
+	requestsTotal.WithLabelValues(route, method, statusLabel).Inc()
+	requestDuration.WithLabelValues(route, method, statusLabel).Observe(duration.Seconds())
+}
+
+func ObserveQuery(queryName string, duration time.Duration, failed bool) {
+	queryDuration.WithLabelValues(queryName).Observe(duration.Seconds())
+	if failed {
+		queryErrors.WithLabelValues(queryName).Inc()
+	}
+}
+
+// RegisterPool exposes the connection pool of a client, stats are read on every scrape.
+func RegisterPool(pool string, stats func() *PoolStats) {
+	labels := prometheus.Labels{"pool": pool}
+	counter := func(name, help string, value func(*PoolStats) uint32) prometheus.Collector {
+		opts := prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help, ConstLabels: labels}
+		return prometheus.NewCounterFunc(opts, func() float64 { return float64(value(stats())) })
+	}
+	gauge := func(name, help string, value func(*PoolStats) uint32) prometheus.Collector {
+		opts := prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help, ConstLabels: labels}
+		return prometheus.NewGaugeFunc(opts, func() float64 { return float64(value(stats())) })
+	}
+	prometheus.MustRegister(
+		counter("pool_requests_total", "Number of the connection requests to the pool.",
+			func(s *PoolStats) uint32 { return s.Requests }),
+		counter("pool_hits_total", "Number of the times a free connection was found in the pool.",
+			func(s *PoolStats) uint32 { return s.Hits }),
+		counter("pool_timeouts_total", "Number of the times waiting for a connection timed out.",
+			func(s *PoolStats) uint32 { return s.Timeouts }),
+		gauge("pool_connections", "Number of the connections in the pool.",
+			func(s *PoolStats) uint32 { return s.TotalConns }),
+		gauge("pool_free_connections", "Number of the idle connections in the pool.",
+			func(s *PoolStats) uint32 { return s.FreeConns }),
+	)
+}
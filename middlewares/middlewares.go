@@ -1,12 +1,21 @@
 package middlewares
 
 import (
+	"bytes"
+	"context"
+	"mallfin_api/cache"
 	"mallfin_api/logging"
+	"mallfin_api/metrics"
+	"mallfin_api/serializers"
 	"mallfin_api/tracing"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/gazoon/httprouter"
 	"github.com/urfave/negroni"
 )
 
@@ -23,19 +32,104 @@ func RecoveryMiddleware(w http.ResponseWriter, r *http.Request, next http.Handle
 	next(w, r)
 }
 
+// RouteMiddleware finds the route template of the request once for the metrics, the spans and the logs.
+func RouteMiddleware(router *httprouter.Router) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		route := routeTemplate(router, r.Method, r.URL.Path)
+		ctx := context.WithValue(r.Context(), routeCtxKey, route)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func routeFromContext(ctx context.Context) string {
+	route, ok := ctx.Value(routeCtxKey).(string)
+	if !ok {
+		return unmatchedRoute
+	}
+	return route
+}
+
+// routeTemplate puts the param names back in place of their values, e.g. /malls/5/floors/2/ -> /malls/:id/floors/:floor/.
+func routeTemplate(router *httprouter.Router, method, path string) string {
+	handle, params, _ := router.Lookup(method, path)
+	if handle == nil {
+		return unmatchedRoute
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(params) == 0 {
+			break
+		}
+		if segment == params[0].Value {
+			segments[i] = ":" + params[0].Key
+			params = params[1:]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// MetricsMiddleware labels the requests with the route templates instead of the raw paths.
+func MetricsMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	route := routeFromContext(r.Context())
+	metrics.RequestStarted()
+
+	next(w, r)
+
+	metrics.RequestFinished(route, r.Method, responseStatus(w), time.Since(start))
+}
+
+// TracingMiddleware starts the server span of the request, the spans are named by the route templates.
 func TracingMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	requestID := tracing.InitializeHeaders(w, r)
-	ctx := tracing.NewContext(r.Context(), requestID)
+	r = r.WithContext(tracing.NewContext(r.Context(), requestID))
+	ctx, span := tracing.StartServerSpan(r, routeFromContext(r.Context()))
+
 	next(w, r.WithContext(ctx))
+
+	tracing.EndServerSpan(span, responseStatus(w))
+}
+
+// nothing written means the implicit 200
+func responseStatus(w http.ResponseWriter) int {
+	status := w.(negroni.ResponseWriter).Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status
+}
+
+// redactQuery hides the coordinates of the user, the rest of the params are logged as is.
+func redactQuery(query url.Values) url.Values {
+	redacted := make(url.Values, len(query))
+	for param, values := range query {
+		if redactedParams[param] {
+			values = []string{redactedValue}
+		}
+		redacted[param] = values
+	}
+	return redacted
 }
 
 func LoggerMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
 	ctx := r.Context()
 	requestID := tracing.FromContext(ctx)
-	logger := log.WithFields(log.Fields{logging.RequestIDField: requestID})
+	// the request logger is what the handlers log with, so the handlers level applies to it
+	logger := log.WithFields(log.Fields{logging.RequestIDField: requestID, logging.PackageField: "handlers"})
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		logger = logger.WithField(logging.TraceIDField, traceID)
+	}
 	ctx = logging.NewContext(ctx, logger)
 
-	logger = logger.WithFields(log.Fields{"path": r.URL.Path, "method": r.Method})
+	logger = logger.WithFields(log.Fields{
+		logging.PackageField: "middlewares",
+		"path":               r.URL.Path,
+		"method":             r.Method,
+		"route":              routeFromContext(ctx),
+		// before the inner middlewares add their params
+		"query": redactQuery(r.URL.Query()),
+	})
 
 	userIP := r.Header.Get("X-Real-IP")
 	if userIP == "" {
@@ -46,5 +140,141 @@ func LoggerMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerF
 	next(w, r.WithContext(ctx))
 
 	res := w.(negroni.ResponseWriter)
-	logger.WithField("status", res.Status()).Info("Request finished")
+	logger.WithFields(log.Fields{
+		"status":        responseStatus(w),
+		"response_size": res.Size(),
+		"latency_ms":    float64(time.Since(start)) / float64(time.Millisecond),
+	}).Info("Request finished")
+}
+
+// FormatMiddleware turns Accept: application/geo+json into the format param,
+// so that handlers and the response cache have to look only at the query.
+func FormatMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	w.Header().Add("Vary", "Accept")
+	query := r.URL.Query()
+	if query.Get("format") == "" && acceptsGeoJSON(r.Header.Get("Accept")) {
+		query.Set("format", serializers.GeoJSONFormat)
+		r.URL.RawQuery = query.Encode()
+	}
+	next(w, r)
+}
+
+func acceptsGeoJSON(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.Split(mediaRange, ";")[0])
+		if strings.EqualFold(mediaType, serializers.GeoJSONContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+const cacheHeader = "X-Cache"
+
+type contextKey int
+
+const (
+	routeCtxKey = contextKey(1)
+	// requests that did not match any route share one route, so the raw paths do not blow up the metrics
+	unmatchedRoute = "unmatched"
+	redactedValue  = "REDACTED"
+)
+
+// the coordinates of the user and of the route they are going along
+var redactedParams = map[string]bool{
+	"location_lat": true,
+	"location_lon": true,
+	"from_lat":     true,
+	"from_lon":     true,
+	"to_lat":       true,
+	"to_lon":       true,
+	"near_lat":     true,
+	"near_lon":     true,
+	"route":        true,
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func writeCached(w http.ResponseWriter, response *cache.Response) {
+	w.Header().Set("Content-Type", response.ContentType)
+	w.Header().Set(cacheHeader, "HIT")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response.Body)
+}
+
+func CacheMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method != http.MethodGet {
+		next(w, r)
+		return
+	}
+	entry := cache.Lookup(r.URL.Path, r.URL.Query())
+	if entry == nil {
+		next(w, r)
+		return
+	}
+	logger := logging.FromContext(r.Context()).WithField("cache_key", entry.Key)
+	w.Header().Set(cacheHeader, "MISS")
+	cached, err := entry.Get(r.Context())
+	if err != nil {
+		logger.Error(err)
+		next(w, r)
+		return
+	}
+	if cached != nil {
+		writeCached(w, cached)
+		return
+	}
+	mutex := entry.Mutex()
+	err = mutex.Lock(r.Context())
+	if err != nil {
+		logger.Errorf("Cannot lock cache entry: %s", err)
+		next(w, r)
+		return
+	}
+	defer func() {
+		err := mutex.Unlock(r.Context())
+		if err != nil {
+			logger.Errorf("Cannot unlock cache entry: %s", err)
+		}
+	}()
+	cached, err = entry.Get(r.Context())
+	if err != nil {
+		logger.Error(err)
+	} else if cached != nil {
+		writeCached(w, cached)
+		return
+	}
+	// an invalidation while the response is built makes it stale, the versions tell Set about it
+	versions, err := entry.Versions(r.Context())
+	if err != nil {
+		logger.Error(err)
+		next(w, r)
+		return
+	}
+	rw := &recordingWriter{ResponseWriter: w}
+	next(rw, r)
+	if rw.status != http.StatusOK {
+		return
+	}
+	err = entry.Set(r.Context(), &cache.Response{ContentType: rw.Header().Get("Content-Type"), Body: rw.body.Bytes()}, versions)
+	if err != nil {
+		logger.Error(err)
+	}
 }
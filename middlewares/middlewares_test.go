@@ -0,0 +1,213 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"mallfin_api/metrics"
+	"mallfin_api/tracing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gazoon/httprouter"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestRouter() *httprouter.Router {
+	router := httprouter.New()
+	ok := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {}
+	router.GET("/malls/:id/", ok)
+	router.GET("/malls/:id/floors/:floor/", ok)
+	router.GET("/shops/:id/malls/:mall_id/", ok)
+	router.GET("/search/", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	return router
+}
+
+// serve runs the request through the route lookup and the given middlewares in the order of main.
+func serve(router *httprouter.Router, target string, handlers ...negroni.HandlerFunc) *httptest.ResponseRecorder {
+	n := negroni.New()
+	n.Use(RouteMiddleware(router))
+	for _, handler := range handlers {
+		n.Use(handler)
+	}
+	n.UseHandler(router)
+	w := httptest.NewRecorder()
+	n.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestRouteTemplate(t *testing.T) {
+	router := newTestRouter()
+	for _, c := range []struct {
+		path  string
+		route string
+	}{
+		{"/malls/5/", "/malls/:id/"},
+		{"/malls/5/floors/2/", "/malls/:id/floors/:floor/"},
+		// the same value of two params is replaced in order
+		{"/shops/3/malls/3/", "/shops/:id/malls/:mall_id/"},
+		{"/search/", "/search/"},
+		{"/malls/", unmatchedRoute},
+		{"/unknown/5/", unmatchedRoute},
+	} {
+		route := routeTemplate(router, http.MethodGet, c.path)
+		if route != c.route {
+			t.Errorf("%s: route %s, expected %s", c.path, route, c.route)
+		}
+	}
+	if route := routeTemplate(router, http.MethodPost, "/malls/5/"); route != unmatchedRoute {
+		t.Errorf("POST /malls/5/: route %s, expected %s", route, unmatchedRoute)
+	}
+}
+
+func TestMetricsRouteLabels(t *testing.T) {
+	metrics.Initialization()
+	router := newTestRouter()
+	for _, target := range []string{"/malls/5/", "/malls/6/?fields=name", "/malls/5/floors/2/", "/search/", "/unknown/5/", "/unknown/6/"} {
+		serve(router, target, MetricsMiddleware)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	scraped := string(body)
+	for _, line := range []string{
+		`mallfin_http_requests_total{method="GET",route="/malls/:id/",status="200"} 2`,
+		`mallfin_http_requests_total{method="GET",route="/malls/:id/floors/:floor/",status="200"} 1`,
+		`mallfin_http_requests_total{method="GET",route="/search/",status="400"} 1`,
+		`mallfin_http_requests_total{method="GET",route="unmatched",status="404"} 2`,
+		`mallfin_http_request_duration_seconds_count{method="GET",route="/malls/:id/",status="200"} 2`,
+		`mallfin_http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(scraped, line+"\n") {
+			t.Errorf("/metrics has no %s", line)
+		}
+	}
+	// the raw paths must not become the label values
+	for _, path := range []string{`route="/malls/5/"`, `route="/malls/6/"`, `route="/unknown/5/"`} {
+		if strings.Contains(scraped, path) {
+			t.Errorf("/metrics has the raw path %s", path)
+		}
+	}
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	router := newTestRouter()
+	requestIDs := []string{}
+	for _, target := range []string{"/malls/5/floors/2/", "/search/", "/unknown/5/"} {
+		w := serve(router, target, TracingMiddleware)
+		requestIDs = append(requestIDs, w.Header().Get(tracing.RequestIDHeader))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans ended, expected 3", len(spans))
+	}
+	for i, expected := range []struct {
+		name   string
+		status int64
+	}{
+		{"GET /malls/:id/floors/:floor/", http.StatusOK},
+		{"GET /search/", http.StatusBadRequest},
+		{"GET unmatched", http.StatusNotFound},
+	} {
+		span := spans[i]
+		attributes := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attributes[kv.Key] = kv.Value
+		}
+		if span.Name() != expected.name || attributes["http.status_code"].AsInt64() != expected.status {
+			t.Errorf("span %d is %s with status %d, expected %s with %d",
+				i, span.Name(), attributes["http.status_code"].AsInt64(), expected.name, expected.status)
+		}
+		// the logs and the spans of a request are joined by its id
+		if requestIDs[i] == "" || attributes["request_id"].AsString() != requestIDs[i] {
+			t.Errorf("span %d has request id %q, the response has %q", i, attributes["request_id"].AsString(), requestIDs[i])
+		}
+	}
+}
+
+// captureLogs writes the log lines of the test as json to the returned buffer.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	logger := log.StandardLogger()
+	output, formatter, level := logger.Out, logger.Formatter, logger.Level
+	buffer := &bytes.Buffer{}
+	log.SetOutput(buffer)
+	log.SetFormatter(&log.JSONFormatter{})
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFormatter(formatter)
+		log.SetLevel(level)
+	})
+	return buffer
+}
+
+// the encoded polyline of a route along three points
+const testPolyline = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+
+func TestRequestLogRedaction(t *testing.T) {
+	logs := captureLogs(t)
+	router := newTestRouter()
+	query := "?query=zara&city=1&location_lat=55.7512&location_lon=37.6184" +
+		"&from_lat=55.7001&from_lon=37.5002&to_lat=55.8003&to_lon=37.7004&near_lat=55.7605&near_lon=37.6206" +
+		"&route=" + url.QueryEscape(testPolyline)
+	serve(router, "/search/"+query, LoggerMiddleware)
+
+	for _, coordinate := range []string{"55.7512", "37.6184", "55.7001", "37.5002", "55.8003", "37.7004", "55.7605", "37.6206", "_p~iF~ps|U"} {
+		if strings.Contains(logs.String(), coordinate) {
+			t.Errorf("the coordinate %s is logged: %s", coordinate, logs)
+		}
+	}
+	var finished map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		entry := map[string]interface{}{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("cannot decode log line %s: %s", line, err)
+		}
+		if entry["msg"] == "Request finished" {
+			finished = entry
+		}
+	}
+	if finished == nil {
+		t.Fatalf("no request finished line in %s", logs)
+	}
+	for field, expected := range map[string]interface{}{
+		"route":  "/search/",
+		"path":   "/search/",
+		"status": float64(http.StatusBadRequest),
+	} {
+		if finished[field] != expected {
+			t.Errorf("request log %s is %v, expected %v", field, finished[field], expected)
+		}
+	}
+	for _, field := range []string{"latency_ms", "response_size"} {
+		if _, ok := finished[field]; !ok {
+			t.Errorf("request log has no %s", field)
+		}
+	}
+	// the rest of the params are kept as is
+	loggedQuery, _ := json.Marshal(finished["query"])
+	expectedQuery := `{"city":["1"],"from_lat":["REDACTED"],"from_lon":["REDACTED"],"location_lat":["REDACTED"],"location_lon":["REDACTED"],` +
+		`"near_lat":["REDACTED"],"near_lon":["REDACTED"],"query":["zara"],"route":["REDACTED"],"to_lat":["REDACTED"],"to_lon":["REDACTED"]}`
+	if string(loggedQuery) != expectedQuery {
+		t.Errorf("request log query is %s, expected %s", loggedQuery, expectedQuery)
+	}
+}
@@ -0,0 +1,324 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"mallfin_api/config"
+	"mallfin_api/db"
+	"mallfin_api/logging"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+)
+
+const (
+	upCommand     = "up"
+	downCommand   = "down"
+	statusCommand = "status"
+	// arbitrary key that serializes concurrent migration runs
+	advisoryLockKey = 228
+)
+
+var (
+	logger        = logging.WithPackage("migrations")
+	fileNameRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Source is the migrations embedded in the binary, so that it does not depend on the working directory,
+// unless migrations_dir in the config points to another dir.
+func Source() fs.FS {
+	dir := config.MigrationsDir()
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	source, err := fs.Sub(embedded, "sql")
+	if err != nil {
+		panic(err)
+	}
+	return source
+}
+
+func Load(source fs.FS) ([]*Migration, error) {
+	files, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read migrations dir")
+	}
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		matches := fileNameRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(matches[1])
+		name := matches[2]
+		direction := matches[3]
+		data, err := fs.ReadFile(source, file.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read migration %s", file.Name())
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, errors.Errorf("Migration %d has different names: %s and %s", version, migration.Name, name)
+		}
+		if direction == upCommand {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, errors.Errorf("Migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func LatestVersion(migrations []*Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func ensureVersionTable() error {
+	client := db.GetClient()
+	_, err := client.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+	  version    INTEGER PRIMARY KEY,
+	  name       TEXT        NOT NULL,
+	  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+	`)
+	if err != nil {
+		return errors.Wrap(err, "cannot create schema_version table")
+	}
+	return nil
+}
+
+func Applied() ([]*AppliedMigration, error) {
+	err := ensureVersionTable()
+	if err != nil {
+		return nil, err
+	}
+	client := db.GetClient()
+	var applied []*AppliedMigration
+	_, err = client.Query(&applied, `
+	SELECT
+	  version,
+	  name,
+	  applied_at
+	FROM schema_version
+	ORDER BY version
+	`)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get applied migrations")
+	}
+	return applied, nil
+}
+
+func CurrentVersion() (int, error) {
+	applied, err := Applied()
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// DatabaseVersion is CurrentVersion for the startup and health checks, it only reads,
+// so that the app role needs no DDL rights. A database without the version table is at version 0.
+func DatabaseVersion() (int, error) {
+	client := db.GetClient()
+	result := struct{ Exists bool }{}
+	_, err := client.QueryOne(&result, `SELECT to_regclass('schema_version') IS NOT NULL AS exists`)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot check schema_version table")
+	}
+	if !result.Exists {
+		return 0, nil
+	}
+	var version int
+	_, err = client.QueryOne(pg.Scan(&version), `
+	SELECT coalesce(max(version), 0)
+	FROM schema_version
+	`)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot get schema version")
+	}
+	return version, nil
+}
+
+func Up(migrations []*Migration) error {
+	current, err := CurrentVersion()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		logger.Infof("Applying migration %d_%s", migration.Version, migration.Name)
+		err = runInTransaction(func(tx *pg.Tx) error {
+			result := struct{ Exists bool }{}
+			_, err := tx.QueryOne(&result, `SELECT exists(SELECT * FROM schema_version WHERE version = ?0)`, migration.Version)
+			if err != nil || result.Exists {
+				return err
+			}
+			_, err = tx.Exec(migration.Up)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO schema_version (version, name) VALUES (?0, ?1)`, migration.Version, migration.Name)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "cannot apply migration %d_%s", migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+func Down(migrations []*Migration) error {
+	current, err := CurrentVersion()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		logger.Info("Nothing to roll back")
+		return nil
+	}
+	var migration *Migration
+	for _, m := range migrations {
+		if m.Version == current {
+			migration = m
+		}
+	}
+	if migration == nil {
+		return errors.Errorf("Cannot find migration for version %d", current)
+	}
+	if migration.Down == "" {
+		return errors.Errorf("Migration %d_%s is irreversible", migration.Version, migration.Name)
+	}
+	logger.Infof("Rolling back migration %d_%s", migration.Version, migration.Name)
+	err = runInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.Exec(migration.Down)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM schema_version WHERE version = ?0`, migration.Version)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot roll back migration %d_%s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+func Status(migrations []*Migration) (string, error) {
+	applied, err := Applied()
+	if err != nil {
+		return "", err
+	}
+	appliedAt := map[int]time.Time{}
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+	var status string
+	for _, migration := range migrations {
+		state := "pending"
+		if t, ok := appliedAt[migration.Version]; ok {
+			state = "applied at " + t.Format(time.RFC3339)
+		}
+		status += fmt.Sprintf("%04d_%s\t%s\n", migration.Version, migration.Name, state)
+	}
+	return status, nil
+}
+
+func Run(command string) error {
+	migrations, err := Load(Source())
+	if err != nil {
+		return err
+	}
+	switch command {
+	case upCommand:
+		return Up(migrations)
+	case downCommand:
+		return Down(migrations)
+	case statusCommand:
+		status, err := Status(migrations)
+		if err != nil {
+			return err
+		}
+		fmt.Print(status)
+		return nil
+	default:
+		return errors.Errorf("Unknown migrate command %s, valid values: up, down, status", command)
+	}
+}
+
+func CheckSchema() error {
+	migrations, err := Load(Source())
+	if err != nil {
+		return err
+	}
+	current, err := DatabaseVersion()
+	if err != nil {
+		return err
+	}
+	latest := LatestVersion(migrations)
+	if current < latest {
+		return errors.Errorf("Database schema version %d is behind %d, run with -migrate up", current, latest)
+	}
+	return nil
+}
+
+func runInTransaction(fn func(tx *pg.Tx) error) error {
+	client := db.GetClient()
+	tx, err := client.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock(?0)`, advisoryLockKey)
+	if err == nil {
+		err = fn(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
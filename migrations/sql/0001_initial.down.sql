@@ -0,0 +1,10 @@
+DROP TABLE mall_shop;
+DROP TABLE shop_category;
+DROP TABLE category;
+DROP TABLE shop_name;
+DROP TABLE shop;
+DROP TABLE mall_working_hours;
+DROP TABLE mall_name;
+DROP TABLE mall;
+DROP TABLE subway_station;
+DROP TABLE city;
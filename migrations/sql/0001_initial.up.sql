@@ -0,0 +1,93 @@
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE city (
+  city_id       SERIAL PRIMARY KEY,
+  city_name     TEXT                  NOT NULL,
+  city_location GEOMETRY(Point, 4326) NOT NULL,
+  city_radius   DOUBLE PRECISION      NOT NULL
+);
+CREATE INDEX city_location_idx ON city USING GIST (city_location);
+
+CREATE TABLE subway_station (
+  station_id   SERIAL PRIMARY KEY,
+  station_name TEXT    NOT NULL,
+  city_id      INTEGER NOT NULL REFERENCES city (city_id) ON DELETE CASCADE
+);
+CREATE INDEX subway_station_city_id_idx ON subway_station (city_id);
+
+CREATE TABLE mall (
+  mall_id           SERIAL PRIMARY KEY,
+  mall_name         TEXT                  NOT NULL,
+  mall_phone        TEXT                  NOT NULL DEFAULT '',
+  mall_logo_small   TEXT                  NOT NULL DEFAULT '',
+  mall_logo_large   TEXT                  NOT NULL DEFAULT '',
+  mall_location     GEOMETRY(Point, 4326) NOT NULL,
+  -- radius in meters, used to detect that a user is inside the mall
+  mall_radius       DOUBLE PRECISION      NOT NULL,
+  mall_site         TEXT                  NOT NULL DEFAULT '',
+  address           TEXT                  NOT NULL DEFAULT '',
+  day_and_night     BOOLEAN               NOT NULL DEFAULT FALSE,
+  shops_count       INTEGER               NOT NULL DEFAULT 0,
+  city_id           INTEGER               NOT NULL REFERENCES city (city_id),
+  subway_station_id INTEGER REFERENCES subway_station (station_id) ON DELETE SET NULL
+);
+CREATE INDEX mall_city_id_idx ON mall (city_id);
+CREATE INDEX mall_subway_station_id_idx ON mall (subway_station_id);
+CREATE INDEX mall_location_idx ON mall USING GIST (mall_location);
+
+-- all names of a mall including the main one, used by the name search
+CREATE TABLE mall_name (
+  mall_id   INTEGER NOT NULL REFERENCES mall (mall_id) ON DELETE CASCADE,
+  mall_name TEXT    NOT NULL,
+  PRIMARY KEY (mall_id, mall_name)
+);
+
+-- days are numbered from 0 (monday) to 6 (sunday), a period may close on the next day
+CREATE TABLE mall_working_hours (
+  mall_id    INTEGER  NOT NULL REFERENCES mall (mall_id) ON DELETE CASCADE,
+  open_day   SMALLINT NOT NULL CHECK (open_day BETWEEN 0 AND 6),
+  open_time  TIME     NOT NULL,
+  close_day  SMALLINT NOT NULL CHECK (close_day BETWEEN 0 AND 6),
+  close_time TIME     NOT NULL
+);
+CREATE INDEX mall_working_hours_mall_id_idx ON mall_working_hours (mall_id);
+
+CREATE TABLE shop (
+  shop_id         SERIAL PRIMARY KEY,
+  shop_name       TEXT    NOT NULL,
+  shop_logo_small TEXT    NOT NULL DEFAULT '',
+  shop_logo_large TEXT    NOT NULL DEFAULT '',
+  shop_phone      TEXT    NOT NULL DEFAULT '',
+  shop_site       TEXT    NOT NULL DEFAULT '',
+  score           INTEGER NOT NULL DEFAULT 0,
+  malls_count     INTEGER NOT NULL DEFAULT 0
+);
+
+-- all names of a shop including the main one, used by the name search
+CREATE TABLE shop_name (
+  shop_id   INTEGER NOT NULL REFERENCES shop (shop_id) ON DELETE CASCADE,
+  shop_name TEXT    NOT NULL,
+  PRIMARY KEY (shop_id, shop_name)
+);
+
+CREATE TABLE category (
+  category_id         SERIAL PRIMARY KEY,
+  category_name       TEXT    NOT NULL,
+  category_logo_small TEXT    NOT NULL DEFAULT '',
+  category_logo_large TEXT    NOT NULL DEFAULT '',
+  shops_count         INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE shop_category (
+  shop_id     INTEGER NOT NULL REFERENCES shop (shop_id) ON DELETE CASCADE,
+  category_id INTEGER NOT NULL REFERENCES category (category_id) ON DELETE CASCADE,
+  PRIMARY KEY (shop_id, category_id)
+);
+CREATE INDEX shop_category_category_id_idx ON shop_category (category_id);
+
+CREATE TABLE mall_shop (
+  mall_id INTEGER NOT NULL REFERENCES mall (mall_id) ON DELETE CASCADE,
+  shop_id INTEGER NOT NULL REFERENCES shop (shop_id) ON DELETE CASCADE,
+  PRIMARY KEY (mall_id, shop_id)
+);
+CREATE INDEX mall_shop_shop_id_idx ON mall_shop (shop_id);
@@ -0,0 +1 @@
+DROP FUNCTION mall_is_open(INTEGER, BOOLEAN, TIMESTAMPTZ, TEXT);
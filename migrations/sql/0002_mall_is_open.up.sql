@@ -0,0 +1,22 @@
+-- the logic matches models.Mall.OpenStatus: working hours are in the mall local time
+-- and a period that closes at the same time or earlier than it opens continues on the next week
+CREATE FUNCTION mall_is_open(mall_id INTEGER, day_and_night BOOLEAN, at TIMESTAMPTZ, timezone TEXT)
+  RETURNS BOOLEAN AS $$
+SELECT $2 OR EXISTS(
+    SELECT 1
+    FROM (
+           SELECT
+             wh.open_day * 86400 + extract(EPOCH FROM wh.open_time)   open_at,
+             wh.close_day * 86400 + extract(EPOCH FROM wh.close_time) close_at
+           FROM mall_working_hours wh
+           WHERE wh.mall_id = $1
+         ) p
+      CROSS JOIN (
+                   SELECT (extract(ISODOW FROM local_time) - 1) * 86400 + extract(EPOCH FROM local_time :: TIME) + shift week_time
+                   FROM (SELECT $3 AT TIME ZONE $4 local_time) l
+                     CROSS JOIN (VALUES (0), (604800)) shifts(shift)
+                 ) w
+    WHERE p.open_at <= w.week_time AND
+          w.week_time < p.close_at + CASE WHEN p.close_at <= p.open_at THEN 604800 ELSE 0 END
+)
+$$ LANGUAGE SQL STABLE;
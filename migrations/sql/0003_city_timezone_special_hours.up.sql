@@ -0,0 +1,65 @@
+-- IANA name like Europe/Moscow, NULL means the timezone from the service config
+ALTER TABLE city ADD COLUMN city_timezone TEXT;
+
+-- overrides the weekly working hours of a mall for a date in the mall local time,
+-- NULL times mean the mall is closed the whole day, a period may close on the next day
+CREATE TABLE mall_special_hours (
+  mall_id      INTEGER NOT NULL REFERENCES mall (mall_id) ON DELETE CASCADE,
+  special_date DATE    NOT NULL,
+  open_time    TIME,
+  close_time   TIME,
+  PRIMARY KEY (mall_id, special_date),
+  CHECK ((open_time IS NULL) = (close_time IS NULL))
+);
+
+-- the logic matches models.Mall.OpenStatus: every period belongs to the date it opens on,
+-- special hours replace all periods of their date including the day and night ones
+CREATE OR REPLACE FUNCTION mall_is_open(mall_id INTEGER, day_and_night BOOLEAN, at TIMESTAMPTZ, timezone TEXT)
+  RETURNS BOOLEAN AS $$
+WITH local AS (
+    SELECT $3 AT TIME ZONE coalesce(
+        (SELECT c.city_timezone
+         FROM mall m
+           JOIN city c ON m.city_id = c.city_id
+         WHERE m.mall_id = $1),
+        $4) local_time
+), days AS (
+    -- weekly periods may last up to a week
+    SELECT
+      l.local_time :: DATE - shift open_date,
+      l.local_time,
+      EXISTS(SELECT 1
+             FROM mall_special_hours sh
+             WHERE sh.mall_id = $1 AND sh.special_date = l.local_time :: DATE - shift) special
+    FROM local l
+      CROSS JOIN generate_series(0, 7) shift
+), periods AS (
+  SELECT
+    d.local_time,
+    d.open_date + sh.open_time period_start,
+    d.open_date + CASE WHEN sh.close_time <= sh.open_time THEN 1 ELSE 0 END + sh.close_time period_end
+  FROM days d
+    JOIN mall_special_hours sh ON sh.mall_id = $1 AND sh.special_date = d.open_date
+  WHERE sh.open_time IS NOT NULL
+  UNION ALL
+  SELECT
+    d.local_time,
+    d.open_date + TIME '00:00' period_start,
+    d.open_date + 1 + TIME '00:00' period_end
+  FROM days d
+  WHERE $2 AND NOT d.special
+  UNION ALL
+  SELECT
+    d.local_time,
+    d.open_date + wh.open_time period_start,
+    d.open_date + CASE WHEN wh.close_day = wh.open_day AND wh.close_time <= wh.open_time
+                    THEN 7
+                  ELSE (wh.close_day - wh.open_day + 7) % 7 END + wh.close_time period_end
+  FROM days d
+    JOIN mall_working_hours wh ON wh.mall_id = $1 AND wh.open_day = extract(ISODOW FROM d.open_date) - 1
+  WHERE NOT $2 AND NOT d.special
+)
+SELECT EXISTS(SELECT 1
+              FROM periods p
+              WHERE p.period_start <= p.local_time AND p.local_time < p.period_end)
+$$ LANGUAGE SQL STABLE;
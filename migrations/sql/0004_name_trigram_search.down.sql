@@ -0,0 +1,3 @@
+DROP INDEX shop_name_search_key_idx;
+DROP INDEX mall_name_search_key_idx;
+DROP FUNCTION name_search_key(TEXT);
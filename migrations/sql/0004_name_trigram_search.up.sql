@@ -0,0 +1,17 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- lower case latin spelling of a name, so that "Мега" and "Mega" get the same key,
+-- must match utils.NameSearchKey
+CREATE OR REPLACE FUNCTION name_search_key(name TEXT)
+  RETURNS TEXT AS $$
+SELECT translate(
+    replace(replace(replace(replace(replace(replace(replace(replace(replace(
+        lower($1),
+        'щ', 'sch'), 'ж', 'zh'), 'ц', 'ts'), 'ч', 'ch'), 'ш', 'sh'), 'ю', 'yu'), 'я', 'ya'), 'ъ', ''), 'ь', ''),
+    'абвгдеёзийклмнопрстуфхыэ',
+    'abvgdeeziyklmnoprstufhye'
+)
+$$ LANGUAGE SQL IMMUTABLE STRICT;
+
+CREATE INDEX mall_name_search_key_idx ON mall_name USING GIN (name_search_key(mall_name) gin_trgm_ops);
+CREATE INDEX shop_name_search_key_idx ON shop_name USING GIN (name_search_key(shop_name) gin_trgm_ops);
@@ -0,0 +1,6 @@
+DROP INDEX subway_station_name_search_key_idx;
+DROP INDEX category_name_search_key_idx;
+DROP INDEX subway_station_name_search_key_prefix_idx;
+DROP INDEX category_name_search_key_prefix_idx;
+DROP INDEX shop_name_search_key_prefix_idx;
+DROP INDEX mall_name_search_key_prefix_idx;
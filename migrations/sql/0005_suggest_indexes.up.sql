@@ -0,0 +1,8 @@
+-- prefix matches of short autocomplete queries
+CREATE INDEX mall_name_search_key_prefix_idx ON mall_name (name_search_key(mall_name) text_pattern_ops);
+CREATE INDEX shop_name_search_key_prefix_idx ON shop_name (name_search_key(shop_name) text_pattern_ops);
+CREATE INDEX category_name_search_key_prefix_idx ON category (name_search_key(category_name) text_pattern_ops);
+CREATE INDEX subway_station_name_search_key_prefix_idx ON subway_station (name_search_key(station_name) text_pattern_ops);
+
+CREATE INDEX category_name_search_key_idx ON category USING GIN (name_search_key(category_name) gin_trgm_ops);
+CREATE INDEX subway_station_name_search_key_idx ON subway_station USING GIN (name_search_key(station_name) gin_trgm_ops);
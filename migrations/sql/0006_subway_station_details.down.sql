@@ -0,0 +1,5 @@
+DROP INDEX subway_station_location_idx;
+ALTER TABLE subway_station
+  DROP COLUMN station_location,
+  DROP COLUMN line_color,
+  DROP COLUMN line_name;
@@ -0,0 +1,7 @@
+ALTER TABLE subway_station
+  ADD COLUMN line_name        TEXT NOT NULL DEFAULT '',
+  -- hex color of the line like #FF0000, empty if unknown
+  ADD COLUMN line_color       TEXT NOT NULL DEFAULT '',
+  -- NULL for the stations added before the coordinates were known
+  ADD COLUMN station_location GEOMETRY(Point, 4326);
+CREATE INDEX subway_station_location_idx ON subway_station USING GIST (station_location);
@@ -0,0 +1,13 @@
+ALTER TABLE mall
+  ADD COLUMN subway_station_id INTEGER REFERENCES subway_station (station_id) ON DELETE SET NULL;
+CREATE INDEX mall_subway_station_id_idx ON mall (subway_station_id);
+
+-- only the nearest station survives
+UPDATE mall m
+SET subway_station_id = (SELECT mss.station_id
+                         FROM mall_subway_station mss
+                         WHERE mss.mall_id = m.mall_id
+                         ORDER BY mss.walk_distance, mss.station_id
+                         LIMIT 1);
+
+DROP TABLE mall_subway_station;
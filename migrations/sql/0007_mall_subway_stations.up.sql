@@ -0,0 +1,23 @@
+CREATE TABLE mall_subway_station (
+  mall_id       INTEGER NOT NULL REFERENCES mall (mall_id) ON DELETE CASCADE,
+  station_id    INTEGER NOT NULL REFERENCES subway_station (station_id) ON DELETE CASCADE,
+  -- walk from the station exit to the mall entrance
+  walk_distance INTEGER NOT NULL,
+  walk_minutes  INTEGER NOT NULL,
+  PRIMARY KEY (mall_id, station_id)
+);
+CREATE INDEX mall_subway_station_station_id_idx ON mall_subway_station (station_id, walk_minutes);
+
+-- the walk is estimated by the straight line at 80 meters per minute, zero if the station has no location
+INSERT INTO mall_subway_station (mall_id, station_id, walk_distance, walk_minutes)
+  SELECT
+    m.mall_id,
+    m.subway_station_id,
+    coalesce(round(st_distance(st_transform(m.mall_location, 26986), st_transform(ss.station_location, 26986))), 0),
+    coalesce(ceil(st_distance(st_transform(m.mall_location, 26986), st_transform(ss.station_location, 26986)) / 80), 0)
+  FROM mall m
+    JOIN subway_station ss ON m.subway_station_id = ss.station_id;
+
+DROP INDEX mall_subway_station_id_idx;
+ALTER TABLE mall
+  DROP COLUMN subway_station_id;
@@ -0,0 +1,5 @@
+ALTER TABLE mall_shop
+  DROP COLUMN phone,
+  DROP COLUMN entrance,
+  DROP COLUMN unit,
+  DROP COLUMN floor;
@@ -0,0 +1,7 @@
+ALTER TABLE mall_shop
+  -- NULL if unknown, negative for the underground floors
+  ADD COLUMN floor    INTEGER,
+  ADD COLUMN unit     TEXT NOT NULL DEFAULT '',
+  ADD COLUMN entrance TEXT NOT NULL DEFAULT '',
+  -- overrides the shop phone in this mall if not empty
+  ADD COLUMN phone    TEXT NOT NULL DEFAULT '';
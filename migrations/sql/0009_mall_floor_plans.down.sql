@@ -0,0 +1,2 @@
+DROP TABLE mall_floor_unit;
+DROP TABLE mall_floor;
@@ -0,0 +1,27 @@
+CREATE TABLE mall_floor (
+  mall_id     INTEGER          NOT NULL REFERENCES mall (mall_id) ON DELETE CASCADE,
+  floor       INTEGER          NOT NULL,
+  floor_name  TEXT             NOT NULL DEFAULT '',
+  -- url of the plan image, empty if the plan is drawn only by the unit polygons
+  plan_image  TEXT             NOT NULL DEFAULT '',
+  -- extent of the local coordinates in meters, the origin is the bottom left corner of the plan
+  plan_width  DOUBLE PRECISION NOT NULL,
+  plan_height DOUBLE PRECISION NOT NULL,
+  PRIMARY KEY (mall_id, floor)
+);
+
+CREATE TABLE mall_floor_unit (
+  unit_id      SERIAL PRIMARY KEY,
+  mall_id      INTEGER           NOT NULL,
+  floor        INTEGER           NOT NULL,
+  unit         TEXT              NOT NULL DEFAULT '',
+  -- NULL for the vacant units, cleared by the mall_shop delete trigger from 0011
+  shop_id      INTEGER,
+  -- in the local coordinates of the floor plan, without SRID
+  unit_polygon GEOMETRY(Polygon) NOT NULL,
+  FOREIGN KEY (mall_id, floor) REFERENCES mall_floor (mall_id, floor) ON DELETE CASCADE,
+  FOREIGN KEY (mall_id, shop_id) REFERENCES mall_shop (mall_id, shop_id)
+);
+CREATE INDEX mall_floor_unit_floor_idx ON mall_floor_unit (mall_id, floor);
+CREATE INDEX mall_floor_unit_shop_idx ON mall_floor_unit (mall_id, shop_id);
+CREATE INDEX mall_floor_unit_polygon_idx ON mall_floor_unit USING GIST (unit_polygon);
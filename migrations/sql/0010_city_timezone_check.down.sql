@@ -0,0 +1,2 @@
+ALTER TABLE city DROP CONSTRAINT city_timezone_name_check;
+DROP FUNCTION is_timezone_name(TEXT);
@@ -0,0 +1,15 @@
+-- full IANA names only: AT TIME ZONE also takes abbreviations and POSIX specs,
+-- which the service cannot load, so the SQL and the Go open status would disagree
+CREATE OR REPLACE FUNCTION is_timezone_name(name TEXT)
+  RETURNS BOOLEAN AS $$
+SELECT EXISTS(SELECT 1
+              FROM pg_timezone_names tz
+              WHERE tz.name = $1)
+$$ LANGUAGE SQL STABLE STRICT;
+
+-- such cities fail mall_is_open, the service already falls back to the config timezone for them
+UPDATE city
+SET city_timezone = NULL
+WHERE NOT is_timezone_name(city_timezone);
+
+ALTER TABLE city ADD CONSTRAINT city_timezone_name_check CHECK (is_timezone_name(city_timezone));
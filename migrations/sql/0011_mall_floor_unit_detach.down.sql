@@ -0,0 +1,2 @@
+DROP TRIGGER mall_shop_detach_floor_units ON mall_shop;
+DROP FUNCTION mall_floor_unit_detach_shop();
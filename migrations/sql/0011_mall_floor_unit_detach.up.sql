@@ -0,0 +1,15 @@
+-- frees the units of a shop when its mall_shop link goes, including the cascades from mall and shop,
+-- so a unit never points to a shop that is not in the mall anymore
+CREATE OR REPLACE FUNCTION mall_floor_unit_detach_shop()
+  RETURNS TRIGGER AS $$
+BEGIN
+  UPDATE mall_floor_unit
+  SET shop_id = NULL
+  WHERE mall_id = OLD.mall_id AND shop_id = OLD.shop_id;
+  RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER mall_shop_detach_floor_units
+BEFORE DELETE ON mall_shop
+FOR EACH ROW EXECUTE PROCEDURE mall_floor_unit_detach_shop();
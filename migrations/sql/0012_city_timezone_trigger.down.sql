@@ -0,0 +1,3 @@
+DROP TRIGGER city_timezone_name_check ON city;
+DROP FUNCTION city_check_timezone_name();
+ALTER TABLE city ADD CONSTRAINT city_timezone_name_check CHECK (is_timezone_name(city_timezone));
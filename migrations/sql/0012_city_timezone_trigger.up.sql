@@ -0,0 +1,18 @@
+-- a CHECK must be immutable, but pg_timezone_names follows the tz database of the server,
+-- so the names are checked by a trigger when they are written instead
+ALTER TABLE city DROP CONSTRAINT city_timezone_name_check;
+
+CREATE OR REPLACE FUNCTION city_check_timezone_name()
+  RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.city_timezone IS NOT NULL AND NOT is_timezone_name(NEW.city_timezone) THEN
+    RAISE EXCEPTION 'unknown timezone %', NEW.city_timezone
+    USING ERRCODE = 'check_violation';
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER city_timezone_name_check
+BEFORE INSERT OR UPDATE OF city_timezone ON city
+FOR EACH ROW EXECUTE PROCEDURE city_check_timezone_name();
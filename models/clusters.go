@@ -0,0 +1,71 @@
+package models
+
+import (
+	"math"
+)
+
+const (
+	MaxClusterZoom = 20
+	// clusters carry the IDs of their malls only up to this size
+	MaxClusterMallIDs = 10
+
+	// size of a web map tile and of a grid cell at any zoom, in pixels
+	tileSize        = 256
+	clusterCellSize = 64
+)
+
+type MallCluster struct {
+	// centroid of the malls
+	Center *Location
+	Count  int
+	// nil for the clusters bigger than MaxClusterMallIDs
+	MallIDs []int
+}
+
+// webMercatorPixel projects a location to the pixel coordinates of the whole world map at the zoom.
+func webMercatorPixel(location *Location, zoom int) (float64, float64) {
+	worldSize := tileSize * math.Exp2(float64(zoom))
+	x := (location.Lon + 180) / 360 * worldSize
+	sinLat := math.Sin(location.Lat * math.Pi / 180)
+	// clamped like the map projection does near the poles
+	sinLat = math.Min(math.Max(sinLat, -0.9999), 0.9999)
+	y := (0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * worldSize
+	return x, y
+}
+
+// ClusterMalls groups the malls by the cells of a pixel grid at the zoom,
+// the clusters keep the order of their first malls.
+func ClusterMalls(malls []*Mall, zoom int) []*MallCluster {
+	type cell struct{ x, y int64 }
+	type clusterSums struct {
+		cluster  *MallCluster
+		lat, lon float64
+	}
+	var cells []cell
+	sums := map[cell]*clusterSums{}
+	for _, mall := range malls {
+		x, y := webMercatorPixel(&mall.Location, zoom)
+		key := cell{x: int64(math.Floor(x / clusterCellSize)), y: int64(math.Floor(y / clusterCellSize))}
+		s, ok := sums[key]
+		if !ok {
+			s = &clusterSums{cluster: &MallCluster{}}
+			sums[key] = s
+			cells = append(cells, key)
+		}
+		s.cluster.Count++
+		s.cluster.MallIDs = append(s.cluster.MallIDs, mall.ID)
+		s.lat += mall.Location.Lat
+		s.lon += mall.Location.Lon
+	}
+	clusters := make([]*MallCluster, len(cells))
+	for i, key := range cells {
+		s := sums[key]
+		count := float64(s.cluster.Count)
+		s.cluster.Center = &Location{Lat: s.lat / count, Lon: s.lon / count}
+		if s.cluster.Count > MaxClusterMallIDs {
+			s.cluster.MallIDs = nil
+		}
+		clusters[i] = s.cluster
+	}
+	return clusters
+}
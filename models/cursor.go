@@ -0,0 +1,139 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+type Cursor struct {
+	Sort     string        `json:"s"`
+	Values   []interface{} `json:"v"`
+	Backward bool          `json:"b,omitempty"`
+}
+
+func SortingString(sorting Sorting) string {
+	if sorting.Reversed() {
+		return REVERSE_SIGN + sorting.Key()
+	}
+	return sorting.Key()
+}
+
+func NewCursor(sorting Sorting, values []interface{}, backward bool) *Cursor {
+	return &Cursor{Sort: SortingString(sorting), Values: values, Backward: backward}
+}
+
+func (c *Cursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sample is the sort values of any model of the listed type and is used to check the cursor values types.
+func ParseCursor(raw string, sorting Sorting, sample []interface{}) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	cursor := &Cursor{}
+	err = json.Unmarshal(data, cursor)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	if cursor.Sort != SortingString(sorting) {
+		return nil, errors.Errorf("cursor was issued for sort %s", cursor.Sort)
+	}
+	if len(cursor.Values) != len(sample) {
+		return nil, errors.New("malformed cursor")
+	}
+	for i, value := range cursor.Values {
+		switch sample[i].(type) {
+		case string:
+			if _, ok := value.(string); !ok {
+				return nil, errors.New("malformed cursor")
+			}
+		case int:
+			number, ok := value.(float64)
+			if !ok || number != math.Trunc(number) {
+				return nil, errors.New("malformed cursor")
+			}
+			cursor.Values[i] = int(number)
+		case float64:
+			if _, ok := value.(float64); !ok {
+				return nil, errors.New("malformed cursor")
+			}
+		}
+	}
+	return cursor, nil
+}
+
+func (m *Mall) SortValues(sorting Sorting) []interface{} {
+	switch sorting.Key() {
+	case IDSortKey:
+		return []interface{}{m.ID}
+	case NameSortKey:
+		return []interface{}{m.Name, m.ID}
+	case ShopsCountSortKey:
+		return []interface{}{m.ShopsCount, m.ID}
+	case RelevanceSortKey:
+		return []interface{}{m.Relevance, m.ID}
+	case DistanceSortKey:
+		var distance float64
+		if m.Distance != nil {
+			distance = *m.Distance
+		}
+		return []interface{}{distance, m.ID}
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for mall", sorting.Key()))
+	}
+}
+
+func (s *Shop) SortValues(sorting Sorting) []interface{} {
+	switch sorting.Key() {
+	case IDSortKey:
+		return []interface{}{s.ID}
+	case NameSortKey:
+		return []interface{}{s.Name, s.ID}
+	case MallsCountSortKey:
+		return []interface{}{s.MallsCount, s.ID}
+	case ScoreSortKey:
+		return []interface{}{s.Score, s.ID}
+	case RelevanceSortKey:
+		return []interface{}{s.Relevance, s.ID}
+	case NearestSortKey:
+		distance := NoMallDistance
+		if s.NearestDistance != nil {
+			distance = *s.NearestDistance
+		}
+		return []interface{}{distance, s.ID}
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for shop", sorting.Key()))
+	}
+}
+
+func (sr *SearchResult) SortValues(sorting Sorting) []interface{} {
+	matched := len(sr.ShopIDs)
+	switch sorting.Key() {
+	case MallIDSortKey:
+		return []interface{}{matched, sr.Mall.ID}
+	case MallNameSortKey:
+		return []interface{}{matched, sr.Mall.Name, sr.Mall.ID}
+	case ShopsCountSortKey:
+		return []interface{}{matched, sr.Mall.ShopsCount, sr.Mall.ID}
+	case DistanceSortKey:
+		var distance float64
+		if sr.Distance != nil {
+			distance = *sr.Distance
+		}
+		return []interface{}{matched, distance, sr.Mall.ID}
+	case RouteDistanceSortKey:
+		var routeDistance float64
+		if sr.RouteDistance != nil {
+			routeDistance = *sr.RouteDistance
+		}
+		return []interface{}{matched, routeDistance, sr.Mall.ID}
+	default:
+		panic(errors.Errorf("Unexpected sorting key %s for search result", sorting.Key()))
+	}
+}
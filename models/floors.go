@@ -0,0 +1,64 @@
+package models
+
+import (
+	"math"
+)
+
+// IndoorPoint is in the local coordinates of a floor plan, in meters from its bottom left corner.
+type IndoorPoint struct {
+	X float64
+	Y float64
+}
+
+type FloorPlan struct {
+	Floor int
+	Name  string
+	// url, empty if the plan is drawn only by the unit polygons
+	PlanImage string
+	Width     float64
+	Height    float64
+	//Details
+	UnitsCount int
+	Units      []*FloorUnit
+}
+
+type FloorUnit struct {
+	ID   int
+	Unit string
+	// nil for the vacant units
+	ShopID *int
+	// the outer ring goes first, then the holes, every ring is closed
+	Polygon [][]IndoorPoint
+}
+
+// Contains checks the point with the even-odd rule, so the holes are excluded.
+func (fu *FloorUnit) Contains(point IndoorPoint) bool {
+	inside := false
+	for _, ring := range fu.Polygon {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a.Y > point.Y) != (b.Y > point.Y) &&
+				point.X < (b.X-a.X)*(point.Y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// Area of the outer ring without the holes, in square meters.
+func (fu *FloorUnit) Area() float64 {
+	var area float64
+	for i, ring := range fu.Polygon {
+		var sum float64
+		for j := 0; j+1 < len(ring); j++ {
+			sum += ring[j].X*ring[j+1].Y - ring[j+1].X*ring[j].Y
+		}
+		if i == 0 {
+			area += math.Abs(sum) / 2
+		} else {
+			area -= math.Abs(sum) / 2
+		}
+	}
+	return area
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+type DependencyCheck struct {
+	Name    string
+	Latency time.Duration
+	// nil if the dependency is fine
+	Err error
+}
+
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	GoVersion string
+}
+
+type Readiness struct {
+	ShuttingDown bool
+	// empty while shutting down, the dependencies are not checked then
+	Checks []*DependencyCheck
+	// 0 if postgres is down
+	SchemaVersion         int
+	ExpectedSchemaVersion int
+	Build                 *BuildInfo
+	StartedAt             time.Time
+}
+
+func (r *Readiness) Ready() bool {
+	if r.ShuttingDown || r.SchemaVersion < r.ExpectedSchemaVersion {
+		return false
+	}
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mallfin_api/config"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DateLayout = "2006-01-02"
+
+	daySeconds = 24 * 60 * 60
+	// weekly periods may last up to a week, so the status looks this far back
+	lookBehindDays = 7
+	// the status does not look for the next opening or closing further than that
+	lookAheadDays = 14
+	// the longest schedule of a mall
+	MaxScheduleDays = 31
+)
+
+var (
+	defaultTimeZone     *time.Location
+	defaultTimeZoneOnce sync.Once
+)
+
+type OpenStatus struct {
+	IsOpen bool
+	// OpensAt is nil if the mall is open and vice versa, both are nil if nothing changes in the look ahead window
+	OpensAt  *time.Time
+	ClosesAt *time.Time
+}
+
+type Period struct {
+	Open  time.Time
+	Close time.Time
+}
+
+type DaySchedule struct {
+	Date    string
+	Special bool
+	// periods that open on this date, they may close on the next days
+	Periods []*Period
+}
+
+// parses postgres time values like 10:00:00 into seconds since midnight.
+func parseDayTime(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.Errorf("bad time %s", value)
+	}
+	seconds := 0
+	multipliers := []int{60 * 60, 60, 1}
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, errors.Errorf("bad time %s", value)
+		}
+		seconds += int(number) * multipliers[i]
+	}
+	if seconds < 0 || seconds > daySeconds {
+		return 0, errors.Errorf("bad time %s", value)
+	}
+	return seconds, nil
+}
+
+// days are numbered from 0 (monday) to 6 (sunday) like in the working hours
+func weekday(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+func dateStart(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func atDayTime(date time.Time, days, seconds int) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day+days, 0, 0, seconds, 0, date.Location())
+}
+
+// the period opens on the given date, close is moved to the next week if it isn't after open
+func (p *WorkPeriod) on(date time.Time) (*Period, error) {
+	open, err := parseDayTime(p.Open.Time)
+	if err != nil {
+		return nil, err
+	}
+	close, err := parseDayTime(p.Close.Time)
+	if err != nil {
+		return nil, err
+	}
+	days := (p.Close.Day - p.Open.Day + 7) % 7
+	if days == 0 && close <= open {
+		days = 7
+	}
+	return &Period{Open: atDayTime(date, 0, open), Close: atDayTime(date, days, close)}, nil
+}
+
+func (sh *SpecialHours) on(date time.Time) (*Period, error) {
+	if sh.Open == nil || sh.Close == nil {
+		return nil, nil
+	}
+	open, err := parseDayTime(*sh.Open)
+	if err != nil {
+		return nil, err
+	}
+	close, err := parseDayTime(*sh.Close)
+	if err != nil {
+		return nil, err
+	}
+	days := 0
+	if close <= open {
+		days = 1
+	}
+	return &Period{Open: atDayTime(date, 0, open), Close: atDayTime(date, days, close)}, nil
+}
+
+// the timezone of the config, like for the cities without their own in the db
+func configTimeZone() *time.Location {
+	defaultTimeZoneOnce.Do(func() {
+		location, err := time.LoadLocation(config.Timezone())
+		if err != nil {
+			// the db refuses to start with such a config, so this is never used outside of the tests
+			location = time.UTC
+		}
+		defaultTimeZone = location
+	})
+	return defaultTimeZone
+}
+
+func (m *Mall) timeZone() *time.Location {
+	if m.TimeZone == nil {
+		return configTimeZone()
+	}
+	return m.TimeZone
+}
+
+// SpecialHoursWindow returns the first and the last dates of the special hours that the open status
+// and the schedules at the time may use, with a spare day on both sides for the timezone of the mall.
+func SpecialHoursWindow(at time.Time) (string, string) {
+	from := at.AddDate(0, 0, -lookBehindDays-1).Format(DateLayout)
+	to := at.AddDate(0, 0, MaxScheduleDays+1).Format(DateLayout)
+	return from, to
+}
+
+func (m *Mall) specialHours(date string) *SpecialHours {
+	for _, special := range m.SpecialHours {
+		if special.Date == date {
+			return special
+		}
+	}
+	return nil
+}
+
+// date must be the midnight in the mall timezone, periods with unparsable times are skipped.
+func (m *Mall) daySchedule(date time.Time) *DaySchedule {
+	schedule := &DaySchedule{Date: date.Format(DateLayout), Periods: []*Period{}}
+	if special := m.specialHours(schedule.Date); special != nil {
+		schedule.Special = true
+		if period, err := special.on(date); err == nil && period != nil {
+			schedule.Periods = append(schedule.Periods, period)
+		}
+		return schedule
+	}
+	if m.DayAndNight {
+		schedule.Periods = append(schedule.Periods, &Period{Open: date, Close: atDayTime(date, 1, 0)})
+		return schedule
+	}
+	for _, workPeriod := range m.WorkingHours {
+		if workPeriod.Open.Day != weekday(date) {
+			continue
+		}
+		if period, err := workPeriod.on(date); err == nil {
+			schedule.Periods = append(schedule.Periods, period)
+		}
+	}
+	return schedule
+}
+
+// Schedule returns the effective schedule of the mall for the given number of days starting from the date of from.
+func (m *Mall) Schedule(from time.Time, days int) []*DaySchedule {
+	date := dateStart(from.In(m.timeZone()))
+	schedule := make([]*DaySchedule, days)
+	for i := range schedule {
+		schedule[i] = m.daySchedule(atDayTime(date, i, 0))
+	}
+	return schedule
+}
+
+func (m *Mall) OpenStatus(at time.Time) *OpenStatus {
+	at = at.In(m.timeZone())
+	windowEnd := atDayTime(dateStart(at), lookAheadDays, 0)
+	var periods []*Period
+	for _, day := range m.Schedule(at.AddDate(0, 0, -lookBehindDays), lookBehindDays+lookAheadDays) {
+		periods = append(periods, day.Periods...)
+	}
+	var closesAt *time.Time
+	for _, period := range periods {
+		if !period.Open.After(at) && at.Before(period.Close) && (closesAt == nil || period.Close.After(*closesAt)) {
+			closesAt = &period.Close
+		}
+	}
+	if closesAt != nil {
+		// a period may continue right where another one ends, e.g. at midnight
+		for extended := true; extended; {
+			extended = false
+			for _, period := range periods {
+				if !period.Open.After(*closesAt) && closesAt.Before(period.Close) {
+					closesAt, extended = &period.Close, true
+				}
+			}
+		}
+		if !closesAt.Before(windowEnd) {
+			return &OpenStatus{IsOpen: true}
+		}
+		return &OpenStatus{IsOpen: true, ClosesAt: closesAt}
+	}
+	var opensAt *time.Time
+	for _, period := range periods {
+		if period.Open.After(at) && (opensAt == nil || period.Open.Before(*opensAt)) {
+			opensAt = &period.Open
+		}
+	}
+	return &OpenStatus{OpensAt: opensAt}
+}
+
+func (m *Mall) IsOpenAt(at time.Time) bool {
+	return m.OpenStatus(at).IsOpen
+}
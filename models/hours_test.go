@@ -0,0 +1,212 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// the hours are checked in a fixed zone, so the tests do not depend on the tz database
+var testZone = time.FixedZone("MSK", 3*60*60)
+
+func weekPeriod(openDay int, open string, closeDay int, close string) *WorkPeriod {
+	return &WorkPeriod{Open: WeekTime{Day: openDay, Time: open}, Close: WeekTime{Day: closeDay, Time: close}}
+}
+
+// 2024-01-01 is a monday
+func testTime(day, hour, minute int) time.Time {
+	return time.Date(2024, time.January, day, hour, minute, 0, 0, testZone)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+type openStatusCase struct {
+	name     string
+	at       time.Time
+	expected *OpenStatus
+}
+
+func checkOpenStatus(t *testing.T, mall *Mall, cases []openStatusCase) {
+	t.Helper()
+	for _, c := range cases {
+		status := mall.OpenStatus(c.at)
+		if status.IsOpen != c.expected.IsOpen || !equalTimes(status.OpensAt, c.expected.OpensAt) ||
+			!equalTimes(status.ClosesAt, c.expected.ClosesAt) {
+			t.Errorf("%s: status at %s is %s, expected %s", c.name, c.at, formatStatus(status), formatStatus(c.expected))
+		}
+	}
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func formatStatus(status *OpenStatus) string {
+	format := func(t *time.Time) string {
+		if t == nil {
+			return "nil"
+		}
+		return t.Format(time.RFC3339)
+	}
+	if status.IsOpen {
+		return "open, closes at " + format(status.ClosesAt)
+	}
+	return "closed, opens at " + format(status.OpensAt)
+}
+
+func open(closesAt *time.Time) *OpenStatus {
+	return &OpenStatus{IsOpen: true, ClosesAt: closesAt}
+}
+
+func closed(opensAt *time.Time) *OpenStatus {
+	return &OpenStatus{OpensAt: opensAt}
+}
+
+func TestOpenStatusWeekly(t *testing.T) {
+	mall := &Mall{
+		TimeZone: testZone,
+		WorkingHours: []*WorkPeriod{
+			weekPeriod(0, "10:00:00", 0, "22:00:00"),
+			weekPeriod(1, "10:00:00", 1, "22:00:00"),
+			// closed on wednesdays
+			weekPeriod(3, "10:00:00", 3, "22:00:00"),
+			weekPeriod(4, "10:00:00", 5, "02:00:00"),
+			weekPeriod(5, "10:00:00", 5, "23:00:00"),
+			weekPeriod(6, "22:00:00", 0, "03:00:00"),
+		},
+	}
+	checkOpenStatus(t, mall, []openStatusCase{
+		{"day", testTime(1, 12, 0), open(timePtr(testTime(1, 22, 0)))},
+		{"before opening", testTime(1, 9, 0), closed(timePtr(testTime(1, 10, 0)))},
+		{"at opening", testTime(1, 10, 0), open(timePtr(testTime(1, 22, 0)))},
+		{"at closing", testTime(1, 22, 0), closed(timePtr(testTime(2, 10, 0)))},
+		{"friday night", testTime(5, 23, 30), open(timePtr(testTime(6, 2, 0)))},
+		{"after midnight", testTime(6, 1, 0), open(timePtr(testTime(6, 2, 0)))},
+		{"after the night", testTime(6, 2, 0), closed(timePtr(testTime(6, 10, 0)))},
+		{"saturday evening", testTime(6, 23, 30), closed(timePtr(testTime(7, 22, 0)))},
+		{"sunday night", testTime(7, 23, 0), open(timePtr(testTime(8, 3, 0)))},
+		// the period opened on the sunday of the previous week
+		{"monday night", testTime(1, 1, 0), open(timePtr(testTime(1, 3, 0)))},
+		{"monday after the night", testTime(8, 3, 0), closed(timePtr(testTime(8, 10, 0)))},
+		{"before a closed day", testTime(2, 22, 30), closed(timePtr(testTime(4, 10, 0)))},
+		{"closed day", testTime(3, 12, 0), closed(timePtr(testTime(4, 10, 0)))},
+		// the zone of the mall, not of the time, decides the day
+		{"utc time", time.Date(2024, time.January, 7, 20, 0, 0, 0, time.UTC), open(timePtr(testTime(8, 3, 0)))},
+	})
+}
+
+func TestOpenStatusWithoutHours(t *testing.T) {
+	checkOpenStatus(t, &Mall{TimeZone: testZone, DayAndNight: true}, []openStatusCase{
+		{"day and night", testTime(3, 12, 0), open(nil)},
+	})
+	checkOpenStatus(t, &Mall{TimeZone: testZone}, []openStatusCase{
+		{"no hours", testTime(3, 12, 0), closed(nil)},
+	})
+	// a period closing at the time it opens lasts the whole week, so the mall never closes
+	checkOpenStatus(t, &Mall{TimeZone: testZone, WorkingHours: []*WorkPeriod{weekPeriod(0, "10:00", 0, "10:00")}}, []openStatusCase{
+		{"whole week", testTime(3, 12, 0), open(nil)},
+	})
+}
+
+func specialHours(date string, hours ...string) *SpecialHours {
+	special := &SpecialHours{Date: date}
+	if len(hours) == 2 {
+		special.Open, special.Close = &hours[0], &hours[1]
+	}
+	return special
+}
+
+func TestOpenStatusSpecialHours(t *testing.T) {
+	mall := &Mall{
+		TimeZone: testZone,
+		WorkingHours: []*WorkPeriod{
+			weekPeriod(0, "10:00:00", 0, "22:00:00"),
+			weekPeriod(1, "10:00:00", 1, "22:00:00"),
+			weekPeriod(2, "10:00:00", 2, "22:00:00"),
+			weekPeriod(3, "10:00:00", 3, "22:00:00"),
+			weekPeriod(4, "10:00:00", 4, "22:00:00"),
+			weekPeriod(5, "10:00:00", 5, "22:00:00"),
+			weekPeriod(6, "22:00:00", 0, "03:00:00"),
+		},
+		SpecialHours: []*SpecialHours{
+			specialHours("2024-01-02", "12:00:00", "18:00:00"),
+			// closed the whole day
+			specialHours("2024-01-03"),
+			specialHours("2024-01-05", "20:00:00", "02:00:00"),
+			// the night from sunday still lasts on a closed monday
+			specialHours("2024-01-08"),
+			specialHours("2024-01-14", "10:00:00", "20:00:00"),
+		},
+	}
+	checkOpenStatus(t, mall, []openStatusCase{
+		{"before the special opening", testTime(2, 11, 0), closed(timePtr(testTime(2, 12, 0)))},
+		{"special day", testTime(2, 13, 0), open(timePtr(testTime(2, 18, 0)))},
+		{"after the special closing", testTime(2, 19, 0), closed(timePtr(testTime(4, 10, 0)))},
+		{"special closed day", testTime(3, 12, 0), closed(timePtr(testTime(4, 10, 0)))},
+		{"weekly day after a special one", testTime(4, 12, 0), open(timePtr(testTime(4, 22, 0)))},
+		{"special night", testTime(5, 23, 0), open(timePtr(testTime(6, 2, 0)))},
+		{"special night after midnight", testTime(6, 1, 0), open(timePtr(testTime(6, 2, 0)))},
+		{"special night instead of the weekly day", testTime(5, 12, 0), closed(timePtr(testTime(5, 20, 0)))},
+		{"sunday night before a closed monday", testTime(8, 1, 0), open(timePtr(testTime(8, 3, 0)))},
+		{"closed monday", testTime(8, 12, 0), closed(timePtr(testTime(9, 10, 0)))},
+		// the special sunday closes at 20:00 and replaces the weekly night
+		{"special sunday", testTime(14, 19, 0), open(timePtr(testTime(14, 20, 0)))},
+		{"special sunday evening", testTime(14, 23, 0), closed(timePtr(testTime(15, 10, 0)))},
+		{"monday after a special sunday", testTime(15, 1, 0), closed(timePtr(testTime(15, 10, 0)))},
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	mall := &Mall{
+		TimeZone: testZone,
+		WorkingHours: []*WorkPeriod{
+			weekPeriod(0, "10:00:00", 0, "22:00:00"),
+			weekPeriod(6, "22:00:00", 0, "03:00:00"),
+		},
+		SpecialHours: []*SpecialHours{specialHours("2024-01-02", "12:00:00", "18:00:00"), specialHours("2024-01-08")},
+	}
+	expected := []struct {
+		date    string
+		special bool
+		periods []*Period
+	}{
+		{"2024-01-01", false, []*Period{{testTime(1, 10, 0), testTime(1, 22, 0)}}},
+		{"2024-01-02", true, []*Period{{testTime(2, 12, 0), testTime(2, 18, 0)}}},
+		{"2024-01-03", false, nil},
+		{"2024-01-04", false, nil},
+		{"2024-01-05", false, nil},
+		{"2024-01-06", false, nil},
+		{"2024-01-07", false, []*Period{{testTime(7, 22, 0), testTime(8, 3, 0)}}},
+		{"2024-01-08", true, nil},
+	}
+	// the date is taken in the zone of the mall
+	schedule := mall.Schedule(time.Date(2023, time.December, 31, 22, 0, 0, 0, time.UTC), len(expected))
+	if len(schedule) != len(expected) {
+		t.Fatalf("Schedule has %d days, expected %d", len(schedule), len(expected))
+	}
+	for i, day := range schedule {
+		e := expected[i]
+		if day.Date != e.date || day.Special != e.special || len(day.Periods) != len(e.periods) {
+			t.Errorf("Day %d is %s special %t with %d periods, expected %s special %t with %d periods",
+				i, day.Date, day.Special, len(day.Periods), e.date, e.special, len(e.periods))
+			continue
+		}
+		for j, period := range day.Periods {
+			if !period.Open.Equal(e.periods[j].Open) || !period.Close.Equal(e.periods[j].Close) {
+				t.Errorf("Period %d of %s is %s - %s, expected %s - %s",
+					j, day.Date, period.Open, period.Close, e.periods[j].Open, e.periods[j].Close)
+			}
+		}
+	}
+}
+
+func TestSpecialHoursWindow(t *testing.T) {
+	from, to := SpecialHoursWindow(testTime(10, 12, 0))
+	if from != "2024-01-02" || to != "2024-02-11" {
+		t.Errorf("Special hours window is %s - %s, expected 2024-01-02 - 2024-02-11", from, to)
+	}
+}
@@ -1,8 +1,10 @@
 package models
 
 import (
-	"github.com/pkg/errors"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 type WeekTime struct {
@@ -15,11 +17,67 @@ type WorkPeriod struct {
 	Close WeekTime
 }
 
+// overrides the weekly working hours for a date
+type SpecialHours struct {
+	Date string
+	// nil means the mall is closed the whole day
+	Open  *string
+	Close *string
+}
+
 type Location struct {
 	Lat float64
 	Lon float64
 }
 
+// BoundingBox is a map viewport, in degrees
+type BoundingBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+func (bb *BoundingBox) Contains(location *Location) bool {
+	return location.Lon >= bb.MinLon && location.Lon <= bb.MaxLon && location.Lat >= bb.MinLat && location.Lat <= bb.MaxLat
+}
+
+func (bb *BoundingBox) Center() *Location {
+	return &Location{Lat: (bb.MinLat + bb.MaxLat) / 2, Lon: (bb.MinLon + bb.MaxLon) / 2}
+}
+
+type GeoFilter struct {
+	Near *Location
+	// in meters, requires Near
+	Radius *float64
+	BBox   *BoundingBox
+}
+
+// Origin is the point the distance is measured from: Near if it's set, otherwise the center of BBox.
+func (gf *GeoFilter) Origin() *Location {
+	if gf == nil {
+		return nil
+	}
+	if gf.Near != nil {
+		return gf.Near
+	}
+	if gf.BBox != nil {
+		return gf.BBox.Center()
+	}
+	return nil
+}
+
+// MallFilter is the filters of the malls list, nil fields are not applied.
+type MallFilter struct {
+	City           *int
+	Shop           *int
+	SubwayStation  *int
+	MaxWalkMinutes *int
+	Query          *string
+	OpenAt         *time.Time
+	Geo            *GeoFilter
+}
+
 type Logo struct {
 	Small string
 	Large string
@@ -28,6 +86,22 @@ type Logo struct {
 type SubwayStation struct {
 	ID   int
 	Name string
+	//Details
+	LineName  string
+	LineColor string
+	CityID    int
+	// nil if the coordinates are unknown
+	Location *Location
+	// set only by location queries
+	Distance *float64
+}
+
+// MallSubwayStation is a station linked to a mall with the walk between them.
+type MallSubwayStation struct {
+	Station *SubwayStation
+	// in meters
+	WalkDistance int
+	WalkMinutes  int
 }
 
 type Mall struct {
@@ -39,10 +113,40 @@ type Mall struct {
 	ShopsCount int
 	Address    string
 	//Details
-	Site         string
-	DayAndNight  bool
-	Subway       *SubwayStation
-	WorkingHours []*WorkPeriod
+	Site        string
+	DayAndNight bool
+	// ordered by the walk distance
+	SubwayStations []*MallSubwayStation
+	WorkingHours   []*WorkPeriod
+	SpecialHours   []*SpecialHours
+	// used to compute the open status, nil means UTC
+	TimeZone *time.Location
+	// name match score from 0 to 1, set only by name queries
+	Relevance float64
+	// in meters from the geo filter origin, nil if there is no origin
+	Distance *float64
+}
+
+type MallChanges struct {
+	Name     *string
+	Aliases  []string
+	Phone    *string
+	Logo     *Logo
+	Location *Location
+	Radius   *float64
+	Address  *string
+	Site     *string
+	// nil keeps the linked stations, empty removes them all
+	SubwayStations []*SubwayStationWalk
+	DayAndNight    *bool
+	CityID         *int
+}
+
+type SubwayStationWalk struct {
+	StationID int
+	// in meters
+	WalkDistance int
+	WalkMinutes  int
 }
 
 type Shop struct {
@@ -55,6 +159,21 @@ type Shop struct {
 	Phone       string
 	Site        string
 	NearestMall *Mall
+	// in meters, set with NearestMall when the user location is known, nil if the shop is in no mall
+	NearestDistance *float64
+	// name match score from 0 to 1, set only by name queries
+	Relevance float64
+	// where the shop is in the mall, set only by mall queries
+	Placement *ShopPlacement
+}
+
+type ShopChanges struct {
+	Name    *string
+	Aliases []string
+	Phone   *string
+	Site    *string
+	Logo    *Logo
+	Score   *int
 }
 
 type Category struct {
@@ -72,12 +191,34 @@ type City struct {
 type MallMatchedShops struct {
 	MallID  int
 	ShopIDs []int
+	// in the order of ShopIDs
+	Placements []*ShopPlacement
 }
 
 type SearchResult struct {
 	Mall     *Mall
 	ShopIDs  []int
 	Distance *float64
+	// set only for search along a route: distance to the path and how far along the path the mall is, in meters
+	RouteDistance *float64
+	RoutePosition *float64
+}
+
+const (
+	MallSuggestion          = "mall"
+	ShopSuggestion          = "shop"
+	CategorySuggestion      = "category"
+	SubwayStationSuggestion = "subway_station"
+)
+
+type Suggestion struct {
+	Type string
+	ID   int
+	Name string
+	// the name or alias that matched the query
+	MatchedAlias string
+	// from 0 to 1, prefix matches score higher than 0.5
+	Score float64
 }
 
 type Sorting interface {
@@ -107,8 +248,18 @@ const (
 	DistanceSortKey   = "distance"
 	MallNameSortKey   = "mall_name"
 	MallIDSortKey     = "mall_id"
+	// only for name queries, the most relevant go first
+	RelevanceSortKey = "relevance"
+	// only for search along a route
+	RouteDistanceSortKey = "route_distance"
+	// shops by the distance to their nearest mall, only with the user location
+	NearestSortKey = "nearest"
 )
 
+// NoMallDistance stands for the nearest mall distance of the shops that are in no mall,
+// it's farther than any two points on Earth, so they go last.
+const NoMallDistance = 1e9
+
 const REVERSE_SIGN = "-"
 
 var (
@@ -116,15 +267,22 @@ var (
 	DefaultShopSorting     = DefaultMallSorting
 	DefaultCategorySorting = DefaultMallSorting
 	DefaultCitySorting     = DefaultMallSorting
+	DefaultStationSorting  = DefaultMallSorting
 	DefaultSearchSorting   = &sorting{key: MallIDSortKey, reversed: false}
+	// default for malls and shops queried by name
+	DefaultRelevanceSorting = &sorting{key: RelevanceSortKey, reversed: false}
+	// default for stations near a location
+	DefaultDistanceSorting = &sorting{key: DistanceSortKey, reversed: false}
+	// default for search along a route
+	DefaultRouteSearchSorting = &sorting{key: RouteDistanceSortKey, reversed: false}
 )
 
 func MallSorting(rawSorting string) (Sorting, error) {
-	return modelSorting(rawSorting, IDSortKey, NameSortKey, ShopsCountSortKey)
+	return modelSorting(rawSorting, IDSortKey, NameSortKey, ShopsCountSortKey, RelevanceSortKey, DistanceSortKey)
 }
 
 func ShopSorting(rawSorting string) (Sorting, error) {
-	return modelSorting(rawSorting, IDSortKey, NameSortKey, ScoreSortKey, MallsCountSortKey)
+	return modelSorting(rawSorting, IDSortKey, NameSortKey, ScoreSortKey, MallsCountSortKey, RelevanceSortKey, NearestSortKey)
 }
 
 func CategorySorting(rawSorting string) (Sorting, error) {
@@ -135,8 +293,12 @@ func CitySorting(rawSorting string) (Sorting, error) {
 	return modelSorting(rawSorting, IDSortKey, NameSortKey)
 }
 
+func SubwayStationSorting(rawSorting string) (Sorting, error) {
+	return modelSorting(rawSorting, IDSortKey, NameSortKey, DistanceSortKey)
+}
+
 func SearchSorting(rawSorting string) (Sorting, error) {
-	return modelSorting(rawSorting, MallIDSortKey, MallNameSortKey, ShopsCountSortKey, DistanceSortKey)
+	return modelSorting(rawSorting, MallIDSortKey, MallNameSortKey, ShopsCountSortKey, DistanceSortKey, RouteDistanceSortKey)
 }
 
 func modelSorting(rawSorting string, validSortKeys ...string) (Sorting, error) {
@@ -149,3 +311,8 @@ func modelSorting(rawSorting string, validSortKeys ...string) (Sorting, error) {
 	}
 	return nil, errors.Errorf("Unsupported sort key: %s, valid values: %v", sortKey, validSortKeys)
 }
+
+// IsDescending tells the actual order direction, relevance is the only key that goes from the largest values.
+func IsDescending(sorting Sorting) bool {
+	return sorting.Reversed() != (sorting.Key() == RelevanceSortKey)
+}
@@ -0,0 +1,38 @@
+package models
+
+type ShopPlacement struct {
+	// nil if unknown, negative for the underground floors
+	Floor    *int
+	Unit     string
+	Entrance string
+	// overrides the shop phone in the mall if not empty
+	Phone string
+}
+
+type DirectoryFloor struct {
+	// nil for the shops on an unknown floor
+	Floor *int
+	Shops []*Shop
+}
+
+// GroupShopsByFloor groups the shops of a mall directory by their floors, the shops must be placed and sorted by floor.
+func GroupShopsByFloor(shops []*Shop) []*DirectoryFloor {
+	floors := []*DirectoryFloor{}
+	var current *DirectoryFloor
+	for _, shop := range shops {
+		floor := shop.Placement.Floor
+		if current == nil || !sameFloor(current.Floor, floor) {
+			current = &DirectoryFloor{Floor: floor}
+			floors = append(floors, current)
+		}
+		current.Shops = append(current.Shops, shop)
+	}
+	return floors
+}
+
+func sameFloor(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
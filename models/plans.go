@@ -0,0 +1,74 @@
+package models
+
+type PlanStop struct {
+	Mall *Mall
+	// the requested shops to visit in this mall
+	ShopIDs  []int
+	Distance *float64
+}
+
+type ShoppingPlan struct {
+	Stops []*PlanStop
+	// the requested shops that no mall has
+	MissingShopIDs []int
+}
+
+// PlanShopping covers the shops with as few malls as the greedy set cover gets:
+// every next stop is the mall with the most shops not covered yet, the nearest one of equal malls.
+// Candidates are search results for the shops, so they are sorted by mall id.
+func PlanShopping(shopIDs []int, candidates []*SearchResult) *ShoppingPlan {
+	uncovered := map[int]bool{}
+	var requested []int
+	for _, shopID := range shopIDs {
+		if !uncovered[shopID] {
+			uncovered[shopID] = true
+			requested = append(requested, shopID)
+		}
+	}
+	plan := &ShoppingPlan{}
+	used := make([]bool, len(candidates))
+	for len(uncovered) != 0 {
+		best := -1
+		var bestShops []int
+		for i, candidate := range candidates {
+			if used[i] {
+				continue
+			}
+			var shops []int
+			for _, shopID := range candidate.ShopIDs {
+				if uncovered[shopID] {
+					shops = append(shops, shopID)
+				}
+			}
+			if len(shops) == 0 {
+				continue
+			}
+			if best == -1 || len(shops) > len(bestShops) ||
+				len(shops) == len(bestShops) && isNearer(candidate.Distance, candidates[best].Distance) {
+				best, bestShops = i, shops
+			}
+		}
+		if best == -1 {
+			break
+		}
+		used[best] = true
+		for _, shopID := range bestShops {
+			delete(uncovered, shopID)
+		}
+		plan.Stops = append(plan.Stops, &PlanStop{
+			Mall:     candidates[best].Mall,
+			ShopIDs:  bestShops,
+			Distance: candidates[best].Distance,
+		})
+	}
+	for _, shopID := range requested {
+		if uncovered[shopID] {
+			plan.MissingShopIDs = append(plan.MissingShopIDs, shopID)
+		}
+	}
+	return plan
+}
+
+func isNearer(distance, other *float64) bool {
+	return distance != nil && other != nil && *distance < *other
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"github.com/pkg/errors"
+)
+
+// RouteFilter keeps malls that are at most MaxDetour meters away from the path.
+type RouteFilter struct {
+	// at least two points
+	Path []Location
+	// in meters
+	MaxDetour float64
+}
+
+// DecodePolyline decodes the encoded polyline format of map services, with the precision of 5 digits.
+func DecodePolyline(encoded string) ([]Location, error) {
+	var path []Location
+	var lat, lon int64
+	for i := 0; i < len(encoded); {
+		var deltas [2]int64
+		for j := range deltas {
+			var result int64
+			var shift uint
+			for {
+				if i >= len(encoded) {
+					return nil, errors.New("polyline is truncated")
+				}
+				b := int64(encoded[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, errors.Errorf("unexpected polyline character %q", encoded[i-1])
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+				if shift > 30 {
+					return nil, errors.New("polyline value is too long")
+				}
+			}
+			if result&1 != 0 {
+				deltas[j] = ^(result >> 1)
+			} else {
+				deltas[j] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lon += deltas[1]
+		path = append(path, Location{Lat: float64(lat) / 1e5, Lon: float64(lon) / 1e5})
+	}
+	return path, nil
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// SlowQuery is a query that took longer than the threshold, Plan is set if it was explained.
+type SlowQuery struct {
+	QueryName string
+	Statement string
+	Duration  time.Duration
+	RequestID string
+	StartedAt time.Time
+	// EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) output
+	Plan []byte
+}
@@ -9,6 +9,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"gopkg.in/redis.v5"
 	"mallfin_api/logging"
+	"mallfin_api/metrics"
 )
 
 const NumberOfDatabases = 16
@@ -44,6 +45,16 @@ func Initialization() {
 	once.Do(func() {
 		newDB := CreateNewDB(config.Redis().DB)
 		db = newDB
+		metrics.RegisterPool("redis", func() *metrics.PoolStats {
+			stats := newDB.PoolStats()
+			return &metrics.PoolStats{
+				Requests:   stats.Requests,
+				Hits:       stats.Hits,
+				Timeouts:   stats.Timeouts,
+				TotalConns: stats.TotalConns,
+				FreeConns:  stats.FreeConns,
+			}
+		})
 	})
 }
 
@@ -0,0 +1,185 @@
+package serializers
+
+import (
+	"mallfin_api/models"
+)
+
+const (
+	// value of the format query param
+	GeoJSONFormat      = "geojson"
+	GeoJSONContentType = "application/geo+json"
+)
+
+type Point struct {
+	Type string `json:"type"`
+	// longitude goes first
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// Polygon of an indoor floor plan, in its local coordinates: x and y in meters from the bottom left corner.
+type Polygon struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+type Feature struct {
+	Type string `json:"type"`
+	// *Point or *Polygon
+	Geometry   interface{} `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+type GeoJSONPagination struct {
+	Count      int     `json:"count"`
+	TotalCount int     `json:"total_count"`
+	Next       *string `json:"next"`
+	Prev       *string `json:"prev"`
+}
+
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+	// foreign member, nil for not paginated responses
+	Pagination *GeoJSONPagination `json:"pagination,omitempty"`
+}
+
+type FloorPlanProperties struct {
+	Floor      int     `json:"floor"`
+	Name       string  `json:"name"`
+	PlanImage  string  `json:"plan_image"`
+	Width      float64 `json:"width"`
+	Height     float64 `json:"height"`
+	UnitsCount int     `json:"units_count"`
+}
+
+type FloorUnitProperties struct {
+	ID    int    `json:"id"`
+	Unit  string `json:"unit"`
+	Floor int    `json:"floor"`
+	// nil for the vacant units
+	Shop *ShopBase `json:"shop"`
+}
+
+// FloorPlanCollection is the units of a floor, the floor properties are foreign members.
+type FloorPlanCollection struct {
+	Type string `json:"type"`
+	*FloorPlanProperties
+	Features []*Feature `json:"features"`
+}
+
+type SearchResultProperties struct {
+	*MallBase
+	ShopIDs       []int    `json:"shops"`
+	Distance      *float64 `json:"distance"`
+	RouteDistance *float64 `json:"route_distance,omitempty"`
+	RoutePosition *float64 `json:"route_position,omitempty"`
+}
+
+func newFeature(location *Location, properties interface{}) *Feature {
+	return &Feature{
+		Type:       "Feature",
+		Geometry:   &Point{Type: "Point", Coordinates: [2]float64{location.Lon, location.Lat}},
+		Properties: properties,
+	}
+}
+
+func NewFeatureCollection(features []*Feature) *FeatureCollection {
+	return &FeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+func MallsFeatures(malls []*MallBase) []*Feature {
+	features := make([]*Feature, len(malls))
+	for i, mall := range malls {
+		features[i] = newFeature(mall.Location, mall)
+	}
+	return features
+}
+
+func MallFeature(mall *MallDetails) *Feature {
+	return newFeature(mall.Location, mall)
+}
+
+func SearchResultsFeatures(searchResults []*SearchResult) []*Feature {
+	features := make([]*Feature, len(searchResults))
+	for i, searchResult := range searchResults {
+		properties := &SearchResultProperties{
+			MallBase:      searchResult.Mall,
+			ShopIDs:       searchResult.ShopIDs,
+			Distance:      searchResult.Distance,
+			RouteDistance: searchResult.RouteDistance,
+			RoutePosition: searchResult.RoutePosition,
+		}
+		features[i] = newFeature(searchResult.Mall.Location, properties)
+	}
+	return features
+}
+
+func serializeFloorPlanProperties(plan *models.FloorPlan) *FloorPlanProperties {
+	serializer := &FloorPlanProperties{
+		Floor:      plan.Floor,
+		Name:       plan.Name,
+		PlanImage:  plan.PlanImage,
+		Width:      plan.Width,
+		Height:     plan.Height,
+		UnitsCount: plan.UnitsCount,
+	}
+	return serializer
+}
+
+func newPolygon(rings [][]models.IndoorPoint) *Polygon {
+	coordinates := make([][][2]float64, len(rings))
+	for i, ring := range rings {
+		coordinates[i] = make([][2]float64, len(ring))
+		for j, point := range ring {
+			coordinates[i][j] = [2]float64{point.X, point.Y}
+		}
+	}
+	return &Polygon{Type: "Polygon", Coordinates: coordinates}
+}
+
+// SerializeMallFloors makes a feature of every floor with the plan extent as the geometry.
+func SerializeMallFloors(plans []*models.FloorPlan) *FeatureCollection {
+	features := make([]*Feature, len(plans))
+	for i, plan := range plans {
+		extent := []models.IndoorPoint{{X: 0, Y: 0}, {X: plan.Width, Y: 0}, {X: plan.Width, Y: plan.Height}, {X: 0, Y: plan.Height}, {X: 0, Y: 0}}
+		features[i] = &Feature{
+			Type:       "Feature",
+			Geometry:   newPolygon([][]models.IndoorPoint{extent}),
+			Properties: serializeFloorPlanProperties(plan),
+		}
+	}
+	return NewFeatureCollection(features)
+}
+
+// FloorUnitFeature serializes the unit with its shop, shop is nil for the vacant units.
+func FloorUnitFeature(unit *models.FloorUnit, floor int, shop *models.Shop) *Feature {
+	properties := &FloorUnitProperties{
+		ID:    unit.ID,
+		Unit:  unit.Unit,
+		Floor: floor,
+	}
+	if shop != nil {
+		properties.Shop = serializeShopBase(shop)
+	}
+	return &Feature{
+		Type:       "Feature",
+		Geometry:   newPolygon(unit.Polygon),
+		Properties: properties,
+	}
+}
+
+func SerializeFloorPlan(plan *models.FloorPlan, shops map[int]*models.Shop) *FloorPlanCollection {
+	features := make([]*Feature, len(plan.Units))
+	for i, unit := range plan.Units {
+		var shop *models.Shop
+		if unit.ShopID != nil {
+			shop = shops[*unit.ShopID]
+		}
+		features[i] = FloorUnitFeature(unit, plan.Floor, shop)
+	}
+	return &FloorPlanCollection{
+		Type:                "FeatureCollection",
+		FloorPlanProperties: serializeFloorPlanProperties(plan),
+		Features:            features,
+	}
+}
@@ -1,6 +1,9 @@
 package serializers
 
 import (
+	"encoding/json"
+	"time"
+
 	"mallfin_api/models"
 )
 
@@ -19,6 +22,15 @@ type SubwayStation struct {
 	Name string `json:"name"`
 }
 
+type MallSubwayStation struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	LineName     string `json:"line_name"`
+	LineColor    string `json:"line_color"`
+	WalkDistance int    `json:"walk_distance"`
+	WalkMinutes  int    `json:"walk_minutes"`
+}
+
 type WeekTime struct {
 	Time string `json:"time"`
 	Day  int    `json:"day"`
@@ -30,21 +42,39 @@ type WorkPeriod struct {
 }
 
 type MallBase struct {
-	ID         int       `json:"id"`
-	Name       string    `json:"name"`
-	Phone      string    `json:"phone"`
-	Logo       *Logo     `json:"logo"`
-	Location   *Location `json:"location"`
-	ShopsCount int       `json:"shops_count"`
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Phone      string     `json:"phone"`
+	Logo       *Logo      `json:"logo"`
+	Location   *Location  `json:"location"`
+	ShopsCount int        `json:"shops_count"`
+	IsOpen     bool       `json:"is_open"`
+	OpensAt    *time.Time `json:"opens_at"`
+	ClosesAt   *time.Time `json:"closes_at"`
+	Distance   *float64   `json:"distance,omitempty"`
+}
+
+type SchedulePeriod struct {
+	Opening time.Time `json:"opening"`
+	Closing time.Time `json:"closing"`
+}
+
+type ScheduleDay struct {
+	Date    string            `json:"date"`
+	Special bool              `json:"special"`
+	Periods []*SchedulePeriod `json:"periods"`
 }
 
 type MallDetails struct {
 	*MallBase
-	Address       string         `json:"address"`
-	Site          string         `json:"site"`
-	DayAndNight   bool           `json:"day_and_night"`
-	WorkingHours  []*WorkPeriod  `json:"working_hours"`
-	SubwayStation *SubwayStation `json:"subway_staion"`
+	Address      string         `json:"address"`
+	Site         string         `json:"site"`
+	DayAndNight  bool           `json:"day_and_night"`
+	WorkingHours []*WorkPeriod  `json:"working_hours"`
+	Schedule     []*ScheduleDay `json:"schedule"`
+	// the nearest of the subway stations
+	SubwayStation  *SubwayStation       `json:"subway_staion"`
+	SubwayStations []*MallSubwayStation `json:"subway_stations"`
 }
 
 type ShopBase struct {
@@ -53,12 +83,30 @@ type ShopBase struct {
 	Logo       *Logo  `json:"logo"`
 	Score      int    `json:"score"`
 	MallsCount int    `json:"malls_count"`
+	// only in lists requested with the user location
+	NearestMall     *MallBase `json:"nearest_mall,omitempty"`
+	NearestDistance *float64  `json:"nearest_distance,omitempty"`
+	// only in the shops of a mall
+	Placement *ShopPlacement `json:"placement,omitempty"`
+}
+
+type ShopPlacement struct {
+	Floor    *int   `json:"floor"`
+	Unit     string `json:"unit"`
+	Entrance string `json:"entrance"`
+	Phone    string `json:"phone"`
+}
+
+type DirectoryFloor struct {
+	Floor *int        `json:"floor"`
+	Shops []*ShopBase `json:"shops"`
 }
 
 type ShopDetails struct {
 	*ShopBase
-	Phone       string    `json:"phone"`
-	Site        string    `json:"site"`
+	Phone string `json:"phone"`
+	Site  string `json:"site"`
+	// overrides the list field, details always have it
 	NearestMall *MallBase `json:"nearest_mall"`
 }
 
@@ -82,15 +130,64 @@ type CityDetails struct {
 	*CityBase
 }
 
+type SubwayStationBase struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	LineName  string    `json:"line_name"`
+	LineColor string    `json:"line_color"`
+	Location  *Location `json:"location"`
+	CityID    int       `json:"city"`
+	// only in queries with the user location
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+type SubwayStationDetails struct {
+	*SubwayStationBase
+}
+
 type SearchResult struct {
+	Mall          *MallBase `json:"mall"`
+	ShopIDs       []int     `json:"shops"`
+	Distance      *float64  `json:"distance"`
+	RouteDistance *float64  `json:"route_distance,omitempty"`
+	RoutePosition *float64  `json:"route_position,omitempty"`
+}
+
+type Suggestion struct {
+	Type         string  `json:"type"`
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	MatchedAlias string  `json:"matched_alias"`
+	Score        float64 `json:"score"`
+}
+
+type MallCluster struct {
+	Location *Location `json:"location"`
+	Count    int       `json:"count"`
+	// null for big clusters
+	MallIDs []int `json:"malls"`
+}
+
+type PlanStop struct {
 	Mall     *MallBase `json:"mall"`
 	ShopIDs  []int     `json:"shops"`
 	Distance *float64  `json:"distance"`
 }
 
+type ShoppingPlan struct {
+	Stops          []*PlanStop `json:"stops"`
+	MissingShopIDs []int       `json:"missing_shops"`
+}
+
 type ShopsInMall struct {
-	MallID  int   `json:"mall"`
-	ShopIDs []int `json:"shops"`
+	MallID     int                  `json:"mall"`
+	ShopIDs    []int                `json:"shops"`
+	Placements []*ShopMallPlacement `json:"placements"`
+}
+
+type ShopMallPlacement struct {
+	ShopID int `json:"shop"`
+	*ShopPlacement
 }
 
 func SerializeShopsInMalls(mallsShops []*models.MallMatchedShops) []*ShopsInMall {
@@ -100,12 +197,17 @@ func SerializeShopsInMalls(mallsShops []*models.MallMatchedShops) []*ShopsInMall
 		if shops == nil {
 			shops = []int{}
 		}
-		serializer[i] = &ShopsInMall{MallID: v.MallID, ShopIDs: shops}
+		placements := make([]*ShopMallPlacement, len(v.Placements))
+		for j, placement := range v.Placements {
+			placements[j] = &ShopMallPlacement{ShopID: shops[j], ShopPlacement: serializeShopPlacement(placement)}
+		}
+		serializer[i] = &ShopsInMall{MallID: v.MallID, ShopIDs: shops, Placements: placements}
 	}
 	return serializer
 }
 
 func serializeMallBase(mall *models.Mall) *MallBase {
+	status := mall.OpenStatus(time.Now())
 	serializer := &MallBase{
 		ID:    mall.ID,
 		Name:  mall.Name,
@@ -119,6 +221,10 @@ func serializeMallBase(mall *models.Mall) *MallBase {
 			Lon: mall.Location.Lon,
 		},
 		ShopsCount: mall.ShopsCount,
+		IsOpen:     status.IsOpen,
+		OpensAt:    status.OpensAt,
+		ClosesAt:   status.ClosesAt,
+		Distance:   mall.Distance,
 	}
 	return serializer
 }
@@ -131,8 +237,25 @@ func serializeShopBase(shop *models.Shop) *ShopBase {
 			Large: shop.Logo.Large,
 			Small: shop.Logo.Small,
 		},
-		Score:      shop.Score,
-		MallsCount: shop.MallsCount,
+		Score:           shop.Score,
+		MallsCount:      shop.MallsCount,
+		NearestDistance: shop.NearestDistance,
+	}
+	if shop.NearestMall != nil {
+		serializer.NearestMall = serializeMallBase(shop.NearestMall)
+	}
+	if shop.Placement != nil {
+		serializer.Placement = serializeShopPlacement(shop.Placement)
+	}
+	return serializer
+}
+
+func serializeShopPlacement(placement *models.ShopPlacement) *ShopPlacement {
+	serializer := &ShopPlacement{
+		Floor:    placement.Floor,
+		Unit:     placement.Unit,
+		Entrance: placement.Entrance,
+		Phone:    placement.Phone,
 	}
 	return serializer
 }
@@ -158,7 +281,70 @@ func serializeCityBase(city *models.City) *CityBase {
 	return serializer
 }
 
-func SerializeMall(mall *models.Mall) *MallDetails {
+func serializeSubwayStationBase(station *models.SubwayStation) *SubwayStationBase {
+	serializer := &SubwayStationBase{
+		ID:        station.ID,
+		Name:      station.Name,
+		LineName:  station.LineName,
+		LineColor: station.LineColor,
+		CityID:    station.CityID,
+		Distance:  station.Distance,
+	}
+	if station.Location != nil {
+		serializer.Location = &Location{
+			Lat: station.Location.Lat,
+			Lon: station.Location.Lon,
+		}
+	}
+	return serializer
+}
+
+func serializeSchedule(schedule []*models.DaySchedule) []*ScheduleDay {
+	serializer := make([]*ScheduleDay, len(schedule))
+	for i, day := range schedule {
+		periods := make([]*SchedulePeriod, len(day.Periods))
+		for j, period := range day.Periods {
+			periods[j] = &SchedulePeriod{Opening: period.Open, Closing: period.Close}
+		}
+		serializer[i] = &ScheduleDay{Date: day.Date, Special: day.Special, Periods: periods}
+	}
+	return serializer
+}
+
+type SlowQuery struct {
+	QueryName  string    `json:"query_name"`
+	Statement  string    `json:"statement"`
+	DurationMs float64   `json:"duration_ms"`
+	RequestID  string    `json:"request_id"`
+	StartedAt  time.Time `json:"started_at"`
+	// null if the query was not explained
+	Plan json.RawMessage `json:"plan"`
+}
+
+type DependencyCheck struct {
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     *string `json:"error"`
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+type Readiness struct {
+	// "ready", "unready" or "shutting_down"
+	Status                string                      `json:"status"`
+	Checks                map[string]*DependencyCheck `json:"checks"`
+	SchemaVersion         int                         `json:"schema_version"`
+	ExpectedSchemaVersion int                         `json:"expected_schema_version"`
+	Build                 *BuildInfo                  `json:"build"`
+	StartedAt             time.Time                   `json:"started_at"`
+}
+
+func SerializeMall(mall *models.Mall, scheduleDays int) *MallDetails {
 	workingHours := make([]*WorkPeriod, len(mall.WorkingHours))
 	for i := range mall.WorkingHours {
 		period := mall.WorkingHours[i]
@@ -174,26 +360,42 @@ func SerializeMall(mall *models.Mall) *MallDetails {
 		}
 	}
 	var subwayStation *SubwayStation
-	if mall.Subway != nil {
-		subwayStation = &SubwayStation{ID: mall.Subway.ID, Name: mall.Subway.Name}
+	subwayStations := make([]*MallSubwayStation, len(mall.SubwayStations))
+	for i, walk := range mall.SubwayStations {
+		station := walk.Station
+		if i == 0 {
+			subwayStation = &SubwayStation{ID: station.ID, Name: station.Name}
+		}
+		subwayStations[i] = &MallSubwayStation{
+			ID:           station.ID,
+			Name:         station.Name,
+			LineName:     station.LineName,
+			LineColor:    station.LineColor,
+			WalkDistance: walk.WalkDistance,
+			WalkMinutes:  walk.WalkMinutes,
+		}
 	}
 	serializer := &MallDetails{
-		MallBase:      serializeMallBase(mall),
-		Address:       mall.Address,
-		Site:          mall.Site,
-		DayAndNight:   mall.DayAndNight,
-		WorkingHours:  workingHours,
-		SubwayStation: subwayStation,
+		MallBase:       serializeMallBase(mall),
+		Address:        mall.Address,
+		Site:           mall.Site,
+		DayAndNight:    mall.DayAndNight,
+		WorkingHours:   workingHours,
+		Schedule:       serializeSchedule(mall.Schedule(time.Now(), scheduleDays)),
+		SubwayStation:  subwayStation,
+		SubwayStations: subwayStations,
 	}
 	return serializer
 }
 
 func SerializeShop(shop *models.Shop) *ShopDetails {
 	serializer := &ShopDetails{
-		ShopBase:    serializeShopBase(shop),
-		Phone:       shop.Phone,
-		Site:        shop.Site,
-		NearestMall: serializeMallBase(shop.NearestMall),
+		ShopBase: serializeShopBase(shop),
+		Phone:    shop.Phone,
+		Site:     shop.Site,
+	}
+	if shop.NearestMall != nil {
+		serializer.NearestMall = serializeMallBase(shop.NearestMall)
 	}
 	return serializer
 }
@@ -212,6 +414,17 @@ func SerializeCity(city *models.City) *CityDetails {
 	return serializer
 }
 
+func SerializeSubwayStation(station *models.SubwayStation) *SubwayStationDetails {
+	serializer := &SubwayStationDetails{
+		SubwayStationBase: serializeSubwayStationBase(station),
+	}
+	return serializer
+}
+
+func SerializeShopPlacement(placement *models.ShopPlacement) *ShopPlacement {
+	return serializeShopPlacement(placement)
+}
+
 func SerializeMalls(malls []*models.Mall) []*MallBase {
 	serializers := make([]*MallBase, len(malls))
 	for i := range malls {
@@ -248,6 +461,23 @@ func SerializeCities(cities []*models.City) []*CityBase {
 	return serializers
 }
 
+func SerializeSubwayStations(stations []*models.SubwayStation) []*SubwayStationBase {
+	serializers := make([]*SubwayStationBase, len(stations))
+	for i := range stations {
+		station := stations[i]
+		serializers[i] = serializeSubwayStationBase(station)
+	}
+	return serializers
+}
+
+func SerializeMallDirectory(floors []*models.DirectoryFloor) []*DirectoryFloor {
+	serializers := make([]*DirectoryFloor, len(floors))
+	for i, floor := range floors {
+		serializers[i] = &DirectoryFloor{Floor: floor.Floor, Shops: SerializeShops(floor.Shops)}
+	}
+	return serializers
+}
+
 func SerializeSearchResults(searchResults []*models.SearchResult) []*SearchResult {
 	serializers := make([]*SearchResult, len(searchResults))
 	for i := range searchResults {
@@ -257,10 +487,107 @@ func SerializeSearchResults(searchResults []*models.SearchResult) []*SearchResul
 			shopIDs = []int{}
 		}
 		serializers[i] = &SearchResult{
-			Mall:     serializeMallBase(searchResult.Mall),
-			ShopIDs:  shopIDs,
-			Distance: searchResult.Distance,
+			Mall:          serializeMallBase(searchResult.Mall),
+			ShopIDs:       shopIDs,
+			Distance:      searchResult.Distance,
+			RouteDistance: searchResult.RouteDistance,
+			RoutePosition: searchResult.RoutePosition,
+		}
+	}
+	return serializers
+}
+
+func SerializeSuggestions(suggestions []*models.Suggestion) []*Suggestion {
+	serializers := make([]*Suggestion, len(suggestions))
+	for i, suggestion := range suggestions {
+		serializers[i] = &Suggestion{
+			Type:         suggestion.Type,
+			ID:           suggestion.ID,
+			Name:         suggestion.Name,
+			MatchedAlias: suggestion.MatchedAlias,
+			Score:        suggestion.Score,
 		}
 	}
 	return serializers
 }
+
+func SerializeMallClusters(clusters []*models.MallCluster) []*MallCluster {
+	serializers := make([]*MallCluster, len(clusters))
+	for i, cluster := range clusters {
+		serializers[i] = &MallCluster{
+			Location: &Location{
+				Lat: cluster.Center.Lat,
+				Lon: cluster.Center.Lon,
+			},
+			Count:   cluster.Count,
+			MallIDs: cluster.MallIDs,
+		}
+	}
+	return serializers
+}
+
+func SerializeShoppingPlan(plan *models.ShoppingPlan) *ShoppingPlan {
+	stops := make([]*PlanStop, len(plan.Stops))
+	for i, stop := range plan.Stops {
+		stops[i] = &PlanStop{
+			Mall:     serializeMallBase(stop.Mall),
+			ShopIDs:  stop.ShopIDs,
+			Distance: stop.Distance,
+		}
+	}
+	missingShopIDs := plan.MissingShopIDs
+	if missingShopIDs == nil {
+		missingShopIDs = []int{}
+	}
+	return &ShoppingPlan{Stops: stops, MissingShopIDs: missingShopIDs}
+}
+
+func SerializeSlowQueries(slowQueries []*models.SlowQuery) []*SlowQuery {
+	serializers := make([]*SlowQuery, len(slowQueries))
+	for i, slowQuery := range slowQueries {
+		serializers[i] = &SlowQuery{
+			QueryName:  slowQuery.QueryName,
+			Statement:  slowQuery.Statement,
+			DurationMs: float64(slowQuery.Duration) / float64(time.Millisecond),
+			RequestID:  slowQuery.RequestID,
+			StartedAt:  slowQuery.StartedAt,
+			Plan:       slowQuery.Plan,
+		}
+	}
+	return serializers
+}
+
+func SerializeReadiness(readiness *models.Readiness) *Readiness {
+	status := "ready"
+	if readiness.ShuttingDown {
+		status = "shutting_down"
+	} else if !readiness.Ready() {
+		status = "unready"
+	}
+	checks := make(map[string]*DependencyCheck, len(readiness.Checks))
+	for _, check := range readiness.Checks {
+		serializer := &DependencyCheck{
+			OK:        check.Err == nil,
+			LatencyMs: float64(check.Latency) / float64(time.Millisecond),
+		}
+		if check.Err != nil {
+			message := check.Err.Error()
+			serializer.Error = &message
+		}
+		checks[check.Name] = serializer
+	}
+	build := readiness.Build
+	return &Readiness{
+		Status:                status,
+		Checks:                checks,
+		SchemaVersion:         readiness.SchemaVersion,
+		ExpectedSchemaVersion: readiness.ExpectedSchemaVersion,
+		Build: &BuildInfo{
+			Version:   build.Version,
+			Commit:    build.Commit,
+			BuildTime: build.BuildTime,
+			GoVersion: build.GoVersion,
+		},
+		StartedAt: readiness.StartedAt,
+	}
+}
@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"mallfin_api/config"
+	"mallfin_api/logging"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	OTLPExporter   = "otlp"
+	StdoutExporter = "stdout"
+	FileExporter   = "file"
+	W3CPropagation = "w3c"
+
+	instrumentationName = "mallfin_api"
+	shutdownTimeout     = 5 * time.Second
+)
+
+var (
+	provider  *sdktrace.TracerProvider
+	traceFile *os.File
+	// whether the incoming traceparent headers are trusted
+	propagate bool
+	logger    = logging.WithPackage("tracing")
+	once      sync.Once
+)
+
+// Initialization sets up the exporter from the config, without it the spans are no-op.
+func Initialization() {
+	once.Do(func() {
+		conf := config.Tracing()
+		if conf == nil {
+			return
+		}
+		propagate = conf.Propagation == W3CPropagation
+		if conf.Exporter == "" {
+			return
+		}
+		exporter, err := newExporter(conf)
+		if err != nil {
+			logger.WithField("exporter", conf.Exporter).Panicf("Cannot create trace exporter: %s", err)
+		}
+		res := resource.NewSchemaless(
+			attribute.String("service.name", config.ServiceName()),
+			attribute.String("service.instance.id", config.ServerID()),
+		)
+		provider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+		otel.SetTracerProvider(provider)
+	})
+}
+
+func newExporter(conf *config.TracingSettings) (sdktrace.SpanExporter, error) {
+	switch conf.Exporter {
+	case OTLPExporter:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), options...)
+	case StdoutExporter:
+		return stdouttrace.New()
+	case FileExporter:
+		file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot open trace file %s", conf.File)
+		}
+		traceFile = file
+		return stdouttrace.New(stdouttrace.WithWriter(file))
+	}
+	return nil, errors.Errorf("unknown exporter %q", conf.Exporter)
+}
+
+// Close flushes the spans that are not exported yet.
+func Close() {
+	if provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			logger.Errorf("Cannot flush spans: %s", err)
+		}
+	}
+	if traceFile != nil {
+		traceFile.Close()
+	}
+}
+
+// TraceID is empty if the request is not traced.
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanFromContext(ctx).SpanContext()
+	if !spanContext.IsValid() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// StartServerSpan starts the span of the request, named by the route template to keep the names few.
+func StartServerSpan(r *http.Request, route string) (context.Context, trace.Span) {
+	ctx := r.Context()
+	if propagate {
+		ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	}
+	return tracer().Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.target", r.URL.RequestURI()),
+			attribute.String("request_id", FromContext(ctx)),
+		),
+	)
+}
+
+func EndServerSpan(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// StartClientSpan starts a span of a call to postgres or redis.
+func StartClientSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+// EndSpan marks the span as failed if there is an error.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartRedisSpan names the span by the command, the key is an attribute.
+func StartRedisSpan(ctx context.Context, command, key string) (context.Context, trace.Span) {
+	return StartClientSpan(ctx, "redis "+command,
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", command),
+		attribute.String("db.redis.key", key),
+	)
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentSpanID = "00f067aa0ba902b7"
+	testTraceparent  = "00-" + testTraceID + "-" + testParentSpanID + "-01"
+)
+
+// recordSpans makes the spans of the test end up in the recorder instead of the exporter from the config.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+	return recorder
+}
+
+func setPropagation(t *testing.T, enabled bool) {
+	previous := propagate
+	propagate = enabled
+	t.Cleanup(func() {
+		propagate = previous
+	})
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func checkAttributes(t *testing.T, span sdktrace.ReadOnlySpan, expected map[attribute.Key]interface{}) {
+	t.Helper()
+	attributes := spanAttributes(span)
+	for key, value := range expected {
+		if actual, ok := attributes[key]; !ok || actual.AsInterface() != value {
+			t.Errorf("span %s: %s is %v, expected %v", span.Name(), key, actual.AsInterface(), value)
+		}
+	}
+}
+
+func newTestRequest(requestID string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/malls/5/?fields=name", nil)
+	r.Header.Set("traceparent", testTraceparent)
+	return r.WithContext(NewContext(r.Context(), requestID))
+}
+
+func TestServerSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	setPropagation(t, false)
+
+	_, span := StartServerSpan(newTestRequest("request-1"), "/malls/:id/")
+	EndServerSpan(span, http.StatusNotFound)
+	_, span = StartServerSpan(newTestRequest("request-2"), "/malls/:id/")
+	EndServerSpan(span, http.StatusInternalServerError)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans ended, expected 2", len(spans))
+	}
+	for i, span := range spans {
+		if span.Name() != "GET /malls/:id/" || span.SpanKind() != trace.SpanKindServer {
+			t.Errorf("span %d is %s of kind %s, expected GET /malls/:id/ of kind server", i, span.Name(), span.SpanKind())
+		}
+		// the incoming traceparent is not trusted without the propagation
+		if span.Parent().IsValid() || span.SpanContext().TraceID().String() == testTraceID {
+			t.Errorf("span %d continues the trace of the request headers", i)
+		}
+	}
+	checkAttributes(t, spans[0], map[attribute.Key]interface{}{
+		"http.method":      http.MethodGet,
+		"http.route":       "/malls/:id/",
+		"http.target":      "/malls/5/?fields=name",
+		"http.status_code": int64(http.StatusNotFound),
+		"request_id":       "request-1",
+	})
+	// only the server errors fail the span
+	if spans[0].Status().Code != codes.Unset || spans[1].Status().Code != codes.Error {
+		t.Errorf("statuses %s and %s, expected unset and error", spans[0].Status().Code, spans[1].Status().Code)
+	}
+}
+
+func TestServerSpanPropagation(t *testing.T) {
+	recorder := recordSpans(t)
+	setPropagation(t, true)
+
+	ctx, span := StartServerSpan(newTestRequest("request-1"), "/malls/:id/")
+	EndServerSpan(span, http.StatusOK)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans ended, expected 1", len(spans))
+	}
+	server := spans[0]
+	if server.SpanContext().TraceID().String() != testTraceID || server.Parent().SpanID().String() != testParentSpanID {
+		t.Errorf("span is in trace %s under %s, expected trace %s under %s",
+			server.SpanContext().TraceID(), server.Parent().SpanID(), testTraceID, testParentSpanID)
+	}
+	if traceID := TraceID(ctx); traceID != testTraceID {
+		t.Errorf("TraceID is %q, expected %s", traceID, testTraceID)
+	}
+	if traceID := TraceID(context.Background()); traceID != "" {
+		t.Errorf("TraceID without a span is %q, expected empty", traceID)
+	}
+}
+
+func TestClientSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	setPropagation(t, false)
+
+	ctx, server := StartServerSpan(newTestRequest("request-1"), "/malls/:id/")
+	_, query := StartClientSpan(ctx, "GetMallDetails", attribute.String("db.system", "postgresql"))
+	EndSpan(query, nil)
+	_, redis := StartRedisSpan(ctx, "HGETALL", "cache:/malls/5/")
+	EndSpan(redis, errors.New("connection refused"))
+	EndServerSpan(server, http.StatusOK)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans ended, expected 3", len(spans))
+	}
+	serverContext := spans[2].SpanContext()
+	for _, span := range spans[:2] {
+		if span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("span %s is of kind %s, expected client", span.Name(), span.SpanKind())
+		}
+		if span.Parent().SpanID() != serverContext.SpanID() || span.SpanContext().TraceID() != serverContext.TraceID() {
+			t.Errorf("span %s is not a child of the request span", span.Name())
+		}
+	}
+	if spans[0].Name() != "GetMallDetails" || spans[0].Status().Code != codes.Unset {
+		t.Errorf("query span is %s with status %s, expected GetMallDetails with unset", spans[0].Name(), spans[0].Status().Code)
+	}
+	if spans[1].Name() != "redis HGETALL" || spans[1].Status().Code != codes.Error || len(spans[1].Events()) != 1 {
+		t.Errorf("redis span is %s with status %s and %d events, expected a failed redis HGETALL with the error event",
+			spans[1].Name(), spans[1].Status().Code, len(spans[1].Events()))
+	}
+	checkAttributes(t, spans[1], map[attribute.Key]interface{}{
+		"db.system":    "redis",
+		"db.operation": "HGETALL",
+		"db.redis.key": "cache:/malls/5/",
+	})
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// default pg_trgm.word_similarity_threshold
+const WordSimilarityThreshold = 0.6
+
+var cyrillicToLatin = strings.NewReplacer(
+	"а", "a", "б", "b", "в", "v", "г", "g", "д", "d", "е", "e", "ё", "e", "ж", "zh", "з", "z",
+	"и", "i", "й", "y", "к", "k", "л", "l", "м", "m", "н", "n", "о", "o", "п", "p", "р", "r",
+	"с", "s", "т", "t", "у", "u", "ф", "f", "х", "h", "ц", "ts", "ч", "ch", "ш", "sh", "щ", "sch",
+	"ъ", "", "ы", "y", "ь", "", "э", "e", "ю", "yu", "я", "ya",
+)
+
+// NameSearchKey is the lower case latin spelling of a name, must match name_search_key in the database.
+func NameSearchKey(name string) string {
+	return cyrillicToLatin.Replace(strings.ToLower(name))
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams of the words in order, the words are padded the pg_trgm way: two spaces in front and one behind.
+func trigrams(s string) []string {
+	var result []string
+	for _, word := range splitWords(s) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			result = append(result, string(runes[i:i+3]))
+		}
+	}
+	return result
+}
+
+// WordSimilarity approximates pg_trgm word_similarity of the search keys:
+// the best trigram similarity between the query and a continuous extent of the name trigrams.
+func WordSimilarity(query, name string) float64 {
+	queryTrigrams := map[string]bool{}
+	for _, trigram := range trigrams(NameSearchKey(query)) {
+		queryTrigrams[trigram] = true
+	}
+	if len(queryTrigrams) == 0 {
+		return 0
+	}
+	nameTrigrams := trigrams(NameSearchKey(name))
+	best := 0.0
+	for i := range nameTrigrams {
+		extent := map[string]bool{}
+		common := 0
+		for _, trigram := range nameTrigrams[i:] {
+			if extent[trigram] {
+				continue
+			}
+			extent[trigram] = true
+			if queryTrigrams[trigram] {
+				common++
+			}
+			similarity := float64(common) / float64(len(queryTrigrams)+len(extent)-common)
+			if similarity > best {
+				best = similarity
+			}
+		}
+	}
+	return best
+}
+
+// MatchName mirrors the name queries: the query key is a part of the name key or the names are similar enough.
+func MatchName(query, name string) (float64, bool) {
+	// rounded like in the queries
+	similarity := math.Round(WordSimilarity(query, name)*1e6) / 1e6
+	matched := strings.Contains(NameSearchKey(name), NameSearchKey(query)) || similarity >= WordSimilarityThreshold
+	return similarity, matched
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"context"
 	"mallfin_api/redisdb"
+	"mallfin_api/tracing"
 	"time"
 
 	"encoding/base64"
@@ -42,7 +44,7 @@ func generateUniqueValue() (string, error) {
 	_, err := rand.Read(b)
 	return base64.StdEncoding.EncodeToString(b), err
 }
-func (d *DistributedMutex) Lock() error {
+func (d *DistributedMutex) Lock(ctx context.Context) (err error) {
 	if d.mutexId != "" {
 		return errors.New("already locked")
 	}
@@ -51,6 +53,10 @@ func (d *DistributedMutex) Lock() error {
 		return err
 	}
 	redisConn := redisdb.GetClient()
+	_, span := tracing.StartRedisSpan(ctx, "SETNX", d.Resource)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
 	for {
 		setted, err := redisConn.SetNX(d.Resource, mutexId, MaxLockTime).Result()
 		if err != nil {
@@ -59,17 +65,23 @@ func (d *DistributedMutex) Lock() error {
 		if setted {
 			break
 		}
-		time.Sleep(DelayTime)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(DelayTime):
+		}
 	}
 	d.mutexId = mutexId
 	return nil
 }
-func (d *DistributedMutex) Unlock() error {
+func (d *DistributedMutex) Unlock(ctx context.Context) error {
 	if d.mutexId == "" {
 		return errors.New("mutex hasn't locked yet")
 	}
 	redisConn := redisdb.GetClient()
+	_, span := tracing.StartRedisSpan(ctx, "EVAL", d.Resource)
 	err := redisConn.Eval(UnlockScript, []string{d.Resource}, d.mutexId).Err()
+	tracing.EndSpan(span, err)
 	d.mutexId = ""
 	return err
 }